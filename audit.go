@@ -0,0 +1,99 @@
+package osinredis
+
+import (
+	"sync"
+	"time"
+)
+
+// WithAuditStream makes the storage append an entry to the Redis Stream
+// "<prefix>:audit" for every client created or deleted and every access data
+// saved or revoked, including revocations by PurgeClient, RemoveByTag and
+// rotation. Entries hold the fields op ("created" or "revoked"), namespace
+// ("client" or "access"), id (the client ID or the access ID), client_id and
+// time (RFC 3339 with nanoseconds), and never a token or secret; consumers
+// read them with XREAD or XRANGE. The stream lives independently of the
+// records it describes and is trimmed to about maxLen entries, or kept whole
+// if maxLen is 0.
+//
+// Entries are appended in order by a single background writer after the
+// operation succeeded, so auditing never delays or fails the operation and
+// takes at most one connection at a time. Up to 1024 entries wait for the
+// writer; further entries are dropped while it is that far behind. Dropped
+// entries and failed appends are logged, and entries still waiting when the
+// process exits are lost.
+func WithAuditStream(maxLen int) Option {
+	if maxLen < 0 {
+		panic("osinredis: negative audit stream length")
+	}
+	return func(s *Storage) {
+		s.auditWriter = &auditWriter{queue: make(chan auditEntry, auditQueueSize)}
+		s.auditMaxLen = maxLen
+	}
+}
+
+// auditQueueSize is how many audit entries wait for the writer before further
+// ones are dropped.
+const auditQueueSize = 1024
+
+// auditWriter appends the audit entries of a Storage and its copies one at a
+// time, in the order they were queued.
+type auditWriter struct {
+	start sync.Once
+	queue chan auditEntry
+}
+
+// auditEntry is an event to append to the audit stream of storage.
+type auditEntry struct {
+	storage *Storage
+	event   Event
+}
+
+func (w *auditWriter) run() {
+	for entry := range w.queue {
+		entry.storage.writeAudit(entry.event)
+	}
+}
+
+// appendAudit queues event for the audit stream, or drops it if the queue is
+// full. Loads are not audited.
+func (s *Storage) appendAudit(event Event) {
+	if event.Op == EventLoaded || (event.Namespace != "client" && event.Namespace != "access") {
+		return
+	}
+
+	// The operation's context may end as soon as it returns.
+	detached := *s
+	detached.ctx = nil
+
+	w := s.auditWriter
+	w.start.Do(func() { go w.run() })
+	select {
+	case w.queue <- auditEntry{&detached, event}:
+	default:
+		s.logger.Log(LevelWarn, "audit queue full, dropping entry", "op", string(event.Op), "namespace", event.Namespace, "id", event.ID)
+	}
+}
+
+func (s *Storage) writeAudit(event Event) {
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		s.logger.Log(LevelWarn, "failed to append audit entry", "err", err)
+		return
+	}
+
+	defer conn.Close()
+
+	args := []interface{}{s.makeNamespaceKey("audit")}
+	if s.auditMaxLen > 0 {
+		args = append(args, "MAXLEN", "~", s.auditMaxLen)
+	}
+	args = append(args, "*",
+		"op", string(event.Op),
+		"namespace", event.Namespace,
+		"id", event.ID,
+		"client_id", event.ClientID,
+		"time", event.Time.UTC().Format(time.RFC3339Nano))
+	if _, err := conn.Do("XADD", args...); err != nil {
+		s.logger.Log(LevelWarn, "failed to append audit entry", "err", err)
+	}
+}
@@ -0,0 +1,103 @@
+package osinredis
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithAuditStream(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithAuditStream(1000))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	assert.NoError(t, storage.DeleteClient(client))
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	var entries []map[string]string
+	assert.Eventually(t, func() bool {
+		replies, err := redis.Values(conn.Do("XRANGE", storage.makeNamespaceKey("audit"), "-", "+"))
+		if err != nil {
+			return false
+		}
+		entries = entries[:0]
+		for _, reply := range replies {
+			entry, _ := redis.Values(reply, nil)
+			fields, _ := redis.StringMap(entry[1], nil)
+			entries = append(entries, fields)
+		}
+		return len(entries) == 4
+	}, time.Second, 10*time.Millisecond)
+
+	var got [][2]string
+	for _, entry := range entries {
+		assert.Equal(t, client.GetId(), entry["client_id"])
+		assert.NotEmpty(t, entry["id"])
+		_, err := time.Parse(time.RFC3339Nano, entry["time"])
+		assert.NoError(t, err)
+		for _, value := range entry {
+			assert.NotEqual(t, accessData.AccessToken, value)
+			assert.NotEqual(t, client.Secret, value)
+		}
+		got = append(got, [2]string{entry["op"], entry["namespace"]})
+	}
+	assert.Equal(t, [][2]string{
+		{"created", "client"},
+		{"created", "access"},
+		{"revoked", "access"},
+		{"revoked", "client"},
+	}, got)
+}
+
+func TestWithAuditStreamOverflow(t *testing.T) {
+	flushAll()
+
+	var dropped int32
+	storage := New(pool, "test123", WithAuditStream(0), WithLogger(LoggerFunc(func(level Level, msg string, keyvals ...interface{}) {
+		if msg == "audit queue full, dropping entry" {
+			atomic.AddInt32(&dropped, 1)
+		}
+	})))
+	// Hold the writer up until the queue overflowed.
+	blocked := make(chan struct{})
+	storage.connFunc = func() redis.Conn {
+		<-blocked
+		return pool.Get()
+	}
+
+	total := auditQueueSize + 2
+	for i := 0; i < total; i++ {
+		storage.appendAudit(Event{Op: EventCreated, Namespace: "client", ID: fmt.Sprint(i), Time: time.Now()})
+	}
+	close(blocked)
+	assert.True(t, atomic.LoadInt32(&dropped) >= 1)
+
+	conn := pool.Get()
+	defer conn.Close()
+	assert.Eventually(t, func() bool {
+		n, err := redis.Int(conn.Do("XLEN", storage.makeNamespaceKey("audit")))
+		return err == nil && n == total-int(atomic.LoadInt32(&dropped))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Entries are appended in the order they were queued.
+	replies, err := redis.Values(conn.Do("XRANGE", storage.makeNamespaceKey("audit"), "-", "+"))
+	assert.NoError(t, err)
+	for i, reply := range replies {
+		entry, _ := redis.Values(reply, nil)
+		fields, _ := redis.StringMap(entry[1], nil)
+		assert.Equal(t, fmt.Sprint(i), fields["id"])
+	}
+}
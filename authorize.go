@@ -0,0 +1,267 @@
+package osinredis
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// ErrCodeExists is returned by SaveAuthorizeNX when the authorize code is
+// already stored, and by SaveAuthorize with WithCodeReuseCheck when it was
+// used before.
+var ErrCodeExists = errors.New("authorize code already exists")
+
+// ErrCodeRevoked is returned by LoadAuthorize and LoadAndRemoveAuthorize for
+// a code that was removed by RemoveAuthorize or redeemed by
+// LoadAndRemoveAuthorize within the last revokedCodeTTL. It also matches
+// ErrNotFound with errors.Is, so callers that only tell stored codes from
+// missing ones are unaffected.
+var ErrCodeRevoked error = codeRevokedError{}
+
+type codeRevokedError struct{}
+
+func (codeRevokedError) Error() string { return "authorize code revoked" }
+
+func (codeRevokedError) Is(target error) bool { return target == ErrNotFound }
+
+// revokedCodeTTL is how long a removed authorize code is remembered. It
+// matches the ten minute upper bound RFC 6749 recommends for code lifetimes,
+// so the marker outlives any code it stands in for.
+const revokedCodeTTL = 10 * time.Minute
+
+// removeAuthorizeScript deletes the authorize data KEYS[1] and its challenge
+// hash KEYS[2] and, if the authorize data existed, marks the code revoked in
+// KEYS[3] for ARGV[1] seconds. It returns the number of authorize data it
+// deleted. Running both steps in one script means a retried RemoveAuthorize
+// never finds the code deleted but unmarked. The keys share a hash slot only
+// when WithClusterHashTag is set.
+var removeAuthorizeScript = redis.NewScript(3, `
+local removed = redis.call("DEL", KEYS[1])
+redis.call("DEL", KEYS[2])
+if removed > 0 then
+	redis.call("SET", KEYS[3], "1", "EX", ARGV[1])
+end
+return removed
+`)
+
+// loadRemoveAuthorizeScript returns the authorize data KEYS[1] and, if it
+// existed, deletes it and its challenge hash KEYS[2] and marks the code
+// revoked in KEYS[3] for ARGV[1] seconds. Running the three steps in one
+// script means a code is never redeemed twice, nor left deleted but unmarked
+// when the connection drops between them. The keys share a hash slot only
+// when WithClusterHashTag is set.
+var loadRemoveAuthorizeScript = redis.NewScript(3, `
+local data = redis.call("GET", KEYS[1])
+if data then
+	redis.call("DEL", KEYS[1], KEYS[2])
+	redis.call("SET", KEYS[3], "1", "EX", ARGV[1])
+end
+return data
+`)
+
+// WithCodeReuseCheck makes SaveAuthorize refuse, with ErrCodeExists, to save a
+// code that is still stored or was removed or redeemed within the last ten
+// minutes, rather than overwriting it, and log the collision as a warning. A
+// code generator handing out the same code twice then fails the second grant
+// instead of replacing or reviving the first. Unlike SaveAuthorizeNX the check
+// covers codes already exchanged for an access token.
+func WithCodeReuseCheck() Option {
+	return func(s *Storage) {
+		s.rejectCodeReuse = true
+	}
+}
+
+// saveUnusedAuthorize stores the encoded authorize data like SaveAuthorize,
+// unless its code is stored or marked revoked, see WithCodeReuseCheck. The
+// check and the write run in a MULTI/EXEC transaction guarded by WATCH, so
+// concurrent saves of the same code cannot both succeed.
+func (s *Storage) saveUnusedAuthorize(conn redis.Conn, data *osin.AuthorizeData, ttl int64, payload []byte) error {
+	key := s.tokenKey("auth", data.Code)
+	revokedKey := s.tokenKey("auth_revoked", data.Code)
+	if _, err := conn.Do("WATCH", key, revokedKey); err != nil {
+		return errors.Wrap(err, "failed to watch auth")
+	}
+	used, err := redis.Int(conn.Do("EXISTS", key, revokedKey))
+	if err != nil {
+		conn.Do("UNWATCH")
+		return errors.Wrap(err, "unable to check auth")
+	}
+	if used > 0 {
+		conn.Do("UNWATCH")
+		s.logger.Log(LevelWarn, "authorize code reused", "key", s.tokenLogKey("auth", data.Code))
+		return ErrCodeExists
+	}
+
+	conn.Send("MULTI")
+	conn.Send("SETEX", key, ttl, string(payload))
+	s.sendSaveChallenge(conn, data, ttl)
+	s.sendIndexAuthorize(conn, data)
+	_, err = exec(conn)
+	if err == errTransactionAborted {
+		s.logger.Log(LevelWarn, "authorize code reused", "key", s.tokenLogKey("auth", data.Code))
+		return ErrCodeExists
+	}
+	return errors.Wrap(err, "failed to set auth")
+}
+
+// authorizeMiss returns the error for a code whose auth key does not exist:
+// ErrCodeRevoked if code was removed recently and ErrNotFound otherwise. An
+// expired code cannot be told apart from one that was never stored.
+func (s *Storage) authorizeMiss(conn redis.Conn, code string) error {
+	revoked, err := redis.Bool(conn.Do("EXISTS", s.tokenKey("auth_revoked", code)))
+	if err != nil {
+		return errors.Wrap(err, "unable to check auth revocation")
+	}
+	if revoked {
+		return errors.Wrap(ErrCodeRevoked, "auth removed")
+	}
+	return errors.Wrap(ErrNotFound, "auth not stored")
+}
+
+// SaveAuthorizeNX saves authorize data like SaveAuthorize, but only if no
+// authorize data is stored under the same code yet. Otherwise it leaves the
+// stored data untouched and returns ErrCodeExists.
+func (s *Storage) SaveAuthorizeNX(data *osin.AuthorizeData) (err error) {
+	defer s.observe("SaveAuthorizeNX", time.Now(), &err)
+	s, span := s.startSpan("SaveAuthorizeNX", "SET", "auth")
+	defer span.end(&err)
+
+	if err := checkAuthorizeCode(data); err != nil {
+		return err
+	}
+	ttl, err := s.authorizeExpiry(data)
+	if err != nil {
+		return err
+	}
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	payload, err := s.encode(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode data")
+	}
+
+	reply, err := conn.Do("SET", s.tokenKey("auth", data.Code), string(payload), "NX", "EX", ttl)
+	if err != nil {
+		return errors.Wrap(err, "failed to set auth")
+	}
+	if reply == nil {
+		return ErrCodeExists
+	}
+	// The challenge hash and the user index entry cannot be made conditional
+	// on the SET, so they are written once the code is claimed.
+	// GetAuthorizeChallenge falls back to the authorize data until then.
+	if s.sendSaveChallenge(conn, data, ttl)+s.sendIndexAuthorize(conn, data) > 0 {
+		if _, err := flush(conn); err != nil {
+			return errors.Wrap(err, "failed to set auth challenge")
+		}
+	}
+	s.emitAuthorize(EventCreated, data)
+	return nil
+}
+
+// LoadAndRemoveAuthorize loads the authorize data of code and deletes it in
+// one atomic step, so a code can be redeemed only once even under concurrent
+// requests. The load, the removal of its PKCE challenge and the revocation
+// marker run in one Lua script. Like LoadAuthorize it returns ErrCodeRevoked
+// if the code was already redeemed or removed, ErrNotFound if it does not
+// exist, and ErrNotFound if its client does not exist; the code is removed
+// either way.
+func (s *Storage) LoadAndRemoveAuthorize(code string) (_ *osin.AuthorizeData, err error) {
+	defer s.observe("LoadAndRemoveAuthorize", time.Now(), &err)
+	s, span := s.startSpan("LoadAndRemoveAuthorize", "EVALSHA", "auth")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	rawAuthGob, err := loadRemoveAuthorizeScript.Do(conn,
+		s.tokenKey("auth", code),
+		s.tokenKey("auth_challenge", code),
+		s.tokenKey("auth_revoked", code),
+		int64(revokedCodeTTL/time.Second))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load and remove auth")
+	}
+
+	auth, err := s.decodeAuthorize(conn, code, rawAuthGob)
+	if err != nil {
+		return nil, err
+	}
+	s.emitAuthorize(EventRevoked, auth)
+	return auth, nil
+}
+
+// TouchAuthorize resets the expiry of the authorize data of code to ttl,
+// rounded down to whole seconds, without rewriting it. It returns ErrNotFound
+// if the code does not exist, and an error for a ttl under a second, which
+// would delete the code instead.
+func (s *Storage) TouchAuthorize(code string, ttl time.Duration) (err error) {
+	defer s.observe("TouchAuthorize", time.Now(), &err)
+	s, span := s.startSpan("TouchAuthorize", "EXPIRE", "auth")
+	defer span.end(&err)
+
+	seconds := int64(ttl / time.Second)
+	if seconds <= 0 {
+		return errors.New("ttl must be at least one second")
+	}
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	conn.Send("EXPIRE", s.tokenKey("auth", code), seconds)
+	conn.Send("EXPIRE", s.tokenKey("auth_challenge", code), seconds)
+	replies, err := flush(conn)
+	if err != nil {
+		return errors.Wrap(err, "failed to extend auth")
+	}
+	ok, _ := redis.Bool(replies[0], nil)
+	if !ok {
+		return errors.Wrap(ErrNotFound, "auth not stored")
+	}
+	return nil
+}
+
+// AuthorizeExists reports whether authorize data is stored under code,
+// without decoding it or loading its client, for example to poll whether a
+// code is still pending or to reject unknown codes early. The code of
+// authorize data that expired, was removed or was exchanged is reported as
+// missing.
+func (s *Storage) AuthorizeExists(code string) (_ bool, err error) {
+	defer s.observe("AuthorizeExists", time.Now(), &err)
+	s, span := s.startSpan("AuthorizeExists", "EXISTS", "auth")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return false, err
+	}
+
+	defer conn.Close()
+
+	exists, err := redis.Bool(conn.Do("EXISTS", s.tokenKey("auth", code)))
+	return exists, errors.Wrap(err, "failed to check auth existence")
+}
+
+// isUnknownCommand reports whether err is the error reply of a server that
+// does not implement the command.
+func isUnknownCommand(err error) bool {
+	redisErr, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(redisErr), "ERR unknown command")
+}
@@ -0,0 +1,239 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAndRemoveAuthorize(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	loadData, err := storage.LoadAndRemoveAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAuthorizeData(loadData, authorizeData))
+
+	loadData, err = storage.LoadAndRemoveAuthorize(authorizeData.Code)
+	assert.Nil(t, loadData)
+	assert.True(t, errors.Is(err, ErrCodeRevoked))
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = storage.LoadAuthorize(authorizeData.Code)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestLoadAndRemoveAuthorizeLostReply(t *testing.T) {
+	flushAll()
+
+	client := newClient()
+	assert.NoError(t, initTestStorage().CreateClient(client))
+	assert.NoError(t, initTestStorage().SaveAuthorize(newAuthorizeData(client)))
+
+	conn := pool.Get()
+	assert.NoError(t, loadRemoveAuthorizeScript.Load(conn))
+	conn.Close()
+
+	var evals int
+	storage := New(pool, "test123")
+	storage.connFunc = func() redis.Conn {
+		return lostReplyConn{pool.Get(), "EVALSHA", &evals}
+	}
+	_, err := storage.LoadAndRemoveAuthorize("8888")
+	assert.Error(t, err)
+	assert.Equal(t, 1, evals)
+
+	// The code was deleted and marked revoked although the reply was lost.
+	_, err = initTestStorage().LoadAuthorize("8888")
+	assert.True(t, errors.Is(err, ErrCodeRevoked))
+	exists, err := initTestStorage().AuthorizeExists("8888")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestLoadAuthorizeRevokedVersusMissing(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+	assert.NoError(t, storage.RemoveAuthorize(authorizeData.Code))
+
+	_, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.True(t, errors.Is(err, ErrCodeRevoked))
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = storage.LoadAuthorize("neverStored")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrCodeRevoked))
+
+	// Removing an unknown code must not leave a marker behind.
+	assert.NoError(t, storage.RemoveAuthorize("neverStored"))
+	_, err = storage.LoadAuthorize("neverStored")
+	assert.False(t, errors.Is(err, ErrCodeRevoked))
+}
+
+func TestSaveAuthorizeNX(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorizeNX(authorizeData))
+
+	clobber := newAuthorizeData(client)
+	clobber.RedirectUri = "http://localhost/other"
+	assert.Equal(t, ErrCodeExists, storage.SaveAuthorizeNX(clobber))
+
+	loadData, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAuthorizeData(loadData, authorizeData))
+
+	// SaveAuthorize still overwrites.
+	assert.NoError(t, storage.SaveAuthorize(clobber))
+	loadData, err = storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, clobber.RedirectUri, loadData.RedirectUri)
+}
+
+func TestWithCodeReuseCheck(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithCodeReuseCheck())
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	authorizeData.CodeChallenge = "challenge"
+	authorizeData.CodeChallengeMethod = "S256"
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+	challenge, _, err := storage.GetAuthorizeChallenge(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, "challenge", challenge)
+
+	clobber := newAuthorizeData(client)
+	clobber.RedirectUri = "http://localhost/other"
+	assert.Equal(t, ErrCodeExists, storage.SaveAuthorize(clobber))
+	loadData, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, authorizeData.RedirectUri, loadData.RedirectUri)
+
+	// A redeemed code cannot be saved again either.
+	_, err = storage.LoadAndRemoveAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrCodeExists, storage.SaveAuthorize(clobber))
+
+	other := newAuthorizeData(client)
+	other.Code = "other"
+	assert.NoError(t, storage.SaveAuthorize(other))
+}
+
+func TestTouchAuthorize(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	assert.NoError(t, storage.TouchAuthorize(authorizeData.Code, time.Hour))
+
+	conn := pool.Get()
+	defer conn.Close()
+	ttl, err := redis.Int(conn.Do("TTL", storage.makeKey("auth", authorizeData.Code)))
+	assert.NoError(t, err)
+	assert.InDelta(t, 3600, ttl, 5)
+
+	assert.True(t, errors.Is(storage.TouchAuthorize("missing", time.Hour), ErrNotFound))
+
+	// A ttl under a second is refused rather than deleting the code.
+	assert.Error(t, storage.TouchAuthorize(authorizeData.Code, 500*time.Millisecond))
+	exists, err := storage.AuthorizeExists(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestWithAuthorizeTTL(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithAuthorizeTTL(time.Minute))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	conn := pool.Get()
+	defer conn.Close()
+	authorizeData := newAuthorizeData(client)
+	authorizeData.CodeChallenge = "challenge"
+	authorizeData.CodeChallengeMethod = "plain"
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+	for _, key := range []string{"test123:auth:8888", "test123:auth_challenge:8888"} {
+		ttl, err := redis.Int(conn.Do("TTL", key))
+		assert.NoError(t, err)
+		assert.InDelta(t, 60, ttl, 1, key)
+	}
+
+	loaded, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, authorizeData.ExpiresIn, loaded.ExpiresIn)
+
+	other := newAuthorizeData(client)
+	other.Code = "9999"
+	other.ExpiresIn = 0
+	assert.NoError(t, storage.SaveAuthorizeNX(other))
+	ttl, err := redis.Int(conn.Do("TTL", "test123:auth:9999"))
+	assert.NoError(t, err)
+	assert.InDelta(t, 60, ttl, 1)
+
+	// Without the option a code that does not expire is refused.
+	other.Code = "7777"
+	assert.Error(t, initTestStorage().SaveAuthorize(other))
+	assert.Error(t, initTestStorage().SaveAuthorizeNX(other))
+	exists, err := redis.Bool(conn.Do("EXISTS", "test123:auth:7777"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.Panics(t, func() { WithAuthorizeTTL(-time.Second) })
+	assert.Panics(t, func() { WithAuthorizeTTL(time.Millisecond) })
+}
+
+func TestAuthorizeExists(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	exists, err := storage.AuthorizeExists("8888")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.NoError(t, storage.SaveAuthorize(newAuthorizeData(client)))
+	exists, err = storage.AuthorizeExists("8888")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.NoError(t, storage.RemoveAuthorize("8888"))
+	exists, err = storage.AuthorizeExists("8888")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
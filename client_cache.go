@@ -0,0 +1,129 @@
+package osinredis
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/openshift/osin"
+)
+
+// WithClientCache makes GetClient keep up to size recently used clients in
+// process memory for ttl, so that popular clients are not read from Redis on
+// every request. Clients not found are not cached.
+//
+// CreateClient, CreateClients, UpdateClient, UpdateClientCAS, DeleteClient and
+// PurgeClient drop the entry of the client they write. Writes made by other
+// processes, or directly in Redis, are not seen until the entry expires, so a
+// client may be returned up to ttl after it was changed or deleted elsewhere.
+// Only GetClient reads the cache; clients embedded in loaded authorize and
+// access data are always read from Redis. WithClientCache panics if size or
+// ttl is not positive.
+func WithClientCache(size int, ttl time.Duration) Option {
+	if size <= 0 {
+		panic("osinredis: client cache size must be positive")
+	}
+	if ttl <= 0 {
+		panic("osinredis: client cache TTL must be positive")
+	}
+	return func(s *Storage) {
+		s.clientCache = newClientCache(size, ttl)
+	}
+}
+
+func newClientCache(size int, ttl time.Duration) *clientCache {
+	return &clientCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// clientCache is a least recently used cache of decoded clients.
+type clientCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // of *clientCacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type clientCacheEntry struct {
+	id      string
+	client  osin.DefaultClient
+	expires time.Time
+}
+
+// get returns a copy of the cached client with the given ID, if it has not
+// expired by now.
+func (c *clientCache) get(id string, now time.Time) (*osin.DefaultClient, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*clientCacheEntry)
+	if !now.Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, id)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	client := entry.client
+	return &client, true
+}
+
+// put caches a copy of client, evicting the least recently used client if the
+// cache is full. Clients of a type other than *osin.DefaultClient or
+// *HashedSecretClient are not cached.
+func (c *clientCache) put(client osin.Client, now time.Time) {
+	if c == nil {
+		return
+	}
+	if hashed, ok := client.(*HashedSecretClient); ok {
+		client = &hashed.DefaultClient
+	}
+	defaultClient, ok := client.(*osin.DefaultClient)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &clientCacheEntry{id: client.GetId(), client: *defaultClient, expires: now.Add(c.ttl)}
+	if elem, ok := c.entries[entry.id]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.id] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*clientCacheEntry).id)
+	}
+}
+
+// remove drops the client with the given ID from the cache.
+func (c *clientCache) remove(id string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[id]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, id)
+	}
+}
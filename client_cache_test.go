@@ -0,0 +1,65 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithClientCache(t *testing.T) {
+	flushAll()
+
+	now := time.Now()
+	storage := New(pool, "test123", WithClientCache(1, time.Minute), WithClock(func() time.Time { return now }))
+	var commands []string
+	storage.connFunc = func() redis.Conn {
+		return recordingConn{pool.Get(), &commands}
+	}
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	commands = nil
+	for i := 0; i < 3; i++ {
+		loaded, err := storage.GetClient(client.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, client, loaded)
+	}
+	assert.Equal(t, []string{"GET"}, commands)
+
+	// Writes through the Storage invalidate the entry.
+	client.RedirectUri = "http://localhost/updated"
+	assert.NoError(t, storage.UpdateClient(client))
+	loaded, err := storage.GetClient(client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, client, loaded)
+
+	// Writes made elsewhere are seen once the entry expires.
+	other := New(pool, "test123")
+	assert.NoError(t, other.DeleteClient(client))
+	_, err = storage.GetClient(client.Id)
+	assert.NoError(t, err)
+	now = now.Add(time.Minute)
+	_, err = storage.GetClient(client.Id)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// The least recently used client is evicted.
+	second := &osin.DefaultClient{Id: "second", Secret: "secret", RedirectUri: "http://localhost/"}
+	assert.NoError(t, storage.CreateClient(client))
+	assert.NoError(t, storage.CreateClient(second))
+	_, err = storage.GetClient(client.Id)
+	assert.NoError(t, err)
+	_, err = storage.GetClient(second.Id)
+	assert.NoError(t, err)
+	commands = nil
+	_, err = storage.GetClient(client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET"}, commands)
+
+	assert.Panics(t, func() { WithClientCache(0, time.Minute) })
+	assert.Panics(t, func() { WithClientCache(1, 0) })
+}
@@ -0,0 +1,125 @@
+package osinredis
+
+import (
+	"crypto/subtle"
+	"time"
+
+	"github.com/openshift/osin"
+)
+
+// WithSecretHasher makes CreateClient, UpdateClient and CreateClients store
+// hash(secret) in place of each client's plaintext secret; public clients,
+// without a secret, keep an empty one. Clients returned by GetClient, and
+// embedded in loaded authorize and access data, are *HashedSecretClient
+// values carrying the same ID, redirect URI and user data, whose GetSecret
+// returns the hash but which osin.Server authenticates with the plaintext
+// secret. Outside of osin, check secrets with ValidateClientSecret rather
+// than by comparing GetSecret. hash must be deterministic; clients stored
+// before the hasher was set have to be stored again.
+func WithSecretHasher(hash func(plaintext string) string) Option {
+	if hash == nil {
+		panic("osinredis: nil secret hasher")
+	}
+	return func(s *Storage) {
+		s.secretHasher = hash
+	}
+}
+
+// ValidateClientSecret reports whether secret is the secret of the stored
+// client with the given ID, hashing it first if WithSecretHasher is set. It
+// returns ErrNotFound if there is no such client.
+func (s *Storage) ValidateClientSecret(id, secret string) (_ bool, err error) {
+	defer s.observe("ValidateClientSecret", time.Now(), &err)
+	s, span := s.startSpan("ValidateClientSecret", "GET", "client")
+	defer span.end(&err)
+
+	client, err := s.GetClient(id)
+	if err != nil {
+		return false, err
+	}
+
+	if hashed, ok := client.(*HashedSecretClient); ok {
+		return hashed.ClientSecretMatches(secret), nil
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(client.GetSecret())) == 1, nil
+}
+
+// HashedSecretClient is a client stored with WithSecretHasher. Its Secret
+// holds the hashed secret, and it implements osin.ClientSecretMatcher by
+// hashing the secret presented before comparing, so that osin.Server
+// authenticates it with the plaintext secret. A client without a secret is
+// public and only matches an empty secret.
+type HashedSecretClient struct {
+	osin.DefaultClient
+	hash func(plaintext string) string
+}
+
+// ClientSecretMatches reports whether secret hashes to the stored secret.
+func (c *HashedSecretClient) ClientSecretMatches(secret string) bool {
+	if c.Secret == "" {
+		return secret == ""
+	}
+	if c.hash != nil {
+		secret = c.hash(secret)
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) == 1
+}
+
+// hashedClient returns client as a *HashedSecretClient if WithSecretHasher is
+// set, and as is otherwise.
+func (s *Storage) hashedClient(client *osin.DefaultClient) osin.Client {
+	if s.secretHasher == nil {
+		return client
+	}
+	return &HashedSecretClient{DefaultClient: *client, hash: s.secretHasher}
+}
+
+// attachSecretHasher turns the clients embedded in v, decoded authorize or
+// access data, into *HashedSecretClient values if WithSecretHasher is set.
+func (s *Storage) attachSecretHasher(v interface{}) {
+	if s.secretHasher == nil {
+		return
+	}
+	switch v := v.(type) {
+	case *osin.AuthorizeData:
+		v.Client = s.hashedEmbeddedClient(v.Client)
+	case *osin.AccessData:
+		v.Client = s.hashedEmbeddedClient(v.Client)
+		if v.AuthorizeData != nil {
+			s.attachSecretHasher(v.AuthorizeData)
+		}
+		if v.AccessData != nil {
+			s.attachSecretHasher(v.AccessData)
+		}
+	}
+}
+
+func (s *Storage) hashedEmbeddedClient(client osin.Client) osin.Client {
+	switch client := client.(type) {
+	case *HashedSecretClient:
+		client.hash = s.secretHasher
+		return client
+	case *osin.DefaultClient:
+		return s.hashedClient(client)
+	}
+	return client
+}
+
+// encodeClient encodes client for storage, replacing its secret with the
+// hashed secret if WithSecretHasher is set. A *HashedSecretClient read back
+// from the storage is stored again as is.
+func (s *Storage) encodeClient(client osin.Client) ([]byte, error) {
+	if s.secretHasher == nil {
+		return s.encode(client)
+	}
+	secret := client.GetSecret()
+	if _, hashed := client.(*HashedSecretClient); !hashed && secret != "" {
+		secret = s.secretHasher(secret)
+	}
+	return s.encode(&osin.DefaultClient{
+		Id:          client.GetId(),
+		Secret:      secret,
+		RedirectUri: client.GetRedirectUri(),
+		UserData:    client.GetUserData(),
+	})
+}
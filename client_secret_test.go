@@ -0,0 +1,59 @@
+package osinredis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sha256Hex(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestWithSecretHasher(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithSecretHasher(sha256Hex))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, sha256Hex(client.GetSecret()), clientFound.GetSecret())
+	assert.Equal(t, client.GetRedirectUri(), clientFound.GetRedirectUri())
+
+	ok, err := storage.ValidateClientSecret(client.GetId(), client.GetSecret())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = storage.ValidateClientSecret(client.GetId(), clientFound.GetSecret())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = storage.ValidateClientSecret("unknown", client.GetSecret())
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.Panics(t, func() { WithSecretHasher(nil) })
+}
+
+func TestValidateClientSecretPlaintext(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	ok, err := storage.ValidateClientSecret(client.GetId(), client.GetSecret())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = storage.ValidateClientSecret(client.GetId(), "wrong")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
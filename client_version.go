@@ -0,0 +1,94 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// ErrVersionConflict is returned by UpdateClientCAS when the client changed
+// since the expected version was read.
+var ErrVersionConflict = errors.New("client version conflict")
+
+// GetClientVersion returns the version of the client with the given ID, a
+// counter bumped by every write of the client, or 0 for clients never
+// written through a versioning Storage. Read the version before the client
+// itself, so that a write in between makes the later UpdateClientCAS fail
+// rather than go unnoticed.
+func (s *Storage) GetClientVersion(id string) (_ int64, err error) {
+	defer s.observe("GetClientVersion", time.Now(), &err)
+	s, span := s.startSpan("GetClientVersion", "GET", "client_version")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	version, err := redis.Int64(conn.Do("GET", s.makeKey("client_version", id)))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return version, errors.Wrap(err, "unable to get client version")
+}
+
+// UpdateClientCAS updates a client like UpdateClient, but only if its
+// version, as returned by GetClientVersion, is still expectedVersion. It
+// returns ErrVersionConflict otherwise, so that concurrent edits of the same
+// client cannot silently overwrite each other. The check and the write run in
+// a single MULTI/EXEC guarded by WATCH on the version.
+func (s *Storage) UpdateClientCAS(client osin.Client, expectedVersion int64) (err error) {
+	defer s.observe("UpdateClientCAS", time.Now(), &err)
+	s, span := s.startSpan("UpdateClientCAS", "MULTI", "client")
+	defer span.end(&err)
+
+	payload, err := s.encodeClient(client)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode client")
+	}
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	versionKey := s.makeKey("client_version", client.GetId())
+	if _, err := conn.Do("WATCH", versionKey); err != nil {
+		return errors.Wrap(err, "failed to watch client version")
+	}
+
+	version, err := redis.Int64(conn.Do("GET", versionKey))
+	if err != nil && err != redis.ErrNil {
+		conn.Do("UNWATCH")
+		return errors.Wrap(err, "unable to get client version")
+	}
+	if version != expectedVersion {
+		conn.Do("UNWATCH")
+		return errors.Wrapf(ErrVersionConflict, "client is at version %d, not %d", version, expectedVersion)
+	}
+
+	previous, err := s.storedRedirectURIs(conn, []string{client.GetId()})
+	if err != nil {
+		conn.Do("UNWATCH")
+		return err
+	}
+
+	conn.Send("MULTI")
+	s.sendSaveClient(conn, client, payload, previous[0])
+	_, err = exec(conn)
+	s.clientCache.remove(client.GetId())
+	if err != nil {
+		if err == errTransactionAborted {
+			return errors.Wrap(ErrVersionConflict, "client changed during the update")
+		}
+		return errors.Wrap(err, "failed to update client")
+	}
+	s.emit(EventCreated, "client", client.GetId(), client.GetId())
+	return nil
+}
@@ -0,0 +1,46 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateClientCAS(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	version, err := storage.GetClientVersion("clientID")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), version)
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	version, err = storage.GetClientVersion(client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), version)
+
+	client.Secret = "first"
+	assert.NoError(t, storage.UpdateClientCAS(client, version))
+
+	// A second edit based on the same version loses.
+	client.Secret = "second"
+	assert.True(t, errors.Is(storage.UpdateClientCAS(client, version), ErrVersionConflict))
+
+	loaded, err := storage.GetClient(client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", loaded.GetSecret())
+
+	// Blind updates bump the version as well.
+	version, err = storage.GetClientVersion(client.Id)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.UpdateClient(client))
+	assert.True(t, errors.Is(storage.UpdateClientCAS(client, version), ErrVersionConflict))
+
+	assert.NoError(t, storage.DeleteClient(client))
+	version, err = storage.GetClientVersion(client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), version)
+}
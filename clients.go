@@ -0,0 +1,159 @@
+package osinredis
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// ClientErrors is returned by CreateClients when some of the clients could not
+// be stored. It maps client IDs to the error for that client.
+type ClientErrors map[string]error
+
+func (e ClientErrors) Error() string {
+	ids := make([]string, 0, len(e))
+	for id := range e {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	msgs := make([]string, len(ids))
+	for i, id := range ids {
+		msgs[i] = id + ": " + e[id].Error()
+	}
+	return "failed to create clients: " + strings.Join(msgs, "; ")
+}
+
+// CreateClients inserts the given clients in a single pipeline, storing each
+// exactly as CreateClient would. Clients that fail to encode or store are
+// reported in a ClientErrors while the others are still stored.
+func (s *Storage) CreateClients(clients []osin.Client) (err error) {
+	defer s.observe("CreateClients", time.Now(), &err)
+	s, span := s.startSpan("CreateClients", "SET", "client")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if len(clients) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(clients))
+	for i, client := range clients {
+		ids[i] = client.GetId()
+	}
+	previous, err := s.storedRedirectURIs(conn, ids)
+	if err != nil {
+		return err
+	}
+
+	failed := ClientErrors{}
+	var (
+		sent    []string
+		replied []int
+	)
+	for i, client := range clients {
+		payload, err := s.encodeClient(client)
+		if err != nil {
+			failed[client.GetId()] = errors.Wrap(err, "failed to encode client")
+			continue
+		}
+
+		sent = append(sent, client.GetId())
+		replied = append(replied, s.sendSaveClient(conn, client, payload, previous[i]))
+	}
+
+	if len(sent) > 0 {
+		replies, err := redis.Values(conn.Do(""))
+		for _, id := range sent {
+			s.clientCache.remove(id)
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to save clients")
+		}
+		for i, id := range sent {
+			for _, reply := range replies[:replied[i]] {
+				if err, ok := reply.(redis.Error); ok {
+					failed[id] = errors.Wrap(err, "failed to save client")
+				}
+			}
+			replies = replies[replied[i]:]
+		}
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// ClientExists reports whether a client with the given ID is stored, without
+// decoding it.
+func (s *Storage) ClientExists(id string) (_ bool, err error) {
+	defer s.observe("ClientExists", time.Now(), &err)
+	s, span := s.startSpan("ClientExists", "EXISTS", "client")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return false, err
+	}
+
+	defer conn.Close()
+
+	exists, err := redis.Bool(conn.Do("EXISTS", s.makeKey("client", id)))
+	return exists, errors.Wrap(err, "failed to check client existence")
+}
+
+// getClients reads and decodes the clients with the given IDs over conn with
+// a single MGET, fetching each ID once. Clients that do not exist are missing
+// from the returned map.
+func (s *Storage) getClients(conn redis.Conn, ids []string) (map[string]osin.Client, error) {
+	clients := make(map[string]osin.Client, len(ids))
+	var (
+		unique []string
+		keys   []interface{}
+	)
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+			keys = append(keys, s.makeKey("client", id))
+		}
+	}
+	if len(keys) == 0 {
+		return clients, nil
+	}
+
+	clientGobs, err := redis.ByteSlices(conn.Do("MGET", keys...))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get client gobs")
+	}
+
+	for i, clientGob := range clientGobs {
+		key := keys[i].(string)
+		if clientGob == nil {
+			s.logger.Log(LevelDebug, "client miss", "key", key)
+			continue
+		}
+
+		var client osin.DefaultClient
+		if err := s.decodeRecord(key, clientGob, &client); err != nil {
+			s.logger.Log(LevelWarn, "failed to decode client", "key", key, "err", err)
+			return nil, errors.Wrap(err, "failed to decode client gob")
+		}
+		s.logger.Log(LevelDebug, "client hit", "key", key)
+		clients[unique[i]] = s.hashedClient(&client)
+	}
+	return clients, nil
+}
@@ -0,0 +1,170 @@
+package osinredis
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateClients(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	var clients []osin.Client
+	for i := 0; i < 10; i++ {
+		clients = append(clients, &osin.DefaultClient{Id: fmt.Sprintf("client%d", i), Secret: "secret", RedirectUri: "http://localhost/"})
+	}
+	assert.NoError(t, storage.CreateClients(clients))
+
+	for _, client := range clients {
+		loadClient, err := storage.GetClient(client.GetId())
+		assert.NoError(t, err)
+		assert.Equal(t, client, loadClient)
+
+		_, err = storage.GetClientCreatedAt(client.GetId())
+		assert.NoError(t, err)
+	}
+}
+
+func TestCreateClientsPartialFailure(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	// Gob cannot encode user data of an unregistered type.
+	bad := &osin.DefaultClient{Id: "bad", UserData: struct{ X int }{1}}
+	err := storage.CreateClients([]osin.Client{newClient(), bad})
+
+	clientErrors, ok := err.(ClientErrors)
+	if assert.True(t, ok) {
+		assert.Len(t, clientErrors, 1)
+		assert.Error(t, clientErrors["bad"])
+	}
+
+	_, err = storage.GetClient(newClient().GetId())
+	assert.NoError(t, err)
+}
+
+func TestClientExists(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	exists, err := storage.ClientExists(client.GetId())
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.NoError(t, storage.CreateClient(client))
+	exists, err = storage.ClientExists(client.GetId())
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestLoadClientTokensFetchesClientsOnce(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	var commands []string
+	storage.connFunc = func() redis.Conn {
+		return recordingConn{pool.Get(), &commands}
+	}
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	for i := 0; i < 5; i++ {
+		accessData := newAccessData(newAuthorizeData(client))
+		accessData.AccessToken = fmt.Sprintf("access%d", i)
+		accessData.RefreshToken = fmt.Sprintf("refresh%d", i)
+		assert.NoError(t, storage.SaveAccess(accessData))
+	}
+
+	commands = nil
+	tokens, err := storage.LoadClientTokens(client.Id)
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 5)
+	for _, token := range tokens {
+		assert.Equal(t, client, token.Client)
+		assert.Equal(t, client, token.AuthorizeData.Client)
+	}
+
+	var mgets int
+	for _, command := range commands {
+		if command == "MGET" {
+			mgets++
+		}
+	}
+	assert.Equal(t, 1, mgets)
+}
+
+func TestLoadAccessFetchesClientsOnce(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	var commands []string
+	storage.connFunc = func() redis.Conn {
+		return recordingConn{pool.Get(), &commands}
+	}
+
+	client := newClient()
+	authClient := newClient()
+	authClient.Id = "authClient"
+	assert.NoError(t, storage.CreateClient(client))
+	assert.NoError(t, storage.CreateClient(authClient))
+	accessData := newAccessData(newAuthorizeData(authClient))
+	accessData.Client = client
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	commands = nil
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, client, loadData.Client)
+	assert.Equal(t, authClient, loadData.AuthorizeData.Client)
+	// Both clients come from one MGET rather than a GET each.
+	var mgets int
+	for _, command := range commands {
+		if command == "MGET" {
+			mgets++
+		}
+	}
+	assert.Equal(t, 1, mgets)
+}
+
+func TestWithClientRefreshOnLoad(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithClientRefreshOnLoad(false))
+	var commands []string
+	storage.connFunc = func() redis.Conn {
+		return recordingConn{pool.Get(), &commands}
+	}
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	updated := *client
+	updated.RedirectUri = "http://example.com/"
+	assert.NoError(t, storage.UpdateClient(&updated))
+
+	commands = nil
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	// The clients are the ones embedded when the token was saved.
+	assert.Equal(t, client.RedirectUri, loadData.Client.GetRedirectUri())
+	assert.Equal(t, client.RedirectUri, loadData.AuthorizeData.Client.GetRedirectUri())
+	assert.NotContains(t, commands, "MGET")
+
+	assert.NoError(t, storage.DeleteClient(client))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+
+	loadData, err = New(pool, "test123", WithClientRefreshOnLoad(true)).LoadAccess(accessData.AccessToken)
+	assert.Error(t, err)
+	assert.Nil(t, loadData)
+}
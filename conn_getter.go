@@ -0,0 +1,84 @@
+package osinredis
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// NewWithConnGetter returns a Storage that obtains its connections from
+// getConn instead of a redis.Pool, for setups such as custom pooling,
+// multiplexed connections or connections scoped to a request by middleware.
+// getConn is called once per operation, with the context bound with
+// WithContext and limited by WithOperationTimeout, and returns the connection
+// together with a function that releases it, which the Storage calls instead
+// of closing the connection. The release function may be nil.
+//
+// An error returned by getConn fails the operation, after being retried as
+// set with WithRetry. The options about pools, WithReadPool, WithPoolSelector
+// and WithFailFast, have no effect, and PoolStats returns zero statistics.
+// NewWithConnGetter panics if getConn is nil.
+func NewWithConnGetter(getConn func(ctx context.Context) (redis.Conn, func(), error), keyPrefix string, opts ...Option) *Storage {
+	if getConn == nil {
+		panic("osinredis: nil conn getter")
+	}
+	s := newStorage(keyPrefix, opts)
+	s.connGetter = getConn
+	return s
+}
+
+// getterConn obtains a connection from the getter passed to
+// NewWithConnGetter.
+func (s *Storage) getterConn() redis.Conn {
+	ctx, cancel := s.context(), context.CancelFunc(func() {})
+	if s.operationTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.operationTimeout)
+	}
+	conn, release, err := s.connGetter(ctx)
+	if err != nil {
+		cancel()
+		// unavailableConn only stands in for the missing connection here;
+		// failedConn reports err.
+		return failedConn{unavailableConn{}, errors.Wrap(err, "failed to get connection")}
+	}
+	return &deadlineConn{Conn: newReleasedConn(conn, release), ctx: ctx, cancel: cancel}
+}
+
+// newReleasedConn wraps conn in a releasedConn, keeping its support for
+// redis.ConnWithContext so that deadlineConn can bound its commands.
+func newReleasedConn(conn redis.Conn, release func()) redis.Conn {
+	released := releasedConn{conn, release}
+	if _, ok := conn.(redis.ConnWithContext); ok {
+		return releasedContextConn{released}
+	}
+	return released
+}
+
+// releasedConn is a connection from the getter passed to NewWithConnGetter,
+// which is released rather than closed.
+type releasedConn struct {
+	redis.Conn
+	release func()
+}
+
+func (c releasedConn) Close() error {
+	if c.release != nil {
+		c.release()
+	}
+	return nil
+}
+
+// releasedContextConn is a releasedConn whose connection implements
+// redis.ConnWithContext.
+type releasedContextConn struct {
+	releasedConn
+}
+
+func (c releasedContextConn) DoContext(ctx context.Context, commandName string, args ...interface{}) (interface{}, error) {
+	return c.Conn.(redis.ConnWithContext).DoContext(ctx, commandName, args...)
+}
+
+func (c releasedContextConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return c.Conn.(redis.ConnWithContext).ReceiveContext(ctx)
+}
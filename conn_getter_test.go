@@ -0,0 +1,89 @@
+package osinredis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+type ctxKey struct{}
+
+func TestNewWithConnGetter(t *testing.T) {
+	flushAll()
+
+	var (
+		acquired, released int
+		tenants            []interface{}
+	)
+	storage := NewWithConnGetter(func(ctx context.Context) (redis.Conn, func(), error) {
+		acquired++
+		tenants = append(tenants, ctx.Value(ctxKey{}))
+		conn := pool.Get()
+		return conn, func() {
+			released++
+			conn.Close()
+		}, nil
+	}, "test123")
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.WithContext(context.WithValue(context.Background(), ctxKey{}, "acme")).SaveAccess(accessData))
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAccessData(loadData, accessData))
+
+	assert.Equal(t, acquired, released)
+	assert.Contains(t, tenants, "acme")
+
+	failing := NewWithConnGetter(func(context.Context) (redis.Conn, func(), error) {
+		return nil, nil, errors.New("no connection for you")
+	}, "test123")
+	_, err = failing.GetClient(client.GetId())
+	assert.EqualError(t, err, "failed to get connection: no connection for you")
+
+	assert.Panics(t, func() { NewWithConnGetter(nil, "test123") })
+}
+
+func TestNewWithConnGetterTimeout(t *testing.T) {
+	// A server that accepts connections but never replies.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	storage := NewWithConnGetter(func(context.Context) (redis.Conn, func(), error) {
+		conn, err := redis.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, func() { conn.Close() }, nil
+	}, "test123", WithOperationTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	_, err = storage.GetClient("1")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = storage.WithContext(ctx).GetClient("1")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
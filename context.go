@@ -0,0 +1,61 @@
+package osinredis
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// WithContext returns a shallow copy of s whose operations run in ctx. The
+// osin.Storage interface has no context parameters, so handlers that want
+// their storage calls to be part of the request's trace call
+// storage.WithContext(r.Context()) and use the copy for that request.
+// Waiting for a pooled connection, and for the replies to commands, stops
+// when ctx is done, failing the operation with an error wrapping ctx.Err().
+func (s *Storage) WithContext(ctx context.Context) *Storage {
+	if ctx == nil {
+		panic("osinredis: nil context")
+	}
+	s2 := *s
+	s2.ctx = ctx
+	return &s2
+}
+
+// WithPoolSelector makes the Storage pick the pool of each operation with
+// selector, called with the context bound with WithContext, or
+// context.Background without one. This lets a single Storage serve tenants
+// with a Redis instance each: handlers bind the request context, and selector
+// returns the pool of the tenant it carries. When selector returns nil the
+// pool passed to New is used, along with the read pool set with WithReadPool;
+// a selected pool serves reads as well. All tenants share the key prefix.
+func WithPoolSelector(selector func(ctx context.Context) *redis.Pool) Option {
+	return func(s *Storage) {
+		s.poolSelector = selector
+	}
+}
+
+// selectedPool returns the pool the selector set with WithPoolSelector picks
+// for the context of s, or nil.
+func (s *Storage) selectedPool() *redis.Pool {
+	if s.poolSelector == nil {
+		return nil
+	}
+	return s.poolSelector(s.context())
+}
+
+// selectPool returns the pool to get connections from, see WithPoolSelector.
+func (s *Storage) selectPool() *redis.Pool {
+	if pool := s.selectedPool(); pool != nil {
+		return pool
+	}
+	return s.pool
+}
+
+// context returns the context s was bound to with WithContext, or
+// context.Background.
+func (s *Storage) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
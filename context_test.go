@@ -0,0 +1,88 @@
+package osinredis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithContextWaitingForConnection(t *testing.T) {
+	flushAll()
+
+	saturated := &redis.Pool{Dial: pool.Dial, MaxActive: 1, Wait: true}
+	defer saturated.Close()
+	storage := New(saturated, "test123")
+
+	held := saturated.Get()
+	defer held.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := storage.WithContext(ctx).GetClient("clientID")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWithContextCanceled(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := storage.WithContext(ctx).GetClient(client.GetId())
+	assert.NoError(t, err)
+
+	cancel()
+	_, err = storage.WithContext(ctx).GetClient(client.GetId())
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+}
+
+type tenantKey struct{}
+
+func TestWithPoolSelector(t *testing.T) {
+	flushAll()
+
+	// The tenant's Redis is database 1 of the test server.
+	tenantPool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		conn, err := pool.Dial()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Do("SELECT", 1); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}}
+	defer tenantPool.Close()
+	defer func() {
+		conn := tenantPool.Get()
+		defer conn.Close()
+		conn.Do("FLUSHDB")
+	}()
+
+	storage := New(pool, "test123", WithPoolSelector(func(ctx context.Context) *redis.Pool {
+		if ctx.Value(tenantKey{}) == "tenant" {
+			return tenantPool
+		}
+		return nil
+	}))
+	tenant := storage.WithContext(context.WithValue(context.Background(), tenantKey{}, "tenant"))
+
+	client := newClient()
+	assert.NoError(t, tenant.CreateClient(client))
+	_, err := tenant.GetClient(client.Id)
+	assert.NoError(t, err)
+
+	// Without the tenant in the context the pool passed to New is used.
+	_, err = storage.GetClient(client.Id)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
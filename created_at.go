@@ -0,0 +1,75 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// formatCreatedAt returns the current time as stored in creation time keys.
+func (s *Storage) formatCreatedAt() string {
+	return s.now().UTC().Format(time.RFC3339Nano)
+}
+
+// GetClientCreatedAt returns the UTC time at which the client was first
+// created. Updating a client keeps its creation time. It returns ErrNotFound
+// if the client has no recorded creation time, including clients created
+// before creation times were recorded.
+func (s *Storage) GetClientCreatedAt(id string) (_ time.Time, err error) {
+	defer s.observe("GetClientCreatedAt", time.Now(), &err)
+	s, span := s.startSpan("GetClientCreatedAt", "GET", "client_created_at")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	defer conn.Close()
+
+	return readCreatedAt(conn, s.makeKey("client_created_at", id))
+}
+
+// GetAccessCreatedAt returns the UTC time at which SaveAccess stored the
+// access data of the given access token. Unlike AccessData.CreatedAt, which is
+// set by osin, this is stamped by the storage from its clock. It returns
+// ErrNotFound if the token does not exist or has no recorded creation time.
+func (s *Storage) GetAccessCreatedAt(token string) (_ time.Time, err error) {
+	defer s.observe("GetAccessCreatedAt", time.Now(), &err)
+	s, span := s.startSpan("GetAccessCreatedAt", "GET", "access_token")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	defer conn.Close()
+
+	accessID, err := s.resolveAccessID(conn, "access_token", token)
+	if err == redis.ErrNil {
+		return time.Time{}, errors.Wrap(ErrNotFound, "token not stored")
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "unable to get access ID")
+	}
+
+	return readCreatedAt(conn, s.makeKey("access_created_at", accessID))
+}
+
+func readCreatedAt(conn redis.Conn, key string) (time.Time, error) {
+	value, err := redis.String(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		return time.Time{}, errors.Wrap(ErrNotFound, "creation time not stored")
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "unable to get creation time")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to parse creation time")
+	}
+	return createdAt, nil
+}
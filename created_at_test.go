@@ -0,0 +1,67 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetClientCreatedAt(t *testing.T) {
+	flushAll()
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 6, time.FixedZone("CET", 3600))
+	storage := New(pool, "test123", WithClock(func() time.Time { return now }))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	createdAt, err := storage.GetClientCreatedAt(client.GetId())
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(createdAt))
+	assert.Equal(t, time.UTC, createdAt.Location())
+
+	now = now.Add(time.Hour)
+	assert.NoError(t, storage.UpdateClient(client))
+	createdAt, err = storage.GetClientCreatedAt(client.GetId())
+	assert.NoError(t, err)
+	assert.True(t, now.Add(-time.Hour).Equal(createdAt))
+
+	assert.NoError(t, storage.DeleteClient(client))
+	_, err = storage.GetClientCreatedAt(client.GetId())
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestGetAccessCreatedAt(t *testing.T) {
+	flushAll()
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	storage := New(pool, "test123", WithClock(func() time.Time { return now }))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	createdAt, err := storage.GetAccessCreatedAt(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(createdAt))
+
+	conn := pool.Get()
+	defer conn.Close()
+	accessID, err := redis.String(conn.Do("GET", storage.makeKey("access_token", accessData.AccessToken)))
+	assert.NoError(t, err)
+	ttl, err := redis.Int(conn.Do("TTL", storage.makeKey("access_created_at", accessID)))
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	exists, err := redis.Bool(conn.Do("EXISTS", storage.makeKey("access_created_at", accessID)))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	_, err = storage.GetAccessCreatedAt(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
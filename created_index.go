@@ -0,0 +1,138 @@
+package osinredis
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// createdScore converts a creation time to its score in the
+// access_by_created sorted set. Milliseconds keep the score exactly
+// representable as a float64.
+func createdScore(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// ListAccessCreatedBetween returns all access data created within [start, end],
+// ordered by creation time. Index entries whose access data has already expired
+// are dropped from the index along the way.
+func (s *Storage) ListAccessCreatedBetween(start, end time.Time) (_ []*osin.AccessData, err error) {
+	defer s.observe("ListAccessCreatedBetween", time.Now(), &err)
+	s, span := s.startSpan("ListAccessCreatedBetween", "ZRANGEBYSCORE", "access_by_created")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	indexKey := s.makeNamespaceKey("access_by_created")
+	accessIDs, err := redis.Strings(conn.Do("ZRANGEBYSCORE", indexKey, createdScore(start), createdScore(end)))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query creation time index")
+	}
+	if len(accessIDs) == 0 {
+		return nil, nil
+	}
+
+	accessKeys := make([]interface{}, len(accessIDs))
+	for i, accessID := range accessIDs {
+		accessKeys[i] = s.makeKey("access", accessID)
+	}
+
+	accessGobs, err := redis.ByteSlices(conn.Do("MGET", accessKeys...))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get access gobs")
+	}
+
+	var (
+		accesses []*osin.AccessData
+		expired  []interface{}
+	)
+	for i, accessGob := range accessGobs {
+		if accessGob == nil {
+			expired = append(expired, accessIDs[i])
+			continue
+		}
+
+		var access osin.AccessData
+		if err := s.decodeRecord(accessKeys[i].(string), accessGob, &access); err != nil {
+			s.logger.Log(LevelWarn, "failed to decode access", "key", accessKeys[i], "err", err)
+			return nil, errors.Wrap(err, "failed to decode access gob")
+		}
+		accesses = append(accesses, &access)
+	}
+
+	accesses, err = s.refreshAccessListClients(conn, accesses)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(expired) > 0 {
+		if _, err := conn.Do("ZREM", append([]interface{}{indexKey}, expired...)...); err != nil {
+			return nil, errors.Wrap(err, "failed to prune creation time index")
+		}
+	}
+
+	return accesses, nil
+}
+
+// RebuildCreatedIndex backfills the creation time index from the stored
+// access data, for data saved before the index existed. It scans the access
+// namespace with SCAN and is safe to run against a live server.
+func (s *Storage) RebuildCreatedIndex() (err error) {
+	defer s.observe("RebuildCreatedIndex", time.Now(), &err)
+	s, span := s.startSpan("RebuildCreatedIndex", "SCAN", "access")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	indexKey := s.makeNamespaceKey("access_by_created")
+	accessPrefix := s.makeKey("access", "")
+	var cursor uint64
+	for {
+		var (
+			keys []string
+			err  error
+		)
+		cursor, keys, err = scan(conn, cursor, accessPrefix+"*", s.scanHint())
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			accessGob, err := redis.Bytes(conn.Do("GET", key))
+			if err == redis.ErrNil {
+				continue
+			}
+			if err != nil {
+				return errors.Wrap(err, "unable to get access gob")
+			}
+
+			var access osin.AccessData
+			if err := s.decodeRecord(key, accessGob, &access); err != nil {
+				s.logger.Log(LevelWarn, "failed to decode access", "key", key, "err", err)
+				return errors.Wrapf(err, "failed to decode access gob at %s", key)
+			}
+
+			accessID := strings.TrimPrefix(key, accessPrefix)
+			if _, err := conn.Do("ZADD", indexKey, createdScore(access.CreatedAt), accessID); err != nil {
+				return errors.Wrap(err, "failed to index access by creation time")
+			}
+		}
+
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
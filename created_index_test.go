@@ -0,0 +1,64 @@
+package osinredis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListAccessCreatedBetween(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+
+	now := time.Now()
+	for i, token := range []string{"old", "incident", "new"} {
+		accessData := newAccessData(authorizeData)
+		accessData.AccessToken = token
+		accessData.RefreshToken = "r" + token
+		accessData.CreatedAt = now.Add(time.Duration(i-1) * time.Hour)
+		assert.NoError(t, storage.SaveAccess(accessData))
+	}
+
+	accesses, err := storage.ListAccessCreatedBetween(now.Add(-time.Minute), now.Add(time.Minute))
+	assert.NoError(t, err)
+	if assert.Len(t, accesses, 1) {
+		assert.Equal(t, "incident", accesses[0].AccessToken)
+		assert.Equal(t, client, accesses[0].Client)
+	}
+
+	assert.NoError(t, storage.RemoveAccess("incident"))
+
+	accesses, err = storage.ListAccessCreatedBetween(now.Add(-time.Minute), now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, accesses)
+}
+
+func TestRebuildCreatedIndex(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", storage.makeNamespaceKey("access_by_created"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.RebuildCreatedIndex())
+
+	accesses, err := storage.ListAccessCreatedBetween(accessData.CreatedAt.Add(-time.Second), accessData.CreatedAt.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Len(t, accesses, 1)
+}
@@ -0,0 +1,79 @@
+package osinredis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DecodeError is returned, wrapped, when a stored record cannot be decoded.
+// It names the key and the Go type involved, so that operators can tell
+// which record and which struct a failure is about.
+//
+// GobSerializer already skips fields that are stored but no longer exist in
+// the local type, and leaves fields without a stored value zero; decoding
+// only fails when a field changed type or when the bytes are corrupt.
+type DecodeError struct {
+	Key  string
+	Type string
+	// TypeMismatch reports whether the stored record did not match the local
+	// type, as opposed to being unreadable, for example because the
+	// definition of a UserData struct changed, a type is not registered or
+	// the record is of another kind (ErrWrongRecordKind).
+	TypeMismatch bool
+	Err          error
+}
+
+func (e *DecodeError) Error() string {
+	kind := "corrupt or unreadable record"
+	if e.TypeMismatch {
+		kind = "type mismatch"
+	}
+	return fmt.Sprintf("osinredis: decoding %s into %s: %s: %v", e.Key, e.Type, kind, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// gobTypeErrors are fragments of the encoding/gob errors reporting a mismatch
+// between the stored and the local types. encoding/gob has no typed errors.
+var gobTypeErrors = []string{
+	"type mismatch",
+	"decoding into local type",
+	"local interface type",
+	"wrong type (",
+	"name not registered for interface",
+	"is not assignable to type",
+}
+
+// decodeRecord decodes the record stored at key into v like decode, but
+// reports failures as a DecodeError.
+func (s *Storage) decodeRecord(key string, data []byte, v interface{}) error {
+	err := s.decode(data, v)
+	if err == nil {
+		return nil
+	}
+	return &DecodeError{
+		Key:          key,
+		Type:         reflect.TypeOf(v).Elem().String(),
+		TypeMismatch: isTypeMismatch(err),
+		Err:          err,
+	}
+}
+
+func isTypeMismatch(err error) bool {
+	var jsonErr *json.UnmarshalTypeError
+	if errors.As(err, &jsonErr) || errors.Is(err, ErrWrongRecordKind) {
+		return true
+	}
+	msg := err.Error()
+	for _, fragment := range gobTypeErrors {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeError(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	conn := pool.Get()
+	defer conn.Close()
+
+	// A client whose ID was stored with a different type.
+	payload, err := storage.encode(struct{ Id int }{1})
+	assert.NoError(t, err)
+	_, err = conn.Do("SET", "test123:client:changed", payload)
+	assert.NoError(t, err)
+
+	_, err = storage.GetClient("changed")
+	var decodeErr *DecodeError
+	if assert.True(t, errors.As(err, &decodeErr)) {
+		assert.Equal(t, "test123:client:changed", decodeErr.Key)
+		assert.Equal(t, "osin.DefaultClient", decodeErr.Type)
+		assert.True(t, decodeErr.TypeMismatch)
+		assert.Contains(t, err.Error(), "type mismatch")
+	}
+
+	_, err = conn.Do("SET", "test123:client:corrupt", []byte{schemaVersion1, 0xff, 0x00})
+	assert.NoError(t, err)
+
+	_, err = storage.GetClient("corrupt")
+	if assert.True(t, errors.As(err, &decodeErr)) {
+		assert.Equal(t, "test123:client:corrupt", decodeErr.Key)
+		assert.False(t, decodeErr.TypeMismatch)
+	}
+
+	_, err = conn.Do("SET", "test123:client:future", []byte{99})
+	assert.NoError(t, err)
+	_, err = storage.GetClient("future")
+	assert.True(t, errors.Is(err, ErrUnsupportedSchemaVersion))
+}
+
+func TestDecodeWrongRecordKind(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	conn := pool.Get()
+	defer conn.Close()
+
+	// Access data written under a client key.
+	payload, err := storage.encode(&osin.AccessData{AccessToken: "token"})
+	assert.NoError(t, err)
+	_, err = conn.Do("SET", "test123:client:misplaced", payload)
+	assert.NoError(t, err)
+
+	_, err = storage.GetClient("misplaced")
+	assert.True(t, errors.Is(err, ErrWrongRecordKind))
+	var decodeErr *DecodeError
+	if assert.True(t, errors.As(err, &decodeErr)) {
+		assert.Equal(t, "test123:client:misplaced", decodeErr.Key)
+		assert.True(t, decodeErr.TypeMismatch)
+	}
+
+	// Untagged records written before kinds were recorded still decode.
+	gob, err := (GobSerializer{}).Marshal(newClient())
+	assert.NoError(t, err)
+	_, err = conn.Do("SET", "test123:client:untagged", append([]byte{schemaVersion1}, gob...))
+	assert.NoError(t, err)
+
+	client, err := storage.GetClient("untagged")
+	assert.NoError(t, err)
+	assert.Equal(t, newClient(), client)
+}
+
+func TestDecodeAuthorizeErrorReply(t *testing.T) {
+	storage := initTestStorage()
+	conn := pool.Get()
+	defer conn.Close()
+
+	wrongType := redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	_, err := storage.decodeAuthorize(conn, "8888", wrongType)
+	assert.True(t, errors.Is(err, wrongType))
+	var decodeErr *DecodeError
+	assert.False(t, errors.As(err, &decodeErr))
+
+	_, err = storage.decodeAuthorize(conn, "8888", int64(1))
+	assert.Error(t, err)
+}
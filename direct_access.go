@@ -0,0 +1,55 @@
+package osinredis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+)
+
+// WithDirectAccessKeys stores access data under an access ID derived from its
+// access token, the hex encoded SHA-256 digest of the token, instead of a
+// random ID referenced from an access_token pointer. Looking up an access
+// token then takes a single GET instead of two, and saving writes one key
+// less. Refresh tokens still point to the access ID.
+//
+// The tradeoff is that the access ID is no longer independent of the token:
+// client, user and tag indexes, events and GetAccessByID keep working, but
+// anyone who sees an access ID in logs or events can check a guessed token
+// against it, and Stats reports no access token pointers. This changes the
+// key layout, so access data stored with and without this option cannot be
+// read by the other; tokens have to be re-issued when switching.
+func WithDirectAccessKeys() Option {
+	return func(s *Storage) {
+		s.directAccessKeys = true
+	}
+}
+
+// directAccessID returns the access ID under which WithDirectAccessKeys
+// stores the access data of token.
+func directAccessID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// resolveAccessID returns the access ID the token of the given namespace,
+// "access_token" or "refresh_token", refers to, or redis.ErrNil if the token
+// is not stored. With WithDirectAccessKeys access tokens are resolved without
+// a round trip, and whether the access data exists is left to the caller.
+func (s *Storage) resolveAccessID(conn redis.Conn, namespace, token string) (string, error) {
+	if namespace == "access_token" && s.directAccessKeys {
+		return directAccessID(token), nil
+	}
+	return redis.String(conn.Do("GET", s.tokenKey(namespace, token)))
+}
+
+// accessIDFor returns the access ID to store data under: the one derived from
+// its access token with WithDirectAccessKeys, and an unused generated one
+// otherwise, see claimAccessID.
+func (s *Storage) accessIDFor(conn redis.Conn, data *osin.AccessData) (string, error) {
+	if s.directAccessKeys && data.AccessToken != "" {
+		return directAccessID(data.AccessToken), nil
+	}
+	return s.claimAccessID(conn)
+}
@@ -0,0 +1,63 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDirectAccessKeys(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithDirectAccessKeys())
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	exists, err := redis.Bool(conn.Do("EXISTS", storage.makeKey("access", directAccessID(accessData.AccessToken))))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	pointers, err := redis.Strings(conn.Do("KEYS", storage.makeKey("access_token", "*")))
+	assert.NoError(t, err)
+	assert.Empty(t, pointers)
+
+	loadData, accessID, err := storage.LoadAccessWithID(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, directAccessID(accessData.AccessToken), accessID)
+	assert.Equal(t, client, loadData.Client)
+
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	_, err = storage.TokenTTL(accessData.AccessToken)
+	assert.NoError(t, err)
+	_, err = storage.GetAccessCreatedAt(accessData.AccessToken)
+	assert.NoError(t, err)
+	_, err = storage.LoadAccessAndExtend(accessData.AccessToken, time.Hour)
+	assert.NoError(t, err)
+
+	tokens, err := storage.LoadClientTokens(client.Id)
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 1)
+
+	next := newAccessData(newAuthorizeData(client))
+	next.AccessToken = "9999"
+	next.RefreshToken = "r9999"
+	assert.NoError(t, storage.RotateAccess(accessData, next))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.True(t, errors.Is(storage.RotateAccess(accessData, next), ErrNotFound))
+
+	assert.NoError(t, storage.RemoveAccess(next.AccessToken))
+	_, err = storage.LoadRefresh(next.RefreshToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.NoError(t, storage.RemoveAccess(next.AccessToken))
+	_, err = storage.LoadAccessAndExtend(next.AccessToken, time.Hour)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
@@ -27,5 +27,21 @@ Usage:
 		storage := osinredis.New(pool, "prefix")
 		server := osin.NewServer(osin.NewServerConfig(), storage)
 	}
+
+Errors:
+
+Errors returned by Storage wrap their cause, so they can be inspected with
+errors.Is and errors.As from the standard library. Lookups of records that do
+not exist match ErrNotFound, and connection errors match the underlying
+redigo error, such as redis.ErrPoolExhausted:
+
+	if _, err := storage.LoadAccess(token); errors.Is(err, osinredis.ErrNotFound) {
+		// unknown token
+	}
+
+Authorize codes that were removed or redeemed recently additionally match
+ErrCodeRevoked. Expired codes are dropped by Redis and match only ErrNotFound.
+With WithRevokedTokenTTL, the same holds for access and refresh tokens removed
+recently, which match ErrRevoked.
 */
 package osinredis
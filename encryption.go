@@ -0,0 +1,97 @@
+package osinredis
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDecryptionFailed is returned when an encrypted value cannot be decrypted
+// with any of the configured keys.
+var ErrDecryptionFailed = errors.New("decryption failed")
+
+// WithEncryption encrypts every stored value with AES-256-GCM under key, using
+// a random nonce per value. Values encrypted under any of the oldKeys can still
+// be read, which allows rotating keys: configure the new key as key and the
+// previous ones as oldKeys until all data written under them has expired.
+//
+// Encrypted values carry their own schema version, so they are never confused
+// with plaintext. Plaintext values written before encryption was enabled are
+// still read. Keys must be 32 bytes long; WithEncryption panics otherwise.
+func WithEncryption(key []byte, oldKeys ...[]byte) Option {
+	encryptKey := newGCM(key)
+	decryptKeys := []cipher.AEAD{encryptKey}
+	for _, oldKey := range oldKeys {
+		decryptKeys = append(decryptKeys, newGCM(oldKey))
+	}
+
+	return func(s *Storage) {
+		s.encryptKey = encryptKey
+		s.decryptKeys = decryptKeys
+	}
+}
+
+func newGCM(key []byte) cipher.AEAD {
+	if len(key) != 32 {
+		panic("osinredis: encryption keys must be 32 bytes long")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic("osinredis: " + err.Error())
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		panic("osinredis: " + err.Error())
+	}
+	return gcm
+}
+
+// encrypt seals payload, a record of the given kind, under the encryption
+// key. The header, the schema version byte and record kind, is authenticated
+// along with the ciphertext.
+func (s *Storage) encrypt(payload []byte, kind byte) ([]byte, error) {
+	header := []byte{schemaVersionEncrypted}
+	if kind != 0 {
+		header = []byte{schemaVersionTaggedEncrypted, kind}
+	}
+
+	nonce := make([]byte, s.encryptKey.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "unable to generate nonce")
+	}
+
+	data := append(header, nonce...)
+	return s.encryptKey.Seal(data, nonce, payload, header), nil
+}
+
+// decrypt opens an encrypted value, whose header is headerLen bytes long,
+// with the first key that authenticates it.
+func (s *Storage) decrypt(data []byte, headerLen int) ([]byte, error) {
+	if len(s.decryptKeys) == 0 {
+		return nil, errors.Wrap(ErrDecryptionFailed, "value is encrypted but no keys are configured")
+	}
+
+	for _, key := range s.decryptKeys {
+		if payload, ok := openSealed(key, data, headerLen); ok {
+			return payload, nil
+		}
+	}
+	return nil, ErrDecryptionFailed
+}
+
+// openSealed opens an encrypted value, whose header is headerLen bytes long, with
+// key and reports whether key authenticates it.
+func openSealed(key cipher.AEAD, data []byte, headerLen int) ([]byte, bool) {
+	header, rest := data[:headerLen], data[headerLen:]
+	if len(rest) < key.NonceSize() {
+		return nil, false
+	}
+	nonce, ciphertext := rest[:key.NonceSize()], rest[key.NonceSize():]
+	payload, err := key.Open(nil, nonce, ciphertext, header)
+	return payload, err == nil
+}
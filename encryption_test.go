@@ -0,0 +1,69 @@
+package osinredis
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithEncryption(t *testing.T) {
+	flushAll()
+
+	key := bytes.Repeat([]byte{1}, 32)
+	storage := New(pool, "test123", WithEncryption(key))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAccessData(loadData, accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	raw, err := redis.Bytes(conn.Do("GET", storage.makeKey("client", client.GetId())))
+	assert.NoError(t, err)
+	assert.Equal(t, schemaVersionTaggedEncrypted, raw[0])
+	assert.False(t, bytes.Contains(raw, []byte(client.Secret)))
+
+	_, err = initTestStorage().GetClient(client.GetId())
+	assert.True(t, errors.Is(err, ErrDecryptionFailed))
+}
+
+func TestWithEncryptionKeyRotation(t *testing.T) {
+	flushAll()
+
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	client := newClient()
+	assert.NoError(t, New(pool, "test123", WithEncryption(oldKey)).CreateClient(client))
+
+	rotated := New(pool, "test123", WithEncryption(newKey, oldKey))
+	clientFound, err := rotated.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+
+	_, err = New(pool, "test123", WithEncryption(newKey)).GetClient(client.GetId())
+	assert.True(t, errors.Is(err, ErrDecryptionFailed))
+}
+
+func TestWithEncryptionReadsPlaintext(t *testing.T) {
+	flushAll()
+
+	client := newClient()
+	assert.NoError(t, initTestStorage().CreateClient(client))
+
+	storage := New(pool, "test123", WithEncryption(bytes.Repeat([]byte{1}, 32)))
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+
+	assert.Panics(t, func() { WithEncryption([]byte("short")) })
+}
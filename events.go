@@ -0,0 +1,79 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/openshift/osin"
+)
+
+// EventOp is the kind of lifecycle change an Event reports.
+type EventOp string
+
+const (
+	EventCreated EventOp = "created"
+	EventLoaded  EventOp = "loaded"
+	EventRevoked EventOp = "revoked"
+)
+
+// Event describes a stored record being created, loaded or revoked. It never
+// carries secrets: access data is identified by its internal access ID, and
+// authorize data only by its client.
+type Event struct {
+	Op EventOp
+	// Namespace is "client", "auth" or "access".
+	Namespace string
+	// ID is the client ID for clients and the access ID for access data. It
+	// is empty for authorize data.
+	ID       string
+	ClientID string
+	Time     time.Time
+}
+
+// WithEventChannel makes the storage send an Event on events after each
+// successful client write and removal, and after each authorize and access
+// data write, load and removal. Sends never block: events are dropped while
+// the channel is full, so a slow consumer cannot stall the storage. The
+// storage never closes the channel.
+func WithEventChannel(events chan<- Event) Option {
+	return func(s *Storage) {
+		s.events = events
+	}
+}
+
+// emit sends an event without blocking if an event channel is set, and
+// appends it to the audit stream if WithAuditStream is set.
+func (s *Storage) emit(op EventOp, namespace, id, clientID string) {
+	if s.events == nil && s.auditWriter == nil {
+		return
+	}
+	event := Event{Op: op, Namespace: namespace, ID: id, ClientID: clientID, Time: s.now()}
+	if s.auditWriter != nil {
+		s.appendAudit(event)
+	}
+	if s.events == nil {
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+	}
+}
+
+// emitAccess sends an event about the access data stored under accessID,
+// without a client ID if access is nil.
+func (s *Storage) emitAccess(op EventOp, accessID string, access *osin.AccessData) {
+	var clientID string
+	if access != nil && access.Client != nil {
+		clientID = access.Client.GetId()
+	}
+	s.emit(op, "access", accessID, clientID)
+}
+
+// emitAuthorize sends an event about authorize data.
+func (s *Storage) emitAuthorize(op EventOp, auth *osin.AuthorizeData) {
+	var clientID string
+	if auth.Client != nil {
+		clientID = auth.Client.GetId()
+	}
+	s.emit(op, "auth", "", clientID)
+}
@@ -0,0 +1,65 @@
+package osinredis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithEventChannel(t *testing.T) {
+	flushAll()
+
+	events := make(chan Event, 16)
+	storage := New(pool, "test123", WithEventChannel(events))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	authData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authData))
+	_, err := storage.LoadAuthorize(authData.Code)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.RemoveAuthorize(authData.Code))
+
+	accessData := newAccessData(authData)
+	assert.NoError(t, storage.SaveAccess(accessData))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	assert.NoError(t, storage.DeleteClient(client))
+	close(events)
+
+	var got []Event
+	for event := range events {
+		assert.False(t, event.Time.IsZero())
+		event.Time = time.Time{}
+		got = append(got, event)
+	}
+	if !assert.Len(t, got, 8) {
+		return
+	}
+
+	accessID := got[4].ID
+	assert.NotEmpty(t, accessID)
+	expected := []Event{
+		{Op: EventCreated, Namespace: "client", ID: client.GetId(), ClientID: client.GetId()},
+		{Op: EventCreated, Namespace: "auth", ClientID: client.GetId()},
+		{Op: EventLoaded, Namespace: "auth", ClientID: client.GetId()},
+		{Op: EventRevoked, Namespace: "auth"},
+		{Op: EventCreated, Namespace: "access", ID: accessID, ClientID: client.GetId()},
+		{Op: EventLoaded, Namespace: "access", ID: accessID, ClientID: client.GetId()},
+		{Op: EventRevoked, Namespace: "access", ID: accessID, ClientID: client.GetId()},
+		{Op: EventRevoked, Namespace: "client", ID: client.GetId(), ClientID: client.GetId()},
+	}
+	assert.Equal(t, expected, got)
+}
+
+func TestWithEventChannelFull(t *testing.T) {
+	flushAll()
+
+	events := make(chan Event)
+	storage := New(pool, "test123", WithEventChannel(events))
+
+	// Nobody receives, so the event is dropped instead of blocking.
+	assert.NoError(t, storage.CreateClient(newClient()))
+}
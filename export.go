@@ -0,0 +1,228 @@
+package osinredis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// exportHeader starts every stream written by Export, followed by the
+// version of its format.
+const exportHeader = "osinredis-export"
+
+const exportVersion1 byte = 1
+
+// maxExportField bounds the length of a key or value Import accepts, so a
+// corrupt stream cannot make it allocate arbitrary amounts of memory. Redis
+// itself limits strings to 512 MiB.
+const maxExportField = 512 << 20
+
+// errKeyFuncExport is returned by Export and Import for storages built with
+// WithKeyFunc, whose keys need not share the key prefix.
+var errKeyFuncExport = errors.New("unable to export or import keys built with WithKeyFunc")
+
+// Export writes every key under the key prefix of the Storage to w, as a
+// stream that Import restores. Each key is written with its remaining TTL and
+// its value as serialized by DUMP, so values of every type are exported
+// exactly as stored. Keys are found with SCAN and written per batch, so the
+// export neither blocks the server nor holds more than a batch in memory; as
+// with Flush, keys written while it runs may be missed, and it is no
+// point-in-time snapshot. Storages whose keys are built by WithKeyFunc cannot
+// be exported, since their keys are not found by the prefix.
+func (s *Storage) Export(w io.Writer) (err error) {
+	defer s.observe("Export", time.Now(), &err)
+	s, span := s.startSpan("Export", "DUMP", "")
+	defer span.end(&err)
+
+	if s.keyFunc != nil {
+		return errKeyFuncExport
+	}
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(exportHeader)
+	bw.WriteByte(exportVersion1)
+
+	var (
+		cursor uint64
+		keys   []string
+	)
+	for {
+		cursor, keys, err = scan(conn, cursor, s.prefix()+s.keySep+"*", s.scanHint())
+		if err != nil {
+			return err
+		}
+
+		if err := exportKeys(conn, bw, keys); err != nil {
+			return err
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+	return errors.Wrap(bw.Flush(), "failed to write export")
+}
+
+// exportKeys writes the export records of keys to w, skipping keys that no
+// longer exist.
+func exportKeys(conn redis.Conn, w *bufio.Writer, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	for _, key := range keys {
+		conn.Send("PTTL", key)
+		conn.Send("DUMP", key)
+	}
+	replies, err := flush(conn)
+	if err != nil {
+		return errors.Wrap(err, "failed to dump keys")
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	for i, key := range keys {
+		ttl, _ := redis.Int64(replies[2*i], nil)
+		value, _ := redis.Bytes(replies[2*i+1], nil)
+		if ttl == -2 || value == nil {
+			// Expired or deleted since the scan.
+			continue
+		}
+		if ttl < 0 {
+			ttl = 0
+		}
+
+		w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(key)))])
+		w.WriteString(key)
+		w.Write(buf[:binary.PutUvarint(buf[:], uint64(ttl))])
+		w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(value)))])
+		if _, err := w.Write(value); err != nil {
+			return errors.Wrap(err, "failed to write export")
+		}
+	}
+	return nil
+}
+
+// Import restores the keys of a stream written by Export with RESTORE,
+// replacing keys that already exist. TTLs count from the time of the import.
+// The keys keep their names, so they must be under the key prefix of the
+// Storage; Import fails on the first key that is not, leaving the keys before
+// it restored. The stream is read and restored per batch, so it is never held
+// in memory as a whole. RESTORE requires a server of the same or a later
+// Redis version than the one that was exported. Like Export it does not
+// support WithKeyFunc.
+func (s *Storage) Import(r io.Reader) (err error) {
+	defer s.observe("Import", time.Now(), &err)
+	s, span := s.startSpan("Import", "RESTORE", "")
+	defer span.end(&err)
+
+	if s.keyFunc != nil {
+		return errKeyFuncExport
+	}
+
+	br := bufio.NewReader(r)
+	header := make([]byte, len(exportHeader)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return errors.Wrap(err, "failed to read export header")
+	}
+	if !bytes.Equal(header[:len(exportHeader)], []byte(exportHeader)) {
+		return errors.New("not an osinredis export")
+	}
+	if header[len(exportHeader)] != exportVersion1 {
+		return errors.Wrapf(ErrUnsupportedSchemaVersion, "unable to import version %d", header[len(exportHeader)])
+	}
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	prefix := s.prefix() + s.keySep
+	pending := 0
+	for {
+		key, ttl, value, err := readExportRecord(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return errors.Errorf("unable to import %s: key is not under the prefix %s", key, prefix)
+		}
+
+		conn.Send("RESTORE", key, ttl, value, "REPLACE")
+		pending++
+		if pending == s.scanHint() {
+			if _, err := flush(conn); err != nil {
+				return errors.Wrap(err, "failed to restore keys")
+			}
+			pending = 0
+		}
+	}
+
+	if pending > 0 {
+		if _, err := flush(conn); err != nil {
+			return errors.Wrap(err, "failed to restore keys")
+		}
+	}
+	return nil
+}
+
+// readExportRecord reads the next record written by exportKeys. It returns
+// io.EOF at the end of the stream and io.ErrUnexpectedEOF for a truncated
+// record.
+func readExportRecord(r *bufio.Reader) (key string, ttl uint64, value []byte, err error) {
+	keyLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	rawKey, err := readExportField(r, keyLen)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if ttl, err = binary.ReadUvarint(r); err != nil {
+		return "", 0, nil, errors.Wrap(noEOF(err), "failed to read export record")
+	}
+	valueLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", 0, nil, errors.Wrap(noEOF(err), "failed to read export record")
+	}
+	if value, err = readExportField(r, valueLen); err != nil {
+		return "", 0, nil, err
+	}
+	return string(rawKey), ttl, value, nil
+}
+
+func readExportField(r io.Reader, n uint64) ([]byte, error) {
+	if n > maxExportField {
+		return nil, errors.Errorf("failed to read export record: field of %d bytes", n)
+	}
+	field := make([]byte, n)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, errors.Wrap(noEOF(err), "failed to read export record")
+	}
+	return field, nil
+}
+
+// noEOF turns io.EOF in the middle of a record into io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package osinredis
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithScanCount(2))
+	conn := pool.Get()
+	defer conn.Close()
+
+	for _, key := range []string{"test123:a", "test123:b", "test123:c"} {
+		_, err := conn.Do("SET", key, "value of "+key)
+		assert.NoError(t, err)
+	}
+	_, err := conn.Do("EXPIRE", "test123:a", 3600)
+	assert.NoError(t, err)
+	_, err = conn.Do("SET", "other:a", "not exported")
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, storage.Export(&buf))
+	export := buf.Bytes()
+
+	assert.NoError(t, storage.Flush())
+	assert.NoError(t, storage.Import(bytes.NewReader(export)))
+
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:*"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"test123:a", "test123:b", "test123:c"}, keys)
+	value, err := redis.String(conn.Do("GET", "test123:b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value of test123:b", value)
+	ttl, err := redis.Int(conn.Do("TTL", "test123:a"))
+	assert.NoError(t, err)
+	assert.InDelta(t, 3600, ttl, 1)
+	ttl, err = redis.Int(conn.Do("TTL", "test123:c"))
+	assert.NoError(t, err)
+	assert.Equal(t, -1, ttl)
+
+	// Keys outside the prefix of the importing Storage are refused.
+	assert.Error(t, New(pool, "elsewhere").Import(bytes.NewReader(export)))
+
+	assert.Error(t, storage.Import(bytes.NewReader(export[:len(export)-1])))
+	assert.Error(t, storage.Import(bytes.NewReader([]byte("garbage"))))
+
+	// Keys built by a key func are not necessarily under the prefix.
+	keyFunc := New(pool, "test123", WithKeyFunc(func(namespace, id string) string {
+		return namespace + "/" + id
+	}))
+	assert.Error(t, keyFunc.Export(&buf))
+	assert.Error(t, keyFunc.Import(bytes.NewReader(export)))
+}
@@ -0,0 +1,62 @@
+package osinredis
+
+import (
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrUnavailable is returned by storages created with WithFailFast when no
+// pooled connection is available right away. It also matches
+// ErrPoolExhausted with errors.Is.
+var ErrUnavailable error = unavailableError{}
+
+type unavailableError struct{}
+
+func (unavailableError) Error() string { return "no redis connection available" }
+
+func (unavailableError) Is(target error) bool { return target == ErrPoolExhausted }
+
+// WithFailFast makes every method fail with ErrUnavailable instead of waiting
+// when the pool has no idle connection and no room to dial a new one, even if
+// the pool sets Wait. This suits deployments that put their own circuit
+// breaker in front of Redis and would rather shed load than pile up
+// goroutines waiting for connections during an outage. ErrUnavailable is not
+// retried by WithRetry. Dialing a new connection when the pool has room, and
+// commands once a connection is obtained, are bounded by the dial and read
+// timeouts and by WithOperationTimeout as usual. It has no effect on storages
+// created with NewGoRedis.
+func WithFailFast() Option {
+	return func(s *Storage) {
+		s.failFast = true
+	}
+}
+
+// poolExhausted reports whether getting a connection from pool would have to
+// wait for one to be returned. Another caller may take the last connection in
+// the meantime, so a Get after a false report can still wait briefly.
+func poolExhausted(pool *redis.Pool) bool {
+	if pool.MaxActive <= 0 {
+		return false
+	}
+	stats := pool.Stats()
+	return stats.IdleCount == 0 && stats.ActiveCount >= pool.MaxActive
+}
+
+// failFastConn returns the connection to use in place of conn, as handed out
+// by a pool, with WithFailFast.
+func failFastConn(conn redis.Conn) redis.Conn {
+	if conn.Err() == redis.ErrPoolExhausted {
+		conn.Close()
+		return unavailableConn{}
+	}
+	return conn
+}
+
+// unavailableConn stands in for a connection WithFailFast did not wait for.
+type unavailableConn struct{}
+
+func (unavailableConn) Close() error                                   { return nil }
+func (unavailableConn) Err() error                                     { return ErrUnavailable }
+func (unavailableConn) Do(string, ...interface{}) (interface{}, error) { return nil, ErrUnavailable }
+func (unavailableConn) Send(string, ...interface{}) error              { return ErrUnavailable }
+func (unavailableConn) Flush() error                                   { return ErrUnavailable }
+func (unavailableConn) Receive() (interface{}, error)                  { return nil, ErrUnavailable }
@@ -0,0 +1,53 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithFailFast(t *testing.T) {
+	flushAll()
+
+	for _, wait := range []bool{true, false} {
+		single := &redis.Pool{Dial: pool.Dial, MaxActive: 1, Wait: wait}
+		defer single.Close()
+		storage := New(single, "test123", WithFailFast(), WithRetry(3, 0))
+
+		client := newClient()
+		assert.NoError(t, storage.CreateClient(client))
+
+		held := single.Get()
+		_, err := storage.GetClient(client.GetId())
+		assert.True(t, errors.Is(err, ErrUnavailable))
+		assert.True(t, errors.Is(err, ErrPoolExhausted))
+		held.Close()
+
+		_, err = storage.GetClient(client.GetId())
+		assert.NoError(t, err)
+	}
+}
+
+func TestErrPoolExhausted(t *testing.T) {
+	flushAll()
+
+	single := &redis.Pool{Dial: pool.Dial, MaxActive: 1}
+	defer single.Close()
+	storage := New(single, "test123")
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	held := single.Get()
+	defer held.Close()
+	_, err := storage.GetClient(client.GetId())
+	assert.True(t, errors.Is(err, ErrPoolExhausted))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrPoolExhausted))
+	assert.True(t, errors.Is(storage.SaveAccess(accessData), ErrPoolExhausted))
+	assert.True(t, errors.Is(storage.RemoveAccess(accessData.AccessToken), ErrPoolExhausted))
+}
@@ -0,0 +1,131 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// WithFlushBatchSize sets how many keys Flush and RevokeAllTokens delete per
+// DEL command, which is also the COUNT hint of their SCAN. It defaults to the
+// hint set with WithScanCount.
+func WithFlushBatchSize(n int) Option {
+	if n <= 0 {
+		panic("osinredis: flush batch size must be positive")
+	}
+	return func(s *Storage) {
+		s.flushBatchSize = n
+	}
+}
+
+// Flush deletes every key under the key prefix of the Storage, leaving other
+// keys of the Redis instance alone. It iterates with SCAN rather than KEYS or
+// FLUSHDB, so it does not block the server, but keys written while it runs
+// may survive.
+func (s *Storage) Flush() (err error) {
+	defer s.observe("Flush", time.Now(), &err)
+	s, span := s.startSpan("Flush", "DEL", "")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	_, err = s.deleteMatching(conn, s.prefix()+s.keySep+"*")
+	return err
+}
+
+// tokenNamespaces are the namespaces RevokeAllTokens empties: authorize and
+// access data with their token pointers and the token indexes.
+var tokenNamespaces = []string{
+	"auth", "auth_challenge", "access", "access_created_at", "access_meta", "access_indexes",
+	"access_token", "refresh_token", "client_tokens", "user_tokens",
+	"user_auths", "tag",
+}
+
+// RevokeAllTokens deletes every authorize code and every access and refresh
+// token stored under the key prefix of the Storage, together with the token
+// indexes, and returns how many keys it deleted. Clients and their indexes
+// are kept, as are the markers of tokens revoked or rotated before, so
+// RevokeAllTokens forces every user to authorize again without touching
+// client registrations. Like Flush it deletes with SCAN in batches, so tokens
+// saved while it runs may survive, and no events are emitted for the deleted
+// tokens.
+func (s *Storage) RevokeAllTokens() (count int, err error) {
+	defer s.observe("RevokeAllTokens", time.Now(), &err)
+	s, span := s.startSpan("RevokeAllTokens", "DEL", "")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	for _, namespace := range tokenNamespaces {
+		deleted, err := s.deleteMatching(conn, s.makeKey(namespace, "")+"*")
+		count += deleted
+		if err != nil {
+			return count, err
+		}
+	}
+	deleted, err := redis.Int(conn.Do("DEL", s.makeNamespaceKey("access_by_created")))
+	if err != nil {
+		return count, errors.Wrap(err, "failed to delete keys")
+	}
+	return count + deleted, nil
+}
+
+// deleteMatching deletes the keys matching pattern, found with SCAN, in
+// batches of the size set with WithFlushBatchSize, and returns how many it
+// deleted.
+func (s *Storage) deleteMatching(conn redis.Conn, pattern string) (int, error) {
+	batchSize := s.flushBatchSize
+	if batchSize == 0 {
+		batchSize = s.scanHint()
+	}
+
+	var (
+		cursor  uint64
+		keys    []string
+		batch   []interface{}
+		deleted int
+		err     error
+	)
+	for {
+		cursor, keys, err = scan(conn, cursor, pattern, batchSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		for _, key := range keys {
+			batch = append(batch, key)
+			if len(batch) == batchSize {
+				n, err := redis.Int(conn.Do("DEL", batch...))
+				if err != nil {
+					return deleted, errors.Wrap(err, "failed to delete keys")
+				}
+				deleted += n
+				batch = batch[:0]
+			}
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if len(batch) > 0 {
+		n, err := redis.Int(conn.Do("DEL", batch...))
+		if err != nil {
+			return deleted, errors.Wrap(err, "failed to delete keys")
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
@@ -0,0 +1,92 @@
+package osinredis
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlush(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithFlushBatchSize(3))
+	other := New(pool, "test1234")
+
+	var clients []osin.Client
+	for i := 0; i < 10; i++ {
+		clients = append(clients, &osin.DefaultClient{Id: fmt.Sprintf("client%d", i)})
+	}
+	assert.NoError(t, storage.CreateClients(clients))
+	assert.NoError(t, storage.SaveAccess(newAccessData(newAuthorizeData(newClient()))))
+	assert.NoError(t, other.CreateClient(newClient()))
+
+	conn := pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", "unprefixed", "value")
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.Flush())
+
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:*"))
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	exists, err := redis.Bool(conn.Do("EXISTS", "unprefixed"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	_, err = other.GetClient(newClient().GetId())
+	assert.NoError(t, err)
+
+	assert.Panics(t, func() { WithFlushBatchSize(0) })
+}
+
+func TestRevokeAllTokens(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithFlushBatchSize(2), WithUserIDFunc(usernameOf))
+	other := New(pool, "test1234")
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	auth := newAuthorizeData(client)
+	auth.UserData = map[string]interface{}{"username": "jdoe"}
+	assert.NoError(t, storage.SaveAuthorize(auth))
+	assert.NoError(t, storage.SaveAccess(newUserAccessData(client, "a", "jdoe")))
+	assert.NoError(t, storage.SaveAccess(newUserAccessData(client, "b", "")))
+	assert.NoError(t, other.CreateClient(client))
+	assert.NoError(t, other.SaveAccess(newAccessData(newAuthorizeData(client))))
+
+	count, err := storage.RevokeAllTokens()
+	assert.NoError(t, err)
+	// auth, user_auths, two each of access, access_created_at, access_token
+	// and refresh_token, client_tokens, user_tokens and access_by_created.
+	assert.Equal(t, 13, count)
+
+	_, err = storage.LoadAuthorize(auth.Code)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = storage.LoadAccess("a")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = storage.LoadRefresh("refresh-b")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	conn := pool.Get()
+	defer conn.Close()
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:*"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"test123:client:clientID",
+		"test123:client_created_at:clientID",
+		"test123:client_version:clientID",
+		"test123:redirect:http://localhost/",
+	}, keys)
+
+	_, err = storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	_, err = other.LoadAccess("8888")
+	assert.NoError(t, err)
+}
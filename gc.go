@@ -0,0 +1,215 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// GarbageCollect deletes access and refresh token pointers whose access data
+// no longer exists, such as pointers left behind without a TTL, and returns
+// how many it deleted. It iterates with SCAN, never KEYS, so it can run
+// periodically against a live server; pointers written while it runs are
+// only checked if SCAN happens to return them.
+func (s *Storage) GarbageCollect() (removed int, err error) {
+	defer s.observe("GarbageCollect", time.Now(), &err)
+	s, span := s.startSpan("GarbageCollect", "SCAN", "")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	for _, namespace := range []string{"access_token", "refresh_token"} {
+		var (
+			cursor uint64
+			keys   []string
+		)
+		for {
+			cursor, keys, err = scan(conn, cursor, s.makeKey(namespace, "")+"*", s.scanHint())
+			if err != nil {
+				return removed, err
+			}
+
+			n, err := s.removeDanglingPointers(conn, keys)
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	return removed, nil
+}
+
+// removeDanglingPointers deletes those of the given token pointer keys whose
+// access data does not exist and returns how many it deleted.
+func (s *Storage) removeDanglingPointers(conn redis.Conn, keys []string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	accessIDs, err := redis.Values(conn.Do("MGET", redis.Args{}.AddFlat(keys)...))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read token pointers")
+	}
+
+	var candidates []string
+	for i, accessID := range accessIDs {
+		if accessID == nil {
+			// Expired or removed since SCAN returned it.
+			continue
+		}
+		id, _ := redis.String(accessID, nil)
+		conn.Send("EXISTS", s.makeKey("access", id))
+		candidates = append(candidates, keys[i])
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+
+	exists, err := redis.Ints(flush(conn))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to check access data")
+	}
+
+	var dangling []interface{}
+	for i, key := range candidates {
+		if exists[i] == 0 {
+			dangling = append(dangling, key)
+		}
+	}
+	if len(dangling) == 0 {
+		return 0, nil
+	}
+
+	n, err := redis.Int(conn.Do("DEL", dangling...))
+	return n, errors.Wrap(err, "failed to delete dangling token pointers")
+}
+
+// indexNamespaces are the namespaces of the indexes PruneIndexes prunes: the
+// token indexes, the sets of access IDs kept per client, user and tag, and the
+// authorize indexes, the sets of auth keys kept per user.
+var indexNamespaces = []string{"client_tokens", "user_tokens", "tag", "user_auths"}
+
+// pruneIndexScript drops from the index KEYS[1] each member ARGV[i] whose key
+// KEYS[i+1] does not exist, and returns how many it dropped.
+// Checking and dropping in one script keeps a member that is saved again in
+// the meantime. The keys share a hash slot only when WithClusterHashTag is
+// set.
+var pruneIndexScript = redis.NewScript(-1, `
+local removed = 0
+for i, id in ipairs(ARGV) do
+	if redis.call("EXISTS", KEYS[i + 1]) == 0 then
+		removed = removed + redis.call("SREM", KEYS[1], id)
+	end
+end
+return removed
+`)
+
+// PruneIndexes drops the members of the client, user and tag token indexes
+// whose access data no longer exists, and of the user authorize indexes whose
+// authorize data no longer exists, typically because it expired, and returns
+// how many it dropped. Redis does not remove set members when the
+// keys they refer to expire, so without it the indexes of long-lived clients
+// and users keep growing. Like GarbageCollect it iterates with SCAN and SSCAN
+// and can run periodically against a live server.
+func (s *Storage) PruneIndexes() (removed int, err error) {
+	defer s.observe("PruneIndexes", time.Now(), &err)
+	s, span := s.startSpan("PruneIndexes", "SCAN", "")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	for _, namespace := range indexNamespaces {
+		var (
+			cursor uint64
+			keys   []string
+		)
+		for {
+			cursor, keys, err = scan(conn, cursor, s.makeKey(namespace, "")+"*", s.scanHint())
+			if err != nil {
+				return removed, err
+			}
+
+			for _, key := range keys {
+				n, err := s.pruneIndex(conn, namespace, key)
+				removed += n
+				if err != nil {
+					return removed, err
+				}
+			}
+
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	return removed, nil
+}
+
+// pruneIndex drops the members of the index indexKey in namespace whose
+// access or authorize data does not exist and returns how many it dropped.
+// The members are collected before any is dropped, since dropping members
+// while SSCAN iterates may make it skip others.
+func (s *Storage) pruneIndex(conn redis.Conn, namespace, indexKey string) (removed int, err error) {
+	var (
+		cursor uint64
+		ids    []string
+	)
+	for {
+		values, err := redis.Values(conn.Do("SSCAN", indexKey, cursor, "COUNT", s.scanHint()))
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to scan token index")
+		}
+		var page []string
+		if _, err := redis.Scan(values, &cursor, &page); err != nil {
+			return 0, errors.Wrap(err, "failed to parse scan reply")
+		}
+		ids = append(ids, page...)
+		if cursor == 0 {
+			break
+		}
+	}
+
+	for len(ids) > 0 {
+		chunk := ids
+		if len(chunk) > s.scanHint() {
+			chunk = chunk[:s.scanHint()]
+		}
+		ids = ids[len(chunk):]
+
+		keys := redis.Args{indexKey}
+		for _, member := range chunk {
+			keys = keys.Add(s.indexMemberKey(namespace, member))
+		}
+		n, err := redis.Int(pruneIndexScript.Do(conn, append(redis.Args{len(keys)}, keys...).AddFlat(chunk)...))
+		removed += n
+		if err != nil {
+			return removed, errors.Wrap(err, "failed to prune token index")
+		}
+	}
+	return removed, nil
+}
+
+// indexMemberKey returns the key a member of an index in namespace refers to:
+// the auth key itself for the authorize indexes, which hold key names, and
+// the access key of the access ID for the token indexes.
+func (s *Storage) indexMemberKey(namespace, member string) string {
+	if namespace == "user_auths" {
+		return member
+	}
+	return s.makeKey("access", member)
+}
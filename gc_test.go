@@ -0,0 +1,106 @@
+package osinredis
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	for _, key := range []string{"test123:access_token:orphan", "test123:refresh_token:orphan"} {
+		_, err := conn.Do("SET", key, "missing-id")
+		assert.NoError(t, err)
+	}
+
+	removed, err := storage.GarbageCollect()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:*orphan"))
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+
+	removed, err = storage.GarbageCollect()
+	assert.NoError(t, err)
+	assert.Zero(t, removed)
+}
+
+func TestPruneIndexes(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithScanCount(2),
+		WithTokenTagsFunc(func(*osin.AccessData) []string { return []string{"device"} }))
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	var accessIDs []string
+	for i := 0; i < 5; i++ {
+		accessData := newAccessData(newAuthorizeData(client))
+		accessData.AccessToken = fmt.Sprintf("access%d", i)
+		accessData.RefreshToken = fmt.Sprintf("refresh%d", i)
+		assert.NoError(t, storage.SaveAccess(accessData))
+		id, err := redis.String(conn.Do("GET", "test123:access_token:"+accessData.AccessToken))
+		assert.NoError(t, err)
+		accessIDs = append(accessIDs, id)
+	}
+
+	// Expire three of them behind the indexes' back.
+	for _, id := range accessIDs[:3] {
+		_, err := conn.Do("DEL", "test123:access:"+id)
+		assert.NoError(t, err)
+	}
+
+	removed, err := storage.PruneIndexes()
+	assert.NoError(t, err)
+	assert.Equal(t, 6, removed)
+
+	for _, key := range []string{"test123:client_tokens:" + client.Id, "test123:tag:device"} {
+		members, err := redis.Strings(conn.Do("SMEMBERS", key))
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, accessIDs[3:], members)
+	}
+
+	removed, err = storage.PruneIndexes()
+	assert.NoError(t, err)
+	assert.Zero(t, removed)
+
+	// The authorize index of a user holds auth keys instead of access IDs.
+	storage = New(pool, "test123", WithUserIDFunc(usernameOf))
+	for _, code := range []string{"a", "b"} {
+		auth := newAuthorizeData(client)
+		auth.Code = code
+		auth.UserData = map[string]interface{}{"username": "jdoe"}
+		assert.NoError(t, storage.SaveAuthorize(auth))
+	}
+	_, err = conn.Do("DEL", "test123:auth:a")
+	assert.NoError(t, err)
+
+	removed, err = storage.PruneIndexes()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	members, err := redis.Strings(conn.Do("SMEMBERS", "test123:user_auths:jdoe"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test123:auth:b"}, members)
+}
@@ -0,0 +1,271 @@
+package osinredis
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// NewGoRedis initializes and returns a new Storage that talks to Redis through
+// a github.com/redis/go-redis client instead of a redigo pool.
+//
+// With a *goredis.Client every Storage call runs on a single dedicated
+// connection, so the WATCH-guarded operations (PurgeClient and
+// RemoveAllForClient) keep their optimistic locking. Other clients such as
+// *goredis.ClusterClient have no dedicated connections: transactions still run
+// atomically via TxPipeline, but WATCH has no effect on them.
+func NewGoRedis(client goredis.UniversalClient, keyPrefix string, opts ...Option) *Storage {
+	s := newStorage(keyPrefix, opts)
+	s.connFunc = func() redis.Conn {
+		if c, ok := client.(*goredis.Client); ok {
+			conn := c.Conn()
+			return &goRedisConn{client: conn, release: conn.Close}
+		}
+		return &goRedisConn{client: client}
+	}
+	return s
+}
+
+// goRedisPipeliner is implemented by go-redis clients and connections.
+type goRedisPipeliner interface {
+	Pipeline() goredis.Pipeliner
+	TxPipeline() goredis.Pipeliner
+}
+
+// goRedisConn adapts a go-redis client to the redigo redis.Conn interface used
+// throughout the package. Commands queued with Send run as a go-redis
+// pipeline, with MULTI ... EXEC sequences mapped onto TxPipeline.
+type goRedisConn struct {
+	client  goRedisPipeliner
+	release func() error
+	pending [][]interface{}
+	replies []interface{}
+	err     error
+}
+
+func (c *goRedisConn) Close() error {
+	if c.release != nil {
+		return c.release()
+	}
+	return nil
+}
+
+func (c *goRedisConn) Err() error {
+	return c.err
+}
+
+func (c *goRedisConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName != "" {
+		c.Send(commandName, args...)
+	}
+	if len(c.pending) == 0 {
+		return nil, nil
+	}
+
+	cmds := c.pending
+	c.pending = nil
+	replies, err := c.run(cmds)
+	if err != nil {
+		c.err = err
+		return nil, err
+	}
+
+	if commandName == "" {
+		return replies, nil
+	}
+
+	// Like redigo, report the first error reply of any pending command.
+	for _, reply := range replies {
+		if e, ok := reply.(redis.Error); ok {
+			err = e
+			break
+		}
+	}
+	return replies[len(replies)-1], err
+}
+
+func (c *goRedisConn) Send(commandName string, args ...interface{}) error {
+	c.pending = append(c.pending, append([]interface{}{commandName}, args...))
+	return nil
+}
+
+func (c *goRedisConn) Flush() error {
+	replies, err := c.Do("")
+	if err != nil {
+		return err
+	}
+	if replies != nil {
+		c.replies = append(c.replies, replies.([]interface{})...)
+	}
+	return nil
+}
+
+func (c *goRedisConn) Receive() (interface{}, error) {
+	if len(c.replies) == 0 {
+		return nil, errors.New("osinredis: no pending replies")
+	}
+
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	if e, ok := reply.(redis.Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// run executes cmds in order and returns one redigo-style reply per command.
+func (c *goRedisConn) run(cmds [][]interface{}) ([]interface{}, error) {
+	replies := make([]interface{}, 0, len(cmds))
+	for len(cmds) > 0 {
+		end := txEnd(cmds)
+		if end < 0 {
+			// Run everything up to the next transaction as a plain pipeline.
+			n := 1
+			for n < len(cmds) && !isCommand(cmds[n], "MULTI") {
+				n++
+			}
+			segment, err := c.pipeline(c.client.Pipeline(), cmds[:n])
+			if err != nil {
+				return nil, err
+			}
+			replies = append(replies, segment...)
+			cmds = cmds[n:]
+			continue
+		}
+
+		exec, err := c.transaction(cmds[1:end])
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, []byte("OK"))
+		for range cmds[1:end] {
+			replies = append(replies, []byte("QUEUED"))
+		}
+		replies = append(replies, exec)
+		cmds = cmds[end+1:]
+	}
+	return replies, nil
+}
+
+// txEnd returns the index of the EXEC closing a transaction that starts at
+// the first command, or -1 if cmds does not start with a complete transaction.
+func txEnd(cmds [][]interface{}) int {
+	if !isCommand(cmds[0], "MULTI") {
+		return -1
+	}
+	for i, cmd := range cmds {
+		if isCommand(cmd, "EXEC") {
+			return i
+		}
+	}
+	return -1
+}
+
+func isCommand(cmd []interface{}, name string) bool {
+	s, ok := cmd[0].(string)
+	return ok && strings.EqualFold(s, name)
+}
+
+func (c *goRedisConn) pipeline(pipe goredis.Pipeliner, cmds [][]interface{}) ([]interface{}, error) {
+	ctx := context.Background()
+	results := make([]*goredis.Cmd, len(cmds))
+	for i, cmd := range cmds {
+		results[i] = pipe.Do(ctx, cmd...)
+	}
+	pipe.Exec(ctx)
+
+	replies := make([]interface{}, len(results))
+	for i, result := range results {
+		reply, err := fromGoRedis(result.Val(), result.Err())
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// transaction runs cmds atomically and returns the equivalent of the EXEC
+// reply: one reply per command, or nil if a WATCHed key changed.
+func (c *goRedisConn) transaction(cmds [][]interface{}) (interface{}, error) {
+	ctx := context.Background()
+	pipe := c.client.TxPipeline()
+	results := make([]*goredis.Cmd, len(cmds))
+	for i, cmd := range cmds {
+		results[i] = pipe.Do(ctx, cmd...)
+	}
+	if _, err := pipe.Exec(ctx); err == goredis.TxFailedErr {
+		return nil, nil
+	}
+
+	replies := make([]interface{}, len(results))
+	for i, result := range results {
+		reply, err := fromGoRedis(result.Val(), result.Err())
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// fromGoRedis converts a go-redis reply to the representation redigo uses:
+// strings become []byte, RESP3 maps become flat key/value arrays, nil replies
+// become nil and server errors become redis.Error values. Any other error is
+// a connection problem and is returned as such.
+func fromGoRedis(val interface{}, err error) (interface{}, error) {
+	if err == goredis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		var redisErr goredis.Error
+		if errors.As(err, &redisErr) {
+			return redis.Error(err.Error()), nil
+		}
+		return nil, err
+	}
+
+	switch val := val.(type) {
+	case string:
+		return []byte(val), nil
+	case bool:
+		if val {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case float64:
+		return []byte(strconv.FormatFloat(val, 'f', -1, 64)), nil
+	case []interface{}:
+		converted := make([]interface{}, len(val))
+		for i, v := range val {
+			converted[i], err = fromGoRedis(v, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	case map[interface{}]interface{}:
+		converted := make([]interface{}, 0, 2*len(val))
+		for k, v := range val {
+			key, err := fromGoRedis(k, nil)
+			if err != nil {
+				return nil, err
+			}
+			value, err := fromGoRedis(v, nil)
+			if err != nil {
+				return nil, err
+			}
+			converted = append(converted, key, value)
+		}
+		return converted, nil
+	case error:
+		// Errors nested in arrays, such as EXEC replies.
+		return fromGoRedis(nil, val)
+	default:
+		return val, nil
+	}
+}
@@ -0,0 +1,76 @@
+package osinredis
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func initGoRedisTestStorage() *Storage {
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = ":6379"
+	}
+	return NewGoRedis(goredis.NewClient(&goredis.Options{Addr: redisAddr}), "test123")
+}
+
+func TestGoRedisClient(t *testing.T) {
+	flushAll()
+
+	storage := initGoRedisTestStorage()
+	assert.NoError(t, storage.Ping())
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+
+	_, err = storage.GetClient("notthere")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	clients, _, err := storage.ListClients(0, 100)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+}
+
+func TestGoRedisAccess(t *testing.T) {
+	flushAll()
+
+	storage := initGoRedisTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	loadAuthorize, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAuthorizeData(loadAuthorize, authorizeData))
+
+	accessData := newAccessData(authorizeData)
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadData, err := storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAccessData(loadData, accessData))
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	removed, err := storage.PurgeClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
@@ -0,0 +1,180 @@
+package osinredis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// indexReaperGrace is how much longer the index list written with
+// WithIndexReaper lives than its access data, leaving the reaper time to
+// handle the expired event.
+const indexReaperGrace = time.Hour
+
+// WithIndexReaper makes SaveAccess record, next to access data that expires,
+// which client, user and tag token indexes list it, so that a reaper started
+// with StartIndexReaper can remove the access ID from them once the access
+// data expires. The record is one more key per token, kept for an hour
+// longer than the access data.
+func WithIndexReaper() Option {
+	return func(s *Storage) {
+		s.indexReaper = true
+	}
+}
+
+// sendIndexReaperKey queues the commands that record the token indexes
+// listing accessID for the reaper, expiring the record ttl seconds plus
+// indexReaperGrace from now.
+func (s *Storage) sendIndexReaperKey(conn redis.Conn, accessID string, ttl int64, indexes []interface{}) {
+	if len(indexes) == 0 {
+		return
+	}
+	key := s.makeKey("access_indexes", accessID)
+	conn.Send("SADD", redis.Args{key}.Add(indexes...)...)
+	conn.Send("EXPIRE", key, ttl+int64(indexReaperGrace/time.Second))
+}
+
+// StartIndexReaper subscribes to the expired keyspace events of the Redis
+// server and, in a background goroutine, removes the access ID of each
+// expired access data under the key prefix from the creation time index and,
+// if WithIndexReaper is set, from the client, user and tag token indexes
+// that list it, rather than leaving them to PruneIndexes. It holds one
+// connection until ctx is done or the connection fails, which is logged and
+// stops the reaper; start it again to resume. Events are only delivered while
+// the reaper runs, so access data expiring in the meantime still needs
+// PruneIndexes.
+//
+// The server has to publish the events, by setting notify-keyspace-events to
+// include "Ex". StartIndexReaper checks the setting with CONFIG GET and, if
+// expired events are disabled, logs a warning and returns nil without
+// starting the reaper. If CONFIG is not available, as with some managed
+// services, it warns and subscribes anyway. Events of every database are
+// received but access data still stored in the database of the Storage is
+// left alone.
+func (s *Storage) StartIndexReaper(ctx context.Context) error {
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+	config, err := redis.Strings(conn.Do("CONFIG", "GET", "notify-keyspace-events"))
+	conn.Close()
+	switch {
+	case err != nil:
+		s.logger.Log(LevelWarn, "unable to check keyspace notifications, subscribing anyway", "err", err)
+	case len(config) == 2 && !expiredEventsEnabled(config[1]):
+		s.logger.Log(LevelWarn, "expired keyspace events disabled, not starting index reaper", "notify-keyspace-events", config[1])
+		return nil
+	}
+
+	// The subscription outlives any single operation.
+	sub := *s
+	sub.operationTimeout = 0
+	psc := redis.PubSubConn{Conn: sub.getConn()}
+	if err := psc.Conn.Err(); err != nil {
+		return err
+	}
+	if err := psc.PSubscribe("__keyevent@*__:expired"); err != nil {
+		psc.Close()
+		return errors.Wrap(err, "failed to subscribe to expired events")
+	}
+	if err, ok := psc.Receive().(error); ok {
+		psc.Close()
+		return errors.Wrap(err, "failed to subscribe to expired events")
+	}
+
+	go s.reapIndexes(ctx, psc)
+	return nil
+}
+
+// expiredEventsEnabled reports whether the notify-keyspace-events flags
+// publish keyevent notifications for expired keys.
+func expiredEventsEnabled(flags string) bool {
+	return strings.Contains(flags, "E") && strings.ContainsAny(flags, "xA")
+}
+
+// reapIndexes handles the expired events received on psc until ctx is done
+// or the connection fails.
+func (s *Storage) reapIndexes(ctx context.Context, psc redis.PubSubConn) {
+	// Unsubscribing from another goroutine than the one receiving is
+	// supported by redigo; closing is not, so it waits for the unsubscribe.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			psc.PUnsubscribe()
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+		psc.Close()
+	}()
+
+	prefix := s.makeKey("access", "")
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			key := string(v.Data)
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if err := s.reapAccessIndexes(strings.TrimPrefix(key, prefix)); err != nil {
+				s.logger.Log(LevelWarn, "failed to reap token indexes", "key", key, "err", err)
+			}
+		case redis.Subscription:
+			if v.Count == 0 {
+				return
+			}
+		case error:
+			if ctx.Err() == nil {
+				s.logger.Log(LevelWarn, "index reaper stopped", "err", v)
+			}
+			return
+		}
+	}
+}
+
+// reapAccessIndexes removes the expired accessID from the indexes recorded
+// for it and from the creation time index.
+func (s *Storage) reapAccessIndexes(accessID string) error {
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	indexesKey := s.makeKey("access_indexes", accessID)
+	conn.Send("EXISTS", s.makeKey("access", accessID))
+	conn.Send("SMEMBERS", indexesKey)
+	replies, err := flush(conn)
+	if err != nil {
+		return errors.Wrap(err, "unable to get token indexes")
+	}
+	// The event came from another database.
+	if exists, _ := redis.Bool(replies[0], nil); exists {
+		return nil
+	}
+	indexes, err := redis.Strings(replies[1], nil)
+	if err != nil {
+		return errors.Wrap(err, "unable to get token indexes")
+	}
+
+	for _, index := range indexes {
+		conn.Send("SREM", index, accessID)
+	}
+	conn.Send("DEL", indexesKey)
+	conn.Send("ZREM", s.makeNamespaceKey("access_by_created"), accessID)
+	if _, err := flush(conn); err != nil {
+		return errors.Wrap(err, "failed to reap token indexes")
+	}
+	return nil
+}
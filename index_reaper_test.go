@@ -0,0 +1,73 @@
+package osinredis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexReaper(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithIndexReaper())
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	_, accessID, err := storage.LoadAccessWithID(accessData.AccessToken)
+	assert.NoError(t, err)
+
+	conn := pool.Get()
+	defer conn.Close()
+	indexesKey := "test123:access_indexes:" + accessID
+	indexes, err := redis.Strings(conn.Do("SMEMBERS", indexesKey))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test123:client_tokens:clientID"}, indexes)
+	ttl, err := redis.Int(conn.Do("TTL", indexesKey))
+	assert.NoError(t, err)
+	assert.InDelta(t, 3600+3600, ttl, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, storage.StartIndexReaper(ctx))
+
+	// Events for keys outside the prefix and for access data that is still
+	// stored are ignored.
+	_, err = conn.Do("PUBLISH", "__keyevent@0__:expired", "other:access:"+accessID)
+	assert.NoError(t, err)
+	_, err = conn.Do("PUBLISH", "__keyevent@0__:expired", "test123:access:"+accessID)
+	assert.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	members, err := redis.Strings(conn.Do("SMEMBERS", "test123:client_tokens:clientID"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{accessID}, members)
+
+	// miniredis does not publish keyspace events, so expire the access data
+	// by hand.
+	_, err = conn.Do("DEL", "test123:access:"+accessID)
+	assert.NoError(t, err)
+	_, err = conn.Do("PUBLISH", "__keyevent@0__:expired", "test123:access:"+accessID)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		n, _ := redis.Int(conn.Do("SCARD", "test123:client_tokens:clientID"))
+		return n == 0
+	}, time.Second, 10*time.Millisecond)
+	exists, err := redis.Bool(conn.Do("EXISTS", indexesKey))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	created, err := redis.Int(conn.Do("ZCARD", "test123:access_by_created"))
+	assert.NoError(t, err)
+	assert.Zero(t, created)
+}
+
+func TestExpiredEventsEnabled(t *testing.T) {
+	assert.True(t, expiredEventsEnabled("Ex"))
+	assert.True(t, expiredEventsEnabled("EA"))
+	assert.True(t, expiredEventsEnabled("KEA"))
+	assert.False(t, expiredEventsEnabled(""))
+	assert.False(t, expiredEventsEnabled("Kx"))
+	assert.False(t, expiredEventsEnabled("Eg"))
+}
@@ -0,0 +1,40 @@
+package osinredis
+
+import "github.com/gomodule/redigo/redis"
+
+// CommandInterceptor runs the command cmd with args on conn and returns its
+// reply. An interceptor normally ends with conn.Do(cmd, args...), and may
+// fail, delay or short-circuit the command instead, for example to inject
+// faults in tests or to put a circuit breaker in front of Redis.
+type CommandInterceptor func(conn redis.Conn, cmd string, args ...interface{}) (interface{}, error)
+
+// WithCommandInterceptor routes every Do of the Storage's connections through
+// intercept instead of calling conn.Do directly, including the EVALSHA and
+// EVAL of Lua scripts. Commands pipelined with Send are not intercepted one by
+// one: they are written by the Do that flushes them, which reaches intercept
+// with an empty cmd, and their replies are that Do's reply. Without an
+// interceptor commands go straight to conn.Do.
+func WithCommandInterceptor(intercept CommandInterceptor) Option {
+	return func(s *Storage) {
+		s.intercept = intercept
+	}
+}
+
+// interceptedConn passes the Do calls of a connection to an interceptor.
+type interceptedConn struct {
+	redis.Conn
+	intercept CommandInterceptor
+}
+
+func (c interceptedConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	return c.intercept(c.Conn, commandName, args...)
+}
+
+// interceptConn wraps conn with the interceptor set with
+// WithCommandInterceptor, if any.
+func (s *Storage) interceptConn(conn redis.Conn) redis.Conn {
+	if s.intercept == nil {
+		return conn
+	}
+	return interceptedConn{conn, s.intercept}
+}
@@ -0,0 +1,37 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithCommandInterceptor(t *testing.T) {
+	flushAll()
+
+	injected := errors.New("injected")
+	var commands []string
+	storage := New(pool, "test123", WithCommandInterceptor(func(conn redis.Conn, cmd string, args ...interface{}) (interface{}, error) {
+		commands = append(commands, cmd)
+		if cmd == "SETEX" {
+			return nil, injected
+		}
+		return conn.Do(cmd, args...)
+	}))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	_, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Contains(t, commands, "GET")
+
+	err = storage.SaveAuthorize(newAuthorizeData(client))
+	assert.True(t, errors.Is(err, injected))
+
+	// Lua scripts go through the interceptor as well.
+	commands = nil
+	assert.NoError(t, storage.RemoveAuthorize("missing"))
+	assert.Contains(t, commands, "EVALSHA")
+}
@@ -0,0 +1,35 @@
+package osinredis
+
+import (
+	"math/rand"
+)
+
+// WithTTLJitter makes SaveAccess, RotateAccess and RotateRefresh extend the
+// expiry of each access and refresh token key by a random fraction of up to
+// maxFraction of its lifetime, so that tokens issued in a burst are not all
+// dropped by Redis at the same instant. Jitter only ever extends a TTL: the
+// access token still expires for osin and LoadAccess at CreatedAt plus
+// ExpiresIn, and the refresh token outlives its configured TTL by at most
+// the jitter. Tokens without an expiry get none. It defaults to 0, no jitter,
+// and panics if maxFraction is negative or greater than 1.
+func WithTTLJitter(maxFraction float64) Option {
+	if maxFraction < 0 || maxFraction > 1 {
+		panic("osinredis: TTL jitter must be between 0 and 1")
+	}
+	return func(s *Storage) {
+		s.ttlJitter = maxFraction
+	}
+}
+
+// jitterTTL returns the TTL of seconds extended by up to the fraction set
+// with WithTTLJitter.
+func (s *Storage) jitterTTL(seconds int64) int64 {
+	if s.ttlJitter == 0 || seconds <= 0 {
+		return seconds
+	}
+	max := int64(float64(seconds) * s.ttlJitter)
+	if max <= 0 {
+		return seconds
+	}
+	return seconds + rand.Int63n(max+1)
+}
@@ -0,0 +1,45 @@
+package osinredis
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTTLJitter(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithTTLJitter(0.5), WithRefreshTTL(2*time.Hour))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	conn := pool.Get()
+	defer conn.Close()
+	accessTTLs := make(map[int]bool)
+	for i := 0; i < 20; i++ {
+		accessData := newAccessData(newAuthorizeData(client))
+		accessData.AccessToken = fmt.Sprintf("access%d", i)
+		accessData.RefreshToken = fmt.Sprintf("refresh%d", i)
+		assert.NoError(t, storage.SaveAccess(accessData))
+
+		accessTTL, err := redis.Int(conn.Do("TTL", "test123:access_token:"+accessData.AccessToken))
+		assert.NoError(t, err)
+		assert.True(t, accessTTL >= 3600 && accessTTL <= 5400, accessTTL)
+		accessTTLs[accessTTL] = true
+		refreshTTL, err := redis.Int(conn.Do("TTL", "test123:refresh_token:"+accessData.RefreshToken))
+		assert.NoError(t, err)
+		assert.True(t, refreshTTL >= 7200 && refreshTTL <= 10800, refreshTTL)
+
+		// The access token still expires after the requested lifetime.
+		loaded, err := storage.LoadAccess(accessData.AccessToken)
+		assert.NoError(t, err)
+		assert.InDelta(t, 3600, loaded.ExpiresIn, 1)
+	}
+	assert.True(t, len(accessTTLs) > 1)
+
+	assert.Panics(t, func() { WithTTLJitter(-0.1) })
+	assert.Panics(t, func() { WithTTLJitter(1.5) })
+}
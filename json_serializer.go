@@ -0,0 +1,346 @@
+package osinredis
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/openshift/osin"
+)
+
+// JSONSerializer is a Serializer storing records as human-readable JSON, for
+// debugging and for sharing storage with services not written in Go. In
+// redis-cli the JSON document appears after the schema version and record
+// kind bytes.
+//
+// The interface-typed fields of the osin types cannot be restored from JSON on
+// their own: clients are always decoded as *osin.DefaultClient, and UserData
+// is decoded into the value returned by the matching factory, or into the
+// generic encoding/json representation (map[string]interface{} for objects)
+// when no factory is set.
+type JSONSerializer struct {
+	// NewUserData returns a pointer to decode the UserData of authorize and
+	// access data into.
+	NewUserData func() interface{}
+
+	// NewClientUserData returns a pointer to decode the UserData of clients
+	// into.
+	NewClientUserData func() interface{}
+}
+
+type jsonClient struct {
+	Id          string
+	Secret      string
+	RedirectUri string
+	UserData    json.RawMessage
+}
+
+type jsonAuthorizeData struct {
+	Client              *jsonClient
+	Code                string
+	ExpiresIn           int32
+	Scope               string
+	RedirectUri         string
+	State               string
+	CreatedAt           time.Time
+	UserData            json.RawMessage
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+type jsonAccessData struct {
+	Client        *jsonClient
+	AuthorizeData *jsonAuthorizeData
+	AccessData    *jsonAccessData
+	AccessToken   string
+	RefreshToken  string
+	ExpiresIn     int32
+	Scope         string
+	RedirectUri   string
+	CreatedAt     time.Time
+	UserData      json.RawMessage
+}
+
+// Marshal encodes v as JSON.
+func (j JSONSerializer) Marshal(v interface{}) ([]byte, error) {
+	var (
+		doc interface{}
+		err error
+	)
+
+	switch v := v.(type) {
+	case osin.Client:
+		doc, err = toJSONClient(v)
+	case *osin.AuthorizeData:
+		doc, err = toJSONAuthorizeData(v)
+	case *osin.AccessData:
+		doc, err = toJSONAccessData(v)
+	default:
+		doc = v
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(doc)
+}
+
+// Unmarshal decodes JSON into v.
+func (j JSONSerializer) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *osin.DefaultClient:
+		var doc jsonClient
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		client, err := j.fromJSONClient(&doc)
+		if err != nil {
+			return err
+		}
+		*v = *client
+	case *osin.AuthorizeData:
+		var doc jsonAuthorizeData
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		auth, err := j.fromJSONAuthorizeData(&doc)
+		if err != nil {
+			return err
+		}
+		*v = *auth
+	case *osin.AccessData:
+		var doc jsonAccessData
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		access, err := j.fromJSONAccessData(&doc)
+		if err != nil {
+			return err
+		}
+		*v = *access
+	default:
+		return json.Unmarshal(data, v)
+	}
+	return nil
+}
+
+func toJSONClient(client osin.Client) (*jsonClient, error) {
+	if client == nil {
+		return nil, nil
+	}
+
+	userData, err := json.Marshal(client.GetUserData())
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonClient{
+		Id:          client.GetId(),
+		Secret:      client.GetSecret(),
+		RedirectUri: client.GetRedirectUri(),
+		UserData:    userData,
+	}, nil
+}
+
+func toJSONAuthorizeData(auth *osin.AuthorizeData) (*jsonAuthorizeData, error) {
+	if auth == nil {
+		return nil, nil
+	}
+
+	client, err := toJSONClient(auth.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	userData, err := json.Marshal(auth.UserData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonAuthorizeData{
+		Client:              client,
+		Code:                auth.Code,
+		ExpiresIn:           auth.ExpiresIn,
+		Scope:               auth.Scope,
+		RedirectUri:         auth.RedirectUri,
+		State:               auth.State,
+		CreatedAt:           auth.CreatedAt,
+		UserData:            userData,
+		CodeChallenge:       auth.CodeChallenge,
+		CodeChallengeMethod: auth.CodeChallengeMethod,
+	}, nil
+}
+
+func toJSONAccessData(access *osin.AccessData) (*jsonAccessData, error) {
+	if access == nil {
+		return nil, nil
+	}
+
+	client, err := toJSONClient(access.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := toJSONAuthorizeData(access.AuthorizeData)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := toJSONAccessData(access.AccessData)
+	if err != nil {
+		return nil, err
+	}
+
+	userData, err := json.Marshal(access.UserData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonAccessData{
+		Client:        client,
+		AuthorizeData: auth,
+		AccessData:    previous,
+		AccessToken:   access.AccessToken,
+		RefreshToken:  access.RefreshToken,
+		ExpiresIn:     access.ExpiresIn,
+		Scope:         access.Scope,
+		RedirectUri:   access.RedirectUri,
+		CreatedAt:     access.CreatedAt,
+		UserData:      userData,
+	}, nil
+}
+
+func (j JSONSerializer) fromJSONClient(doc *jsonClient) (*osin.DefaultClient, error) {
+	if doc == nil {
+		return nil, nil
+	}
+
+	userData, err := decodeJSONUserData(doc.UserData, j.NewClientUserData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &osin.DefaultClient{
+		Id:          doc.Id,
+		Secret:      doc.Secret,
+		RedirectUri: doc.RedirectUri,
+		UserData:    userData,
+	}, nil
+}
+
+func (j JSONSerializer) fromJSONAuthorizeData(doc *jsonAuthorizeData) (*osin.AuthorizeData, error) {
+	if doc == nil {
+		return nil, nil
+	}
+
+	auth := &osin.AuthorizeData{
+		Code:                doc.Code,
+		ExpiresIn:           doc.ExpiresIn,
+		Scope:               doc.Scope,
+		RedirectUri:         doc.RedirectUri,
+		State:               doc.State,
+		CreatedAt:           doc.CreatedAt,
+		CodeChallenge:       doc.CodeChallenge,
+		CodeChallengeMethod: doc.CodeChallengeMethod,
+	}
+
+	client, err := j.fromJSONClient(doc.Client)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		auth.Client = client
+	}
+
+	auth.UserData, err = decodeJSONUserData(doc.UserData, j.NewUserData)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+func (j JSONSerializer) fromJSONAccessData(doc *jsonAccessData) (*osin.AccessData, error) {
+	if doc == nil {
+		return nil, nil
+	}
+
+	access := &osin.AccessData{
+		AccessToken:  doc.AccessToken,
+		RefreshToken: doc.RefreshToken,
+		ExpiresIn:    doc.ExpiresIn,
+		Scope:        doc.Scope,
+		RedirectUri:  doc.RedirectUri,
+		CreatedAt:    doc.CreatedAt,
+	}
+
+	client, err := j.fromJSONClient(doc.Client)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		access.Client = client
+	}
+
+	if access.AuthorizeData, err = j.fromJSONAuthorizeData(doc.AuthorizeData); err != nil {
+		return nil, err
+	}
+
+	if access.AccessData, err = j.fromJSONAccessData(doc.AccessData); err != nil {
+		return nil, err
+	}
+
+	access.UserData, err = decodeJSONUserData(doc.UserData, j.NewUserData)
+	if err != nil {
+		return nil, err
+	}
+
+	return access, nil
+}
+
+func decodeJSONUserData(raw json.RawMessage, factory func() interface{}) (interface{}, error) {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+
+	if encoded, ok := decodeJSONEncodedUserData(raw); ok {
+		return encoded, nil
+	}
+
+	if factory != nil {
+		userData := factory()
+		if err := json.Unmarshal(raw, userData); err != nil {
+			return nil, err
+		}
+		return userData, nil
+	}
+
+	var userData interface{}
+	if err := json.Unmarshal(raw, &userData); err != nil {
+		return nil, err
+	}
+	return userData, nil
+}
+
+// jsonEncodedUserDataKey is the only key of the JSON object that stands for
+// an encodedUserData, whose bytes it holds in base64.
+const jsonEncodedUserDataKey = "osinredis.encodedUserData"
+
+// MarshalJSON encodes e as an object holding its bytes under
+// jsonEncodedUserDataKey, which decodeJSONUserData recognizes.
+func (e encodedUserData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string][]byte{jsonEncodedUserDataKey: e})
+}
+
+// decodeJSONEncodedUserData decodes raw as written by
+// encodedUserData.MarshalJSON and reports whether it was.
+func decodeJSONEncodedUserData(raw json.RawMessage) (encodedUserData, bool) {
+	if !bytes.HasPrefix(raw, []byte(`{"`+jsonEncodedUserDataKey+`":`)) {
+		return nil, false
+	}
+	var doc map[string][]byte
+	if err := json.Unmarshal(raw, &doc); err != nil || len(doc) != 1 {
+		return nil, false
+	}
+	return encodedUserData(doc[jsonEncodedUserDataKey]), true
+}
@@ -0,0 +1,46 @@
+package osinredis
+
+// WithKeyFunc replaces how the Storage builds its keys, which by default is
+// "<prefix>:<namespace>:<id>", with keyFunc. keyFunc receives the namespace,
+// as renamed by WithNamespaces, and the ID of the record. Keys that exist once
+// per namespace, such as the creation-time index, are built with an empty ID.
+//
+// keyFunc must be stable, returning the same key for the same arguments for
+// as long as data is stored, and collision-free, returning different keys for
+// different namespaces or IDs. The features that find keys with SCAN, such as
+// ListClients, Stats and GarbageCollect, also require the ID to come last:
+// they match keyFunc(namespace, "") followed by "*". Flush still deletes the
+// keys under "<prefix><separator>", Export and Import, which also work on the
+// keys under the prefix, return an error, and WithClusterHashTag and
+// WithKeySeparator have no effect, so keyFunc has to add a hash tag itself.
+//
+// New panics if keyFunc returns the same key for two namespaces, or a
+// different key for the same arguments when called again.
+func WithKeyFunc(keyFunc func(namespace, id string) string) Option {
+	if keyFunc == nil {
+		panic("osinredis: nil key func")
+	}
+	return func(s *Storage) {
+		s.keyFunc = keyFunc
+	}
+}
+
+// checkKeyFunc panics if the key func set with WithKeyFunc visibly breaks its
+// contract.
+func (s *Storage) checkKeyFunc() {
+	if s.keyFunc == nil {
+		return
+	}
+
+	seen := make(map[string]string)
+	for _, name := range s.namespaces.names() {
+		key := s.keyFunc(name, "id")
+		if s.keyFunc(name, "id") != key {
+			panic("osinredis: key func is not stable for namespace " + name)
+		}
+		if other, ok := seen[key]; ok {
+			panic("osinredis: key func maps namespaces " + other + " and " + name + " to the same key")
+		}
+		seen[key] = name
+	}
+}
@@ -0,0 +1,60 @@
+package osinredis
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithKeyFunc(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithKeyFunc(func(namespace, id string) string {
+		return "team/" + namespace + "/" + id
+	}))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, client.GetId(), loadData.Client.GetId())
+
+	clients, _, err := storage.ListClients(0, 100)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+
+	conn := pool.Get()
+	defer conn.Close()
+	keys, err := redis.Strings(conn.Do("KEYS", "test123*"))
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+	exists, err := redis.Bool(conn.Do("EXISTS", "team/access_token/"+accessData.AccessToken))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = redis.Bool(conn.Do("EXISTS", "team/access_by_created/"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.Error(t, err)
+}
+
+func TestWithKeyFuncContract(t *testing.T) {
+	assert.Panics(t, func() { WithKeyFunc(nil) })
+	assert.Panics(t, func() {
+		New(pool, "test123", WithKeyFunc(func(namespace, id string) string { return id }))
+	})
+
+	calls := 0
+	assert.Panics(t, func() {
+		New(pool, "test123", WithKeyFunc(func(namespace, id string) string {
+			calls++
+			return namespace + ":" + id + ":" + string(rune('a'+calls))
+		}))
+	})
+}
@@ -0,0 +1,68 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// LoadAccessAndExtend gets the access data with given access token like
+// LoadAccess and, if ttl is at least a second, extends it like ExtendAccess:
+// the access token then expires ttl from now, rounded down to whole seconds,
+// and its access data is kept for at least as long as its refresh token can
+// still load it. The read and the extension run in a MULTI/EXEC transaction
+// guarded by WATCH, so a token revoked concurrently is either returned and
+// extended or not found, never extended after its removal. An extended token
+// is returned even if it was past CreatedAt+ExpiresIn. Re-fetching the
+// embedded clients takes further round trips, as with LoadAccess.
+func (s *Storage) LoadAccessAndExtend(token string, ttl time.Duration) (_ *osin.AccessData, err error) {
+	defer s.observe("LoadAccessAndExtend", time.Now(), &err)
+	s, span := s.startSpan("LoadAccessAndExtend", "MULTI", "access_token")
+	defer span.end(&err)
+
+	seconds := int64(ttl / time.Second)
+	if seconds <= 0 {
+		access, _, err := s.loadAccessByToken("access_token", token, accessLookup{checkExpiry: true})
+		return access, err
+	}
+
+	var access *osin.AccessData
+	err = s.retry(func() error {
+		conn := s.getConn()
+		if err := conn.Err(); err != nil {
+			return err
+		}
+
+		defer conn.Close()
+
+		accessID, err := s.resolveAccessID(conn, "access_token", token)
+		if err == redis.ErrNil {
+			s.logger.Log(LevelDebug, "token miss", "namespace", "access_token")
+			return s.tokenMiss(conn, "access_token", token)
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to get access ID")
+		}
+
+		data, err := s.extendAccess(conn, accessID, token, seconds)
+		if errors.Is(err, ErrNotFound) && s.directAccessKeys {
+			// The access token was resolved without checking it is stored.
+			return s.tokenMiss(conn, "access_token", token)
+		}
+		if err != nil {
+			return err
+		}
+		s.logger.Log(LevelDebug, "access hit", "key", s.makeKey("access", accessID))
+		s.emitAccess(EventLoaded, accessID, data)
+
+		data.ExpiresIn = int32(seconds)
+		if err := s.refreshAccessClients(conn, data); err != nil {
+			return err
+		}
+		access = data
+		return nil
+	})
+	return access, err
+}
@@ -0,0 +1,41 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAccessAndExtend(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadData, err := storage.LoadAccessAndExtend(accessData.AccessToken, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loadData.AccessToken)
+	assert.Equal(t, client, loadData.Client)
+	assert.InDelta(t, accessData.ExpiresIn, loadData.ExpiresIn, 1)
+
+	loadData, err = storage.LoadAccessAndExtend(accessData.AccessToken, 2*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(7200), loadData.ExpiresIn)
+
+	conn := pool.Get()
+	defer conn.Close()
+	ttl, err := redis.Int(conn.Do("TTL", storage.makeKey("access_token", accessData.AccessToken)))
+	assert.NoError(t, err)
+	assert.InDelta(t, 7200, ttl, 1)
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	_, err = storage.LoadAccessAndExtend(accessData.AccessToken, 2*time.Hour)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
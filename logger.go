@@ -0,0 +1,58 @@
+package osinredis
+
+// Level is the severity of a log entry.
+type Level int
+
+// Log levels, from least to most severe.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Logger receives log entries from Storage. keyvals holds alternating keys and
+// values, as in Log(LevelWarn, "failed to decode", "key", key, "err", err),
+// so it can be forwarded to most structured logging packages directly. Keys
+// that contain token values or authorize codes are never logged.
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+// LoggerFunc adapts an ordinary function to the Logger interface.
+type LoggerFunc func(level Level, msg string, keyvals ...interface{})
+
+// Log calls f(level, msg, keyvals...).
+func (f LoggerFunc) Log(level Level, msg string, keyvals ...interface{}) {
+	f(level, msg, keyvals...)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(Level, string, ...interface{}) {}
+
+// WithLogger sends debug entries for lookup hits and misses and warnings for
+// records that fail to decode to logger. By default nothing is logged.
+func WithLogger(logger Logger) Option {
+	return func(s *Storage) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		s.logger = logger
+	}
+}
@@ -0,0 +1,78 @@
+package osinredis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type logEntry struct {
+	level   Level
+	msg     string
+	keyvals []interface{}
+}
+
+func TestWithLogger(t *testing.T) {
+	flushAll()
+
+	var entries []logEntry
+	storage := New(pool, "test123", WithLogger(LoggerFunc(func(level Level, msg string, keyvals ...interface{}) {
+		entries = append(entries, logEntry{level, msg, keyvals})
+	})))
+
+	client := newClient()
+	assert.Nil(t, storage.CreateClient(client))
+	_, err := storage.GetClient(client.GetId())
+	assert.Nil(t, err)
+	_, err = storage.GetClient("missing")
+	assert.NotNil(t, err)
+
+	conn := pool.Get()
+	_, err = conn.Do("SET", storage.makeKey("client", "corrupt"), "garbage")
+	conn.Close()
+	assert.Nil(t, err)
+	_, err = storage.GetClient("corrupt")
+	assert.NotNil(t, err)
+
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, logEntry{LevelDebug, "client hit", []interface{}{"key", storage.makeKey("client", client.GetId())}}, entries[0])
+		assert.Equal(t, logEntry{LevelDebug, "client miss", []interface{}{"key", storage.makeKey("client", "missing")}}, entries[1])
+		assert.Equal(t, LevelWarn, entries[2].level)
+		assert.Equal(t, []interface{}{"key", storage.makeKey("client", "corrupt")}, entries[2].keyvals[:2])
+	}
+}
+
+func TestLoggerDoesNotLogTokens(t *testing.T) {
+	flushAll()
+
+	var entries []logEntry
+	storage := New(pool, "test123", WithLogger(LoggerFunc(func(level Level, msg string, keyvals ...interface{}) {
+		entries = append(entries, logEntry{level, msg, keyvals})
+	})))
+
+	client := newClient()
+	assert.Nil(t, storage.CreateClient(client))
+	authorizeData := newAuthorizeData(client)
+	assert.Nil(t, storage.SaveAuthorize(authorizeData))
+	accessData := newAccessData(authorizeData)
+	assert.Nil(t, storage.SaveAccess(accessData))
+	entries = nil
+
+	_, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.Nil(t, err)
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.Nil(t, err)
+	_, err = storage.LoadAccess("missing")
+	assert.NotNil(t, err)
+
+	assert.NotEmpty(t, entries)
+	for _, entry := range entries {
+		for _, v := range entry.keyvals {
+			if s, ok := v.(string); ok {
+				assert.NotContains(t, s, authorizeData.Code)
+				assert.NotContains(t, s, accessData.AccessToken)
+				assert.NotContains(t, s, "missing")
+			}
+		}
+	}
+}
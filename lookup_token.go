@@ -0,0 +1,158 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// TokenKind tells which kind of token LookupToken matched.
+type TokenKind int
+
+const (
+	// TokenKindUnknown is returned when no token matched.
+	TokenKindUnknown TokenKind = iota
+	// TokenKindAccess is an access token.
+	TokenKindAccess
+	// TokenKindRefresh is a refresh token.
+	TokenKindRefresh
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenKindAccess:
+		return "access_token"
+	case TokenKindRefresh:
+		return "refresh_token"
+	}
+	return "unknown"
+}
+
+// LookupToken gets the access data of a token of unknown kind, as received by
+// an RFC 7662 introspection endpoint, and returns which kind it is. The access
+// and refresh token pointers are read in a single pipeline; a token stored as
+// both is reported as an access token. An access token is checked for expiry
+// like LoadAccess, returning ErrTokenExpired along with TokenKindAccess, and a
+// refresh token is loaded like LoadRefresh but without WithSlidingRefresh, so
+// introspection does not extend it. It returns ErrNotFound with
+// TokenKindUnknown if neither kind matched.
+func (s *Storage) LookupToken(token string) (_ *osin.AccessData, _ TokenKind, err error) {
+	defer s.observe("LookupToken", time.Now(), &err)
+	s, span := s.startSpan("LookupToken", "GET", "")
+	defer span.end(&err)
+
+	var (
+		access *osin.AccessData
+		kind   TokenKind
+	)
+	err = s.retry(func() error {
+		conn := s.getReadConn()
+		if err := conn.Err(); err != nil {
+			return err
+		}
+
+		defer conn.Close()
+
+		accessID, refreshID, err := s.resolveTokenIDs(conn, token)
+		if err != nil {
+			return err
+		}
+
+		lookup := accessLookup{}
+		switch {
+		case accessID != "":
+			kind, lookup.checkExpiry = TokenKindAccess, true
+		case refreshID != "":
+			kind, accessID = TokenKindRefresh, refreshID
+		default:
+			kind = TokenKindUnknown
+			s.logger.Log(LevelDebug, "token miss")
+			if err := s.tokenMiss(conn, "access_token", token); err == ErrRevoked {
+				return err
+			}
+			return s.tokenMiss(conn, "refresh_token", token)
+		}
+
+		if access, err = s.readAccessByID(conn, accessID, lookup); err != nil {
+			return err
+		}
+		return s.refreshAccessClients(conn, access)
+	})
+	if err != nil {
+		return nil, kind, err
+	}
+	return access, kind, nil
+}
+
+// resolveTokenIDs returns the access IDs token refers to as an access token
+// and as a refresh token, each empty if it is not stored as that kind.
+func (s *Storage) resolveTokenIDs(conn redis.Conn, token string) (accessID, refreshID string, err error) {
+	directID := ""
+	if s.directAccessKeys {
+		directID = directAccessID(token)
+		conn.Send("EXISTS", s.makeKey("access", directID))
+	} else {
+		conn.Send("GET", s.tokenKey("access_token", token))
+	}
+	conn.Send("GET", s.tokenKey("refresh_token", token))
+	replies, err := flush(conn)
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to get access ID")
+	}
+
+	if directID != "" {
+		if exists, _ := redis.Bool(replies[0], nil); exists {
+			accessID = directID
+		}
+	} else if replies[0] != nil {
+		accessID, _ = redis.String(replies[0], nil)
+	}
+	if replies[1] != nil {
+		refreshID, _ = redis.String(replies[1], nil)
+	}
+	return accessID, refreshID, nil
+}
+
+// DescribeToken returns the Redis keys behind token, for inspecting them by
+// hand: "access_token" and "refresh_token" are the keys of its access and
+// refresh token pointers, "access_id" is the access ID the token resolves to,
+// as an access token if it is stored as one and as a refresh token otherwise,
+// and "access" is the key of the access data stored under that ID. The
+// pointer keys are returned whether or not they exist, and the access entries
+// are left out if token is stored as neither kind. With WithDirectAccessKeys
+// access tokens have no pointer, so "access_token" is left out as well.
+//
+// The values are key names, which contain the token itself unless
+// WithTokenKeyHashing is set.
+func (s *Storage) DescribeToken(token string) (_ map[string]string, err error) {
+	defer s.observe("DescribeToken", time.Now(), &err)
+	s, span := s.startSpan("DescribeToken", "GET", "")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	accessID, refreshID, err := s.resolveTokenIDs(conn, token)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]string{"refresh_token": s.tokenKey("refresh_token", token)}
+	if !s.directAccessKeys {
+		keys["access_token"] = s.tokenKey("access_token", token)
+	}
+	if accessID == "" {
+		accessID = refreshID
+	}
+	if accessID != "" {
+		keys["access_id"] = accessID
+		keys["access"] = s.makeKey("access", accessID)
+	}
+	return keys, nil
+}
@@ -0,0 +1,76 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupToken(t *testing.T) {
+	for name, storage := range map[string]*Storage{
+		"pointer": initTestStorage(),
+		"direct":  New(pool, "test123", WithDirectAccessKeys()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			flushAll()
+
+			client := newClient()
+			assert.NoError(t, storage.CreateClient(client))
+			accessData := newAccessData(newAuthorizeData(client))
+			assert.NoError(t, storage.SaveAccess(accessData))
+
+			access, kind, err := storage.LookupToken(accessData.AccessToken)
+			assert.NoError(t, err)
+			assert.Equal(t, TokenKindAccess, kind)
+			assert.Equal(t, accessData.AccessToken, access.AccessToken)
+			assert.Equal(t, client.GetId(), access.Client.GetId())
+
+			access, kind, err = storage.LookupToken(accessData.RefreshToken)
+			assert.NoError(t, err)
+			assert.Equal(t, TokenKindRefresh, kind)
+			assert.Equal(t, accessData.AccessToken, access.AccessToken)
+
+			_, kind, err = storage.LookupToken("missing")
+			assert.True(t, errors.Is(err, ErrNotFound))
+			assert.Equal(t, TokenKindUnknown, kind)
+		})
+	}
+}
+
+func TestDescribeToken(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	accessID, err := redis.String(conn.Do("GET", "test123:access_token:8888"))
+	assert.NoError(t, err)
+
+	keys, err := storage.DescribeToken("8888")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"access_token":  "test123:access_token:8888",
+		"refresh_token": "test123:refresh_token:8888",
+		"access_id":     accessID,
+		"access":        "test123:access:" + accessID,
+	}, keys)
+
+	keys, err = storage.DescribeToken("r8888")
+	assert.NoError(t, err)
+	assert.Equal(t, accessID, keys["access_id"])
+	assert.Equal(t, "test123:refresh_token:r8888", keys["refresh_token"])
+
+	keys, err = storage.DescribeToken("missing")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"access_token":  "test123:access_token:missing",
+		"refresh_token": "test123:refresh_token:missing",
+	}, keys)
+}
@@ -0,0 +1,36 @@
+package osinredis
+
+import "time"
+
+// Observer receives a call for every completed Storage operation, for example
+// to record latencies and error rates. op is the name of the Storage method
+// and err the error it returned; errors.Is(err, ErrNotFound) distinguishes
+// misses from failures.
+type Observer interface {
+	Observe(op string, duration time.Duration, err error)
+}
+
+// ObserverFunc adapts an ordinary function to the Observer interface.
+type ObserverFunc func(op string, duration time.Duration, err error)
+
+// Observe calls f(op, duration, err).
+func (f ObserverFunc) Observe(op string, duration time.Duration, err error) {
+	f(op, duration, err)
+}
+
+// WithMetrics reports every Storage operation to observer.
+func WithMetrics(observer Observer) Option {
+	return func(s *Storage) {
+		s.observer = observer
+	}
+}
+
+// observe reports an operation that started at start. It is meant to be
+// deferred at the top of every public method with a pointer to its named
+// error result.
+func (s *Storage) observe(op string, start time.Time, err *error) {
+	if s.observer == nil {
+		return
+	}
+	s.observer.Observe(op, time.Since(start), *err)
+}
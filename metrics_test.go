@@ -0,0 +1,38 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type observation struct {
+	op  string
+	err error
+}
+
+func TestWithMetrics(t *testing.T) {
+	flushAll()
+
+	var observed []observation
+	storage := New(pool, "test123", WithMetrics(ObserverFunc(func(op string, duration time.Duration, err error) {
+		assert.True(t, duration >= 0)
+		observed = append(observed, observation{op, err})
+	})))
+
+	client := newClient()
+	assert.Nil(t, storage.CreateClient(client))
+	_, err := storage.GetClient(client.GetId())
+	assert.Nil(t, err)
+	_, err = storage.LoadAccess("missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	if assert.Len(t, observed, 3) {
+		assert.Equal(t, observation{"CreateClient", nil}, observed[0])
+		assert.Equal(t, observation{"GetClient", nil}, observed[1])
+		assert.Equal(t, "LoadAccess", observed[2].op)
+		assert.Equal(t, err, observed[2].err)
+	}
+}
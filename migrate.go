@@ -0,0 +1,79 @@
+package osinredis
+
+import (
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// MigratePrefix moves every key under "<from>:" to the same key under
+// "<to>:", keeping TTLs, and returns the number of keys moved. Keys are
+// found with SCAN and moved one at a time with RENAMENX, so the migration does
+// not block the server, can run while the storage is in use and can be run
+// again to pick up keys written under the old prefix in the meantime. A key
+// that already exists under the new prefix is left alone, along with its
+// source. With dryRun nothing is changed and the returned count is the number
+// of keys that would move.
+//
+// Prefixes are given as they appear in the keys, so a prefix used with
+// WithClusterHashTag must include the braces. Only keys using the default ":"
+// separator are matched. RENAMENX cannot move keys
+// between Redis Cluster slots, so on a cluster both prefixes must hash to the
+// same slot.
+func MigratePrefix(pool *redis.Pool, from, to string, dryRun bool) (int, error) {
+	if from == "" || to == "" {
+		return 0, errors.New("osinredis: empty key prefix")
+	}
+	if strings.HasPrefix(to+":", from+":") {
+		return 0, errors.New("osinredis: new key prefix must not be nested in the old one")
+	}
+
+	conn := pool.Get()
+	if err := conn.Err(); err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	var (
+		cursor uint64
+		keys   []string
+		err    error
+		moved  int
+	)
+	for {
+		cursor, keys, err = scan(conn, cursor, from+":*", defaultScanCount)
+		if err != nil {
+			return moved, err
+		}
+
+		for _, key := range keys {
+			if dryRun {
+				moved++
+				continue
+			}
+
+			ok, err := redis.Bool(conn.Do("RENAMENX", key, to+strings.TrimPrefix(key, from)))
+			if isNoSuchKey(err) {
+				// Expired or deleted since the scan.
+				continue
+			}
+			if err != nil {
+				return moved, errors.Wrapf(err, "failed to move %s", key)
+			}
+			if ok {
+				moved++
+			}
+		}
+
+		if cursor == 0 {
+			return moved, nil
+		}
+	}
+}
+
+func isNoSuchKey(err error) bool {
+	redisErr, ok := err.(redis.Error)
+	return ok && strings.Contains(string(redisErr), "no such key")
+}
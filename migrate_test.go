@@ -0,0 +1,49 @@
+package osinredis
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigratePrefix(t *testing.T) {
+	flushAll()
+
+	old := New(pool, "oauth")
+	client := newClient()
+	assert.NoError(t, old.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, old.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	countKeys := func(pattern string) int {
+		keys, err := redis.Strings(conn.Do("KEYS", pattern))
+		assert.NoError(t, err)
+		return len(keys)
+	}
+	total := countKeys("oauth:*")
+
+	n, err := MigratePrefix(pool, "oauth", "auth-v2", true)
+	assert.NoError(t, err)
+	assert.Equal(t, total, n)
+	assert.Equal(t, total, countKeys("oauth:*"))
+
+	n, err = MigratePrefix(pool, "oauth", "auth-v2", false)
+	assert.NoError(t, err)
+	assert.Equal(t, total, n)
+	assert.Equal(t, 0, countKeys("oauth:*"))
+
+	migrated := New(pool, "auth-v2")
+	loadData, err := migrated.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAccessData(loadData, accessData))
+
+	n, err = MigratePrefix(pool, "oauth", "auth-v2", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	_, err = MigratePrefix(pool, "oauth", "oauth:v2", false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,365 @@
+package osinredis
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackSerializer is a Serializer storing records as MessagePack, which is
+// more compact than gob and faster to decode.
+//
+// Like JSONSerializer it decodes clients, including those embedded in
+// authorize and access data, as *osin.DefaultClient. As with gob, UserData
+// values keep their concrete type only if it was registered, here with
+// RegisterMsgpackType, before they are encoded or decoded. UserData of an
+// unregistered type is decoded into the generic msgpack representation, for
+// example map[string]interface{} for structs.
+type MsgpackSerializer struct{}
+
+var msgpackTypes sync.Map // type name -> reflect.Type
+
+// RegisterMsgpackType registers the concrete type of value, so that
+// MsgpackSerializer restores UserData of that type. Types are recorded by
+// name, as with gob.Register, so the name must stay stable for the lifetime
+// of the stored data. It must be called before any storage operation that
+// reads or writes such a value, typically from an init function.
+func RegisterMsgpackType(value interface{}) {
+	t := reflect.TypeOf(value)
+	msgpackTypes.Store(t.String(), t)
+}
+
+// msgpackUserData is the encoding of an interface-typed UserData field.
+type msgpackUserData struct {
+	Type string             `msgpack:"t,omitempty"`
+	Data msgpack.RawMessage `msgpack:"d"`
+}
+
+type msgpackClient struct {
+	Id          string
+	Secret      string
+	RedirectUri string
+	UserData    *msgpackUserData
+}
+
+type msgpackAuthorizeData struct {
+	Client              *msgpackClient
+	Code                string
+	ExpiresIn           int32
+	Scope               string
+	RedirectUri         string
+	State               string
+	CreatedAt           time.Time
+	UserData            *msgpackUserData
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+type msgpackAccessData struct {
+	Client        *msgpackClient
+	AuthorizeData *msgpackAuthorizeData
+	AccessData    *msgpackAccessData
+	AccessToken   string
+	RefreshToken  string
+	ExpiresIn     int32
+	Scope         string
+	RedirectUri   string
+	CreatedAt     time.Time
+	UserData      *msgpackUserData
+}
+
+// Marshal encodes v as MessagePack.
+func (MsgpackSerializer) Marshal(v interface{}) ([]byte, error) {
+	var (
+		doc interface{}
+		err error
+	)
+
+	switch v := v.(type) {
+	case osin.Client:
+		doc, err = toMsgpackClient(v)
+	case *osin.AuthorizeData:
+		doc, err = toMsgpackAuthorizeData(v)
+	case *osin.AccessData:
+		doc, err = toMsgpackAccessData(v)
+	default:
+		doc = v
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return msgpack.Marshal(doc)
+}
+
+// Unmarshal decodes MessagePack into v.
+func (MsgpackSerializer) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *osin.DefaultClient:
+		var doc msgpackClient
+		if err := msgpack.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		client, err := fromMsgpackClient(&doc)
+		if err != nil {
+			return err
+		}
+		*v = *client
+	case *osin.AuthorizeData:
+		var doc msgpackAuthorizeData
+		if err := msgpack.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		auth, err := fromMsgpackAuthorizeData(&doc)
+		if err != nil {
+			return err
+		}
+		*v = *auth
+	case *osin.AccessData:
+		var doc msgpackAccessData
+		if err := msgpack.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		access, err := fromMsgpackAccessData(&doc)
+		if err != nil {
+			return err
+		}
+		*v = *access
+	default:
+		return msgpack.Unmarshal(data, v)
+	}
+	return nil
+}
+
+func toMsgpackUserData(userData interface{}) (*msgpackUserData, error) {
+	if userData == nil {
+		return nil, nil
+	}
+
+	data, err := msgpack.Marshal(userData)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &msgpackUserData{Data: data}
+	if _, ok := userData.(encodedUserData); ok {
+		doc.Type = encodedUserDataType
+		return doc, nil
+	}
+	name := reflect.TypeOf(userData).String()
+	if _, ok := msgpackTypes.Load(name); ok {
+		doc.Type = name
+	}
+	return doc, nil
+}
+
+func fromMsgpackUserData(doc *msgpackUserData) (interface{}, error) {
+	if doc == nil {
+		return nil, nil
+	}
+
+	if doc.Type == "" {
+		var userData interface{}
+		if err := msgpack.Unmarshal(doc.Data, &userData); err != nil {
+			return nil, err
+		}
+		return userData, nil
+	}
+
+	if doc.Type == encodedUserDataType {
+		var encoded []byte
+		if err := msgpack.Unmarshal(doc.Data, &encoded); err != nil {
+			return nil, err
+		}
+		return encodedUserData(encoded), nil
+	}
+
+	t, ok := msgpackTypes.Load(doc.Type)
+	if !ok {
+		return nil, errors.Errorf("msgpack: type %s not registered", doc.Type)
+	}
+
+	ptr := reflect.New(t.(reflect.Type))
+	if err := msgpack.Unmarshal(doc.Data, ptr.Interface()); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+func toMsgpackClient(client osin.Client) (*msgpackClient, error) {
+	if client == nil {
+		return nil, nil
+	}
+
+	userData, err := toMsgpackUserData(client.GetUserData())
+	if err != nil {
+		return nil, err
+	}
+
+	return &msgpackClient{
+		Id:          client.GetId(),
+		Secret:      client.GetSecret(),
+		RedirectUri: client.GetRedirectUri(),
+		UserData:    userData,
+	}, nil
+}
+
+func toMsgpackAuthorizeData(auth *osin.AuthorizeData) (*msgpackAuthorizeData, error) {
+	if auth == nil {
+		return nil, nil
+	}
+
+	client, err := toMsgpackClient(auth.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	userData, err := toMsgpackUserData(auth.UserData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &msgpackAuthorizeData{
+		Client:              client,
+		Code:                auth.Code,
+		ExpiresIn:           auth.ExpiresIn,
+		Scope:               auth.Scope,
+		RedirectUri:         auth.RedirectUri,
+		State:               auth.State,
+		CreatedAt:           auth.CreatedAt,
+		UserData:            userData,
+		CodeChallenge:       auth.CodeChallenge,
+		CodeChallengeMethod: auth.CodeChallengeMethod,
+	}, nil
+}
+
+func toMsgpackAccessData(access *osin.AccessData) (*msgpackAccessData, error) {
+	if access == nil {
+		return nil, nil
+	}
+
+	client, err := toMsgpackClient(access.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := toMsgpackAuthorizeData(access.AuthorizeData)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := toMsgpackAccessData(access.AccessData)
+	if err != nil {
+		return nil, err
+	}
+
+	userData, err := toMsgpackUserData(access.UserData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &msgpackAccessData{
+		Client:        client,
+		AuthorizeData: auth,
+		AccessData:    previous,
+		AccessToken:   access.AccessToken,
+		RefreshToken:  access.RefreshToken,
+		ExpiresIn:     access.ExpiresIn,
+		Scope:         access.Scope,
+		RedirectUri:   access.RedirectUri,
+		CreatedAt:     access.CreatedAt,
+		UserData:      userData,
+	}, nil
+}
+
+func fromMsgpackClient(doc *msgpackClient) (*osin.DefaultClient, error) {
+	if doc == nil {
+		return nil, nil
+	}
+
+	userData, err := fromMsgpackUserData(doc.UserData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &osin.DefaultClient{
+		Id:          doc.Id,
+		Secret:      doc.Secret,
+		RedirectUri: doc.RedirectUri,
+		UserData:    userData,
+	}, nil
+}
+
+func fromMsgpackAuthorizeData(doc *msgpackAuthorizeData) (*osin.AuthorizeData, error) {
+	if doc == nil {
+		return nil, nil
+	}
+
+	auth := &osin.AuthorizeData{
+		Code:                doc.Code,
+		ExpiresIn:           doc.ExpiresIn,
+		Scope:               doc.Scope,
+		RedirectUri:         doc.RedirectUri,
+		State:               doc.State,
+		CreatedAt:           doc.CreatedAt,
+		CodeChallenge:       doc.CodeChallenge,
+		CodeChallengeMethod: doc.CodeChallengeMethod,
+	}
+
+	client, err := fromMsgpackClient(doc.Client)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		auth.Client = client
+	}
+
+	auth.UserData, err = fromMsgpackUserData(doc.UserData)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+func fromMsgpackAccessData(doc *msgpackAccessData) (*osin.AccessData, error) {
+	if doc == nil {
+		return nil, nil
+	}
+
+	access := &osin.AccessData{
+		AccessToken:  doc.AccessToken,
+		RefreshToken: doc.RefreshToken,
+		ExpiresIn:    doc.ExpiresIn,
+		Scope:        doc.Scope,
+		RedirectUri:  doc.RedirectUri,
+		CreatedAt:    doc.CreatedAt,
+	}
+
+	client, err := fromMsgpackClient(doc.Client)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		access.Client = client
+	}
+
+	if access.AuthorizeData, err = fromMsgpackAuthorizeData(doc.AuthorizeData); err != nil {
+		return nil, err
+	}
+
+	if access.AccessData, err = fromMsgpackAccessData(doc.AccessData); err != nil {
+		return nil, err
+	}
+
+	access.UserData, err = fromMsgpackUserData(doc.UserData)
+	if err != nil {
+		return nil, err
+	}
+
+	return access, nil
+}
@@ -0,0 +1,57 @@
+package osinredis
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+type msgpackSession struct {
+	UserID string
+	Roles  []string
+}
+
+func init() {
+	RegisterMsgpackType(&msgpackSession{})
+}
+
+func TestMsgpackSerializer(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithSerializer(MsgpackSerializer{}))
+
+	client := newClient()
+	client.UserData = map[string]interface{}{"tier": "gold"}
+	assert.NoError(t, storage.CreateClient(client))
+
+	loadClient, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, loadClient)
+
+	authorizeData := newAuthorizeData(client)
+	authorizeData.UserData = &msgpackSession{UserID: "42", Roles: []string{"admin"}}
+	accessData := newAccessData(authorizeData)
+	accessData.UserData = &msgpackSession{UserID: "42"}
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	accessID, err := redis.String(conn.Do("GET", storage.makeKey("access_token", accessData.AccessToken)))
+	assert.NoError(t, err)
+	access, err := storage.readAccessGob(conn, accessID)
+	assert.NoError(t, err)
+
+	assert.Equal(t, client, access.Client)
+	assert.Equal(t, &msgpackSession{UserID: "42"}, access.UserData)
+	if assert.NotNil(t, access.AuthorizeData) {
+		assert.Equal(t, authorizeData.Code, access.AuthorizeData.Code)
+		assert.Equal(t, client, access.AuthorizeData.Client)
+		assert.Equal(t, &msgpackSession{UserID: "42", Roles: []string{"admin"}}, access.AuthorizeData.UserData)
+		assert.True(t, authorizeData.CreatedAt.Equal(access.AuthorizeData.CreatedAt))
+	}
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.RefreshToken, loadData.RefreshToken)
+}
@@ -0,0 +1,191 @@
+package osinredis
+
+import (
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// MultiStorage implements "github.com/openshift/osin".Storage on top of two
+// other storages, intended for migrating between backends without downtime.
+//
+// Writes go to the primary first and then to the secondary. Reads are served
+// by the primary and fall back to the secondary only when the primary has no
+// record, so during the transition data written before the dual-write period
+// began is still found as long as it lives in the secondary. A record that was
+// removed from the primary but whose removal failed on the secondary can be
+// read back from the secondary until it expires there; set
+// FailOnSecondaryError if that window is unacceptable.
+type MultiStorage struct {
+	Primary   osin.Storage
+	Secondary osin.Storage
+
+	// FailOnSecondaryError makes write operations return the secondary's
+	// error instead of merely reporting it.
+	FailOnSecondaryError bool
+
+	// OnSecondaryError is called with the operation name and error of every
+	// failed secondary write that is not returned to the caller. It defaults to
+	// logging an error entry to Logger.
+	OnSecondaryError func(op string, err error)
+
+	// Logger receives the failed secondary writes OnSecondaryError does not
+	// handle. It defaults to the logger set with WithLogger on the primary, or
+	// failing that the secondary, if either is a *Storage.
+	Logger Logger
+}
+
+// NewMultiStorage initializes and returns a new MultiStorage
+func NewMultiStorage(primary, secondary osin.Storage) *MultiStorage {
+	return &MultiStorage{
+		Primary:   primary,
+		Secondary: secondary,
+	}
+}
+
+// Clone clones both underlying storages.
+func (m *MultiStorage) Clone() osin.Storage {
+	clone := *m
+	clone.Primary = m.Primary.Clone()
+	clone.Secondary = m.Secondary.Clone()
+	return &clone
+}
+
+// Close closes both underlying storages.
+func (m *MultiStorage) Close() {
+	m.Primary.Close()
+	m.Secondary.Close()
+}
+
+// GetClient gets a client by ID, falling back to the secondary on a miss.
+func (m *MultiStorage) GetClient(id string) (osin.Client, error) {
+	client, err := m.Primary.GetClient(id)
+	if !isMiss(client == nil, err) {
+		return client, err
+	}
+	return m.Secondary.GetClient(id)
+}
+
+// SaveAuthorize saves authorize data to both storages.
+func (m *MultiStorage) SaveAuthorize(data *osin.AuthorizeData) error {
+	if err := m.Primary.SaveAuthorize(data); err != nil {
+		return err
+	}
+	return m.secondaryResult("SaveAuthorize", m.Secondary.SaveAuthorize(data))
+}
+
+// LoadAuthorize looks up AuthorizeData by a code, falling back to the
+// secondary on a miss.
+func (m *MultiStorage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	data, err := m.Primary.LoadAuthorize(code)
+	if !isMiss(data == nil, err) {
+		return data, err
+	}
+	return m.Secondary.LoadAuthorize(code)
+}
+
+// RemoveAuthorize removes the authorization code from both storages, see
+// remove.
+func (m *MultiStorage) RemoveAuthorize(code string) error {
+	return m.remove("RemoveAuthorize", func(s osin.Storage) error { return s.RemoveAuthorize(code) })
+}
+
+// SaveAccess saves access data to both storages.
+func (m *MultiStorage) SaveAccess(data *osin.AccessData) error {
+	if err := m.Primary.SaveAccess(data); err != nil {
+		return err
+	}
+	return m.secondaryResult("SaveAccess", m.Secondary.SaveAccess(data))
+}
+
+// LoadAccess gets access data with given access token, falling back to the
+// secondary on a miss.
+func (m *MultiStorage) LoadAccess(token string) (*osin.AccessData, error) {
+	data, err := m.Primary.LoadAccess(token)
+	if !isMiss(data == nil, err) {
+		return data, err
+	}
+	return m.Secondary.LoadAccess(token)
+}
+
+// RemoveAccess deletes access data with given access token from both
+// storages, see remove.
+func (m *MultiStorage) RemoveAccess(token string) error {
+	return m.remove("RemoveAccess", func(s osin.Storage) error { return s.RemoveAccess(token) })
+}
+
+// LoadRefresh gets access data with given refresh token, falling back to the
+// secondary on a miss.
+func (m *MultiStorage) LoadRefresh(token string) (*osin.AccessData, error) {
+	data, err := m.Primary.LoadRefresh(token)
+	if !isMiss(data == nil, err) {
+		return data, err
+	}
+	return m.Secondary.LoadRefresh(token)
+}
+
+// RemoveRefresh deletes access data with given refresh token from both
+// storages, see remove.
+func (m *MultiStorage) RemoveRefresh(token string) error {
+	return m.remove("RemoveRefresh", func(s osin.Storage) error { return s.RemoveRefresh(token) })
+}
+
+// isMiss reports whether a read found nothing, either by returning ErrNotFound
+// or, for storages that signal a miss that way, a nil record without error.
+func isMiss(empty bool, err error) bool {
+	if err != nil {
+		return errors.Is(err, ErrNotFound)
+	}
+	return empty
+}
+
+// remove runs the removal op on the primary and then on the secondary. A
+// record the primary does not have may still live in the secondary, so a miss
+// on the primary, an error matching ErrNotFound, is only returned if the
+// secondary misses too. A miss on the secondary alone is not a failure.
+func (m *MultiStorage) remove(op string, remove func(osin.Storage) error) error {
+	primaryErr := remove(m.Primary)
+	if primaryErr != nil && !errors.Is(primaryErr, ErrNotFound) {
+		return primaryErr
+	}
+	err := remove(m.Secondary)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		if err := m.secondaryResult(op, err); err != nil {
+			return err
+		}
+	}
+	return primaryErr
+}
+
+// secondaryResult returns the error of the secondary write op if
+// FailOnSecondaryError is set, and otherwise reports it and returns nil.
+func (m *MultiStorage) secondaryResult(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if m.FailOnSecondaryError {
+		return errors.Wrapf(err, "secondary %s failed", op)
+	}
+	if m.OnSecondaryError != nil {
+		m.OnSecondaryError(op, err)
+	} else {
+		m.logger().Log(LevelError, "secondary write failed", "op", op, "err", err)
+	}
+	return nil
+}
+
+// logger returns Logger, or the logger of the primary or secondary *Storage if
+// it is not set.
+func (m *MultiStorage) logger() Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+	for _, storage := range []osin.Storage{m.Primary, m.Secondary} {
+		if s, ok := storage.(*Storage); ok {
+			return s.logger
+		}
+	}
+	return nopLogger{}
+}
@@ -0,0 +1,112 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiStorageWritesBoth(t *testing.T) {
+	flushAll()
+
+	primary := New(pool, "primary")
+	secondary := New(pool, "secondary")
+	storage := NewMultiStorage(primary, secondary)
+
+	client := newClient()
+	assert.NoError(t, primary.CreateClient(client))
+	assert.NoError(t, secondary.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	for _, s := range []*Storage{primary, secondary} {
+		loadData, err := s.LoadAuthorize(authorizeData.Code)
+		assert.NoError(t, err)
+		assert.True(t, isEqualAuthorizeData(loadData, authorizeData))
+	}
+
+	assert.NoError(t, storage.RemoveAuthorize(authorizeData.Code))
+
+	for _, s := range []*Storage{primary, secondary} {
+		loadData, err := s.LoadAuthorize(authorizeData.Code)
+		assert.Nil(t, loadData)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	}
+}
+
+func TestMultiStorageFallsBackToSecondary(t *testing.T) {
+	flushAll()
+
+	primary := New(pool, "primary")
+	secondary := New(pool, "secondary")
+	storage := NewMultiStorage(primary, secondary)
+
+	client := newClient()
+	assert.NoError(t, secondary.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, secondary.SaveAuthorize(authorizeData))
+
+	accessData := newAccessData(authorizeData)
+	assert.NoError(t, secondary.SaveAccess(accessData))
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAccessData(loadData, accessData))
+}
+
+func TestMultiStorageRemoveFallsBackToSecondary(t *testing.T) {
+	flushAll()
+
+	primary := New(pool, "primary", WithStrictRemove())
+	secondary := New(pool, "secondary", WithStrictRemove())
+	storage := NewMultiStorage(primary, secondary)
+
+	client := newClient()
+	assert.NoError(t, secondary.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, secondary.SaveAuthorize(authorizeData))
+	accessData := newAccessData(authorizeData)
+	assert.NoError(t, secondary.SaveAccess(accessData))
+
+	assert.NoError(t, storage.RemoveAuthorize(authorizeData.Code))
+	_, err := secondary.LoadAuthorize(authorizeData.Code)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	_, err = secondary.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// A miss on both storages is reported, a miss on the secondary alone is not.
+	assert.True(t, errors.Is(storage.RemoveRefresh(accessData.RefreshToken), ErrNotFound))
+
+	assert.NoError(t, primary.SaveAuthorize(authorizeData))
+	assert.NoError(t, storage.RemoveAuthorize(authorizeData.Code))
+}
+
+func TestMultiStorageLogsSecondaryError(t *testing.T) {
+	flushAll()
+
+	var logged []interface{}
+	logger := LoggerFunc(func(level Level, msg string, keyvals ...interface{}) {
+		if level == LevelError {
+			logged = append(logged, msg)
+			logged = append(logged, keyvals...)
+		}
+	})
+	down := &redis.Pool{Dial: func() (redis.Conn, error) { return nil, errors.New("down") }}
+	storage := NewMultiStorage(New(pool, "primary", WithLogger(logger)), New(down, "secondary"))
+
+	client := newClient()
+	assert.NoError(t, storage.Primary.(*Storage).CreateClient(client))
+	assert.NoError(t, storage.SaveAuthorize(newAuthorizeData(client)))
+	assert.Equal(t, []interface{}{"secondary write failed", "op", "SaveAuthorize"}, logged[:3])
+}
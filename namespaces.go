@@ -0,0 +1,71 @@
+package osinredis
+
+import "strings"
+
+// Namespaces holds the key segments placed between the key prefix and the ID
+// of the main records, for example "client" in "prefix:client:id".
+type Namespaces struct {
+	Client       string
+	Auth         string
+	Access       string
+	AccessToken  string
+	RefreshToken string
+}
+
+// DefaultNamespaces returns the namespaces used unless WithNamespaces is set.
+func DefaultNamespaces() Namespaces {
+	return Namespaces{
+		Client:       "client",
+		Auth:         "auth",
+		Access:       "access",
+		AccessToken:  "access_token",
+		RefreshToken: "refresh_token",
+	}
+}
+
+// WithNamespaces renames the key segments of clients, authorize data, access
+// data and the token pointers, so that several deployments can share a key
+// prefix. Start from DefaultNamespaces and change what is needed. The keys of
+// creation times and token indexes keep their names. It panics if a
+// namespace is empty or, when the Storage is constructed, contains the key
+// separator. Records stored under the old names are not renamed.
+func WithNamespaces(namespaces Namespaces) Option {
+	for _, name := range namespaces.names() {
+		if name == "" {
+			panic("osinredis: empty namespace")
+		}
+	}
+	return func(s *Storage) {
+		s.namespaces = namespaces
+	}
+}
+
+func (n Namespaces) names() []string {
+	return []string{n.Client, n.Auth, n.Access, n.AccessToken, n.RefreshToken}
+}
+
+// checkNamespaces panics if a namespace contains the key separator.
+func (s *Storage) checkNamespaces() {
+	for _, name := range s.namespaces.names() {
+		if strings.Contains(name, s.keySep) {
+			panic("osinredis: namespace " + name + " contains the key separator")
+		}
+	}
+}
+
+// namespace maps the default name of a namespace to the configured one.
+func (s *Storage) namespace(name string) string {
+	switch name {
+	case "client":
+		return s.namespaces.Client
+	case "auth":
+		return s.namespaces.Auth
+	case "access":
+		return s.namespaces.Access
+	case "access_token":
+		return s.namespaces.AccessToken
+	case "refresh_token":
+		return s.namespaces.RefreshToken
+	}
+	return name
+}
@@ -0,0 +1,46 @@
+package osinredis
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithNamespaces(t *testing.T) {
+	flushAll()
+
+	namespaces := DefaultNamespaces()
+	namespaces.Client = "c"
+	namespaces.Auth = "a"
+	namespaces.AccessToken = "at"
+	storage := New(pool, "test123", WithNamespaces(namespaces))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	authData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authData))
+	accessData := newAccessData(authData)
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	for _, key := range []string{"test123:c:clientID", "test123:a:8888", "test123:at:8888", "test123:refresh_token:r8888"} {
+		exists, err := redis.Bool(conn.Do("EXISTS", key))
+		assert.NoError(t, err)
+		assert.True(t, exists, key)
+	}
+
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+
+	// A storage with the default namespaces does not see the records.
+	_, err = initTestStorage().GetClient(client.GetId())
+	assert.Error(t, err)
+
+	namespaces.Access = ""
+	assert.Panics(t, func() { WithNamespaces(namespaces) })
+	namespaces.Access = "access:v2"
+	assert.Panics(t, func() { New(pool, "test123", WithNamespaces(namespaces)) })
+	assert.NotPanics(t, func() { New(pool, "test123", WithNamespaces(namespaces), WithKeySeparator("/")) })
+}
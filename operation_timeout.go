@@ -0,0 +1,87 @@
+package osinredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// WithOperationTimeout bounds how long a single storage method waits for a
+// pooled connection and for the replies to its commands to d, counted from
+// when the method takes its connection. A method running past the deadline
+// fails with a timeout error and the connection is closed instead of
+// being returned to the pool, so a wedged connection cannot block callers
+// indefinitely. The deadline applies on top of any context bound with
+// WithContext. It has no effect on storages created with NewGoRedis, whose
+// client has its own timeouts. A value of 0 disables the timeout.
+func WithOperationTimeout(d time.Duration) Option {
+	if d < 0 {
+		panic("osinredis: negative operation timeout")
+	}
+	return func(s *Storage) {
+		s.operationTimeout = d
+	}
+}
+
+// getPoolConn gets a connection from pool, bound to the context set with
+// WithContext and to the operation timeout, if either is configured, without
+// waiting for one with WithFailFast.
+func (s *Storage) getPoolConn(pool *redis.Pool) redis.Conn {
+	if !s.failFast {
+		return s.waitPoolConn(pool)
+	}
+	if poolExhausted(pool) {
+		return unavailableConn{}
+	}
+	return failFastConn(s.waitPoolConn(pool))
+}
+
+// waitPoolConn gets a connection from pool like getPoolConn, waiting for one
+// if the pool sets Wait.
+func (s *Storage) waitPoolConn(pool *redis.Pool) redis.Conn {
+	if s.operationTimeout <= 0 && s.ctx == nil {
+		return pool.Get()
+	}
+
+	ctx, cancel := s.context(), context.CancelFunc(func() {})
+	if s.operationTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.operationTimeout)
+	}
+	conn, err := pool.GetContext(ctx)
+	if err != nil {
+		cancel()
+		if err == ctx.Err() {
+			return failedConn{conn, errors.Wrap(err, "gave up waiting for a connection")}
+		}
+		return conn
+	}
+	return &deadlineConn{Conn: conn, ctx: ctx, cancel: cancel}
+}
+
+// deadlineConn runs the commands of a connection under the deadline of ctx.
+type deadlineConn struct {
+	redis.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c *deadlineConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if _, ok := c.Conn.(redis.ConnWithContext); !ok {
+		return c.Conn.Do(commandName, args...)
+	}
+	return redis.DoContext(c.Conn, c.ctx, commandName, args...)
+}
+
+func (c *deadlineConn) Receive() (interface{}, error) {
+	if _, ok := c.Conn.(redis.ConnWithContext); !ok {
+		return c.Conn.Receive()
+	}
+	return redis.ReceiveContext(c.Conn, c.ctx)
+}
+
+func (c *deadlineConn) Close() error {
+	defer c.cancel()
+	return c.Conn.Close()
+}
@@ -0,0 +1,55 @@
+package osinredis
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithOperationTimeout(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithOperationTimeout(time.Second))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+
+	assert.Panics(t, func() { WithOperationTimeout(-time.Second) })
+}
+
+func TestWithOperationTimeoutWedged(t *testing.T) {
+	// A server that accepts connections but never replies.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	wedged := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", listener.Addr().String())
+		},
+	}
+	storage := New(wedged, "test123", WithOperationTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	_, err = storage.GetClient("1")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,251 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+)
+
+// Option configures optional Storage behavior. Options are passed to New.
+type Option func(*Storage)
+
+// WithUsernameFunc sets the function used to derive the username reported by
+// LoadAccessInfo from the access data's UserData. Without it the username is
+// left empty.
+func WithUsernameFunc(f func(userData interface{}) string) Option {
+	return func(s *Storage) {
+		s.usernameFunc = f
+	}
+}
+
+// WithStrictRemove makes RemoveAccess, RemoveRefresh, RemoveAuthorize and
+// DeleteClient return ErrNotFound for a token, code or client that does not
+// exist, rather than succeeding.
+func WithStrictRemove() Option {
+	return func(s *Storage) {
+		s.strictRemove = true
+	}
+}
+
+// WithClientRefreshOnLoad sets whether loading access data replaces the
+// clients embedded in it, and in its authorize data, with their current
+// stored versions, which costs a round trip per load. It defaults to true.
+// With false the clients are returned as they were when the access data was
+// saved: changes to a client, such as a new secret or redirect URI, are not
+// seen by its existing tokens, and tokens of a deleted client keep loading
+// regardless of WithOrphanTokenPolicy. Authorize data is always loaded with
+// its current client.
+func WithClientRefreshOnLoad(refresh bool) Option {
+	return func(s *Storage) {
+		s.embeddedClients = !refresh
+	}
+}
+
+// WithValidateClientOnSave makes SaveAccess check that the referenced client
+// exists before writing anything, returning ErrClientNotFound otherwise. This
+// costs an extra round trip per SaveAccess.
+func WithValidateClientOnSave() Option {
+	return func(s *Storage) {
+		s.validateClientOnSave = true
+	}
+}
+
+// WithRefreshTTL sets the lifetime of refresh tokens written by SaveAccess.
+// Refresh tokens typically outlive the access tokens they were issued with;
+// when unset they expire together with the access token. When it is longer
+// than the ExpiresIn of the access data, the access data is kept for as long
+// as its refresh token, so LoadRefresh still finds it once the access token
+// expired and osin can mint a new access token from it. The access token
+// itself still expires after ExpiresIn.
+func WithRefreshTTL(d time.Duration) Option {
+	return func(s *Storage) {
+		s.refreshTTL = d
+	}
+}
+
+// WithAuthorizeTTL sets how long SaveAuthorize and SaveAuthorizeNX keep
+// authorize codes, rounded down to whole seconds, in place of the ExpiresIn of
+// the authorize data, for example to enforce a shorter code lifetime than the
+// one osin is configured with. The ExpiresIn stored in the authorize data is
+// left as is. When unset, codes expire after ExpiresIn, and saving authorize
+// data whose ExpiresIn is not positive fails. It panics if d is negative or
+// shorter than a second.
+func WithAuthorizeTTL(d time.Duration) Option {
+	if d < 0 || (d > 0 && d < time.Second) {
+		panic("osinredis: authorize TTL must be at least one second")
+	}
+	return func(s *Storage) {
+		s.authorizeTTL = d
+	}
+}
+
+// WithClientTTLFunc sets a function that decides the access token lifetime of
+// each client. When it returns a positive duration for the client of the
+// access data, SaveAccess and RotateAccess replace data.ExpiresIn with it,
+// rounded down to whole seconds, before storing; when it returns zero the
+// ExpiresIn set by the caller is kept. Since osin builds the token response
+// after saving, the response reports the overridden lifetime as well.
+func WithClientTTLFunc(ttl func(osin.Client) time.Duration) Option {
+	return func(s *Storage) {
+		s.clientTTLFunc = ttl
+	}
+}
+
+// WithSlidingRefresh resets the TTL of the access data to ttl, rounded down to
+// whole seconds, every time LoadRefresh finds it, so that refresh tokens in
+// active use do not expire. The TTL of the access token pointer is reset as
+// well, but LoadAccess still rejects the access token once it is past
+// CreatedAt+ExpiresIn; LoadAccess itself never extends TTLs.
+func WithSlidingRefresh(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.slidingRefreshTTL = ttl
+	}
+}
+
+// WithCascadingClientDelete makes DeleteClient also revoke every access and
+// refresh token issued to the client, as PurgeClient does. Without it deleted
+// clients' tokens remain valid until they expire.
+func WithCascadingClientDelete() Option {
+	return func(s *Storage) {
+		s.cascadeClientDelete = true
+	}
+}
+
+// WithReadPool sends read-only commands, such as those of GetClient,
+// LoadAuthorize and LoadAccess, to readPool, typically connected to a replica,
+// while all writes go to the primary pool. Replication is asynchronous, so
+// data written on the primary may briefly be missing from reads, for example
+// a token loaded right after it was saved. Lookups that also write, such as
+// LoadRefresh with WithSlidingRefresh, use the primary pool.
+func WithReadPool(readPool *redis.Pool) Option {
+	return func(s *Storage) {
+		s.readPool = readPool
+	}
+}
+
+// WithSerializer sets the Serializer used for every stored record. It defaults
+// to GobSerializer.
+func WithSerializer(serializer Serializer) Option {
+	return func(s *Storage) {
+		s.serializer = serializer
+		s.clientSerializer = serializer
+	}
+}
+
+// WithClientSerializer sets the Serializer used for clients only, for example
+// to keep long-lived clients as JSON readable by admin tooling while tokens
+// use a compact format. It defaults to GobSerializer.
+func WithClientSerializer(serializer Serializer) Option {
+	return func(s *Storage) {
+		s.clientSerializer = serializer
+	}
+}
+
+// WithTokenSerializer sets the Serializer used for authorize data and access
+// data only, see WithClientSerializer. It defaults to GobSerializer.
+func WithTokenSerializer(serializer Serializer) Option {
+	return func(s *Storage) {
+		s.serializer = serializer
+	}
+}
+
+// WithMaxPayloadBytes makes every write fail with ErrPayloadTooLarge, before
+// anything is sent to Redis, when a client, authorize data or access data
+// encodes to more than n bytes, for example because of an oversized UserData.
+// A value of 0 means unlimited.
+func WithMaxPayloadBytes(n int) Option {
+	if n < 0 {
+		panic("osinredis: negative payload limit")
+	}
+	return func(s *Storage) {
+		s.maxPayloadBytes = n
+	}
+}
+
+// WithFallbackSerializers sets Serializers that decoding falls back to, in
+// order, when the primary Serializer set with WithSerializer fails to decode
+// a stored record. Writes always use the primary Serializer. The fallbacks
+// apply to clients and tokens alike. This lets a storage read records written
+// in the old format while migrating between serializers; once all old records
+// have expired or been rewritten, the fallbacks can be dropped.
+func WithFallbackSerializers(serializers ...Serializer) Option {
+	return func(s *Storage) {
+		s.fallbackSerializers = serializers
+	}
+}
+
+// WithKeySeparator sets the separator placed between the key prefix, the
+// namespace and the ID of every key. It defaults to ":". It panics if sep is
+// empty, since keys of different namespaces could then collide.
+func WithKeySeparator(sep string) Option {
+	if sep == "" {
+		panic("osinredis: key separator must not be empty")
+	}
+	return func(s *Storage) {
+		s.keySep = sep
+	}
+}
+
+// WithClusterHashTag wraps the key prefix in a Redis Cluster hash tag, turning
+// keys like "prefix:access:<id>" into "{prefix}:access:<id>".
+//
+// SaveAccess and the revocation methods update several keys in one
+// transaction, which Redis Cluster only allows when all keys hash to the same
+// slot. Access and refresh tokens are looked up by their value alone, so the
+// only tag all related keys can share is the prefix. As a consequence every key
+// of a Storage lives in a single slot, and thus on a single node: this makes
+// the package usable on a cluster, but does not spread one Storage's data
+// across it. Use distinct prefixes to spread independent data sets. The
+// SCAN-based methods must be run against the node owning that slot.
+func WithClusterHashTag() Option {
+	return func(s *Storage) {
+		s.hashTag = true
+	}
+}
+
+// WithIDGenerator sets the function SaveAccess uses to mint the ID under which
+// access data is stored. It defaults to random UUIDv4 strings. IDs must be
+// unique and must not contain the key separator.
+func WithIDGenerator(newID func() string) Option {
+	if newID == nil {
+		panic("osinredis: nil ID generator")
+	}
+	return func(s *Storage) {
+		s.newID = newID
+	}
+}
+
+// WithClock sets the function used to read the current time when stamping
+// creation times and checking token expiry. It defaults to time.Now.
+// Operation latencies reported to an Observer are always measured with the
+// wall clock.
+func WithClock(now func() time.Time) Option {
+	if now == nil {
+		panic("osinredis: nil clock")
+	}
+	return func(s *Storage) {
+		s.now = now
+	}
+}
+
+// WithUsername sets the ACL username NewStorage authenticates as, together
+// with its password, on Redis 6 and later. NewTLSStorage and
+// NewSentinelStorage use it with a password set with WithDialOptions. It has
+// no effect on storages built from an existing pool.
+func WithUsername(username string) Option {
+	return func(s *Storage) {
+		s.username = username
+	}
+}
+
+// WithDialOptions adds redigo dial options, such as redis.DialPassword or
+// redis.DialUseTLS, to the connections NewStorage, NewTLSStorage and
+// NewSentinelStorage open to the Redis server. With NewSentinelStorage they
+// apply to the master, not to the sentinels. It has no effect on storages
+// built from an existing pool.
+func WithDialOptions(dialOpts ...redis.DialOption) Option {
+	return func(s *Storage) {
+		s.dialOptions = append(s.dialOptions, dialOpts...)
+	}
+}
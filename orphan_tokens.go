@@ -0,0 +1,53 @@
+package osinredis
+
+import (
+	"github.com/pkg/errors"
+)
+
+// OrphanTokenPolicy decides what loading access data does when a client it
+// references no longer exists, or when it was stored without a client.
+type OrphanTokenPolicy int
+
+const (
+	// OrphanTokenError fails the load with an error wrapping ErrNotFound that
+	// names the missing client. It is the default.
+	OrphanTokenError OrphanTokenPolicy = iota
+	// OrphanTokenInvalid treats the access data as if it did not exist: loads
+	// by token return ErrNotFound, as for any unknown token, and listings
+	// such as LoadClientTokens skip it.
+	OrphanTokenInvalid
+	// OrphanTokenKeep returns the access data with the missing client set to
+	// nil. osin itself requires a client, so this only suits callers that
+	// inspect tokens on their own.
+	OrphanTokenKeep
+)
+
+// errOrphanToken is returned, wrapped, by refreshAccessClients under
+// OrphanTokenInvalid.
+var errOrphanToken = errors.Wrap(ErrNotFound, "token not stored: its client no longer exists")
+
+// WithOrphanTokenPolicy sets what loading access data does when a client it
+// references, directly or through its authorize data, has been deleted, for
+// example so that a deleted client simply invalidates its tokens.
+func WithOrphanTokenPolicy(policy OrphanTokenPolicy) Option {
+	return func(s *Storage) {
+		s.orphanTokenPolicy = policy
+	}
+}
+
+// orphanClient applies the orphan token policy to err, the error of loading a
+// client referenced by access data. It returns nil if the access data should
+// be returned without the client.
+func (s *Storage) orphanClient(err error) error {
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	switch s.orphanTokenPolicy {
+	case OrphanTokenInvalid:
+		return errOrphanToken
+	case OrphanTokenKeep:
+		return nil
+	default:
+		return err
+	}
+}
@@ -0,0 +1,74 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func saveOrphanToken(t *testing.T, storage *Storage) string {
+	flushAll()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, storage.DeleteClient(client))
+	return accessData.AccessToken
+}
+
+func TestOrphanTokenError(t *testing.T) {
+	storage := initTestStorage()
+	token := saveOrphanToken(t, storage)
+
+	_, err := storage.LoadAccess(token)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Contains(t, err.Error(), `"clientID"`)
+
+	_, err = storage.LoadClientTokens("clientID")
+	assert.Error(t, err)
+}
+
+func TestOrphanTokenInvalid(t *testing.T) {
+	storage := New(pool, "test123", WithOrphanTokenPolicy(OrphanTokenInvalid))
+	token := saveOrphanToken(t, storage)
+
+	_, err := storage.LoadAccess(token)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	tokens, err := storage.LoadClientTokens("clientID")
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+}
+
+func TestOrphanTokenKeep(t *testing.T) {
+	storage := New(pool, "test123", WithOrphanTokenPolicy(OrphanTokenKeep))
+	token := saveOrphanToken(t, storage)
+
+	loadData, err := storage.LoadAccess(token)
+	assert.NoError(t, err)
+	assert.Nil(t, loadData.Client)
+	assert.Nil(t, loadData.AuthorizeData.Client)
+	assert.Equal(t, token, loadData.AccessToken)
+}
+
+func TestAccessWithoutClient(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	accessData := newAccessData(newAuthorizeData(newClient()))
+	accessData.Client = nil
+	accessData.AuthorizeData.Client = nil
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Contains(t, err.Error(), "access data has no client")
+
+	keep := New(pool, "test123", WithOrphanTokenPolicy(OrphanTokenKeep))
+	loadData, err := keep.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Nil(t, loadData.Client)
+	assert.Nil(t, loadData.AuthorizeData.Client)
+}
@@ -0,0 +1,94 @@
+package osinredis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer returns an osin.Server backed by storage that accepts
+// authorization code grants.
+func newTestServer(storage osin.Storage) *osin.Server {
+	config := osin.NewServerConfig()
+	config.AllowedAccessTypes = osin.AllowedAccessType{osin.AUTHORIZATION_CODE}
+	return osin.NewServer(config, storage)
+}
+
+// tokenRequest exchanges code at server, authenticating as clientID with
+// secret.
+func tokenRequest(server *osin.Server, clientID, secret, code string) *osin.Response {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {"http://localhost/"},
+	}
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(clientID, secret)
+
+	resp := server.NewResponse()
+	defer resp.Close()
+	if ar := server.HandleAccessRequest(resp, r); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, r, ar)
+	}
+	return resp
+}
+
+func TestOsinServerUnknownClient(t *testing.T) {
+	flushAll()
+
+	server := newTestServer(initTestStorage())
+
+	resp := tokenRequest(server, "unknown", "secret", "8888")
+	assert.True(t, resp.IsError)
+	assert.Equal(t, osin.E_UNAUTHORIZED_CLIENT, resp.ErrorId)
+
+	r := httptest.NewRequest(http.MethodGet, "/authorize?response_type=code&client_id=unknown&redirect_uri=http%3A%2F%2Flocalhost%2F", nil)
+	authResp := server.NewResponse()
+	defer authResp.Close()
+	assert.Nil(t, server.HandleAuthorizeRequest(authResp, r))
+	assert.Equal(t, osin.E_UNAUTHORIZED_CLIENT, authResp.ErrorId)
+}
+
+func TestOsinServerHashedSecret(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithSecretHasher(func(plaintext string) string {
+		return "hashed:" + plaintext
+	}))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	public := newClient()
+	public.Id = "public"
+	public.Secret = ""
+	assert.NoError(t, storage.CreateClient(public))
+
+	stored, err := storage.GetClient(client.Id)
+	assert.NoError(t, err)
+	authorizeData := newAuthorizeData(client)
+	authorizeData.Client = stored
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	server := newTestServer(storage)
+	resp := tokenRequest(server, client.Id, "wrong", "8888")
+	assert.Equal(t, osin.E_UNAUTHORIZED_CLIENT, resp.ErrorId)
+	resp = tokenRequest(server, client.Id, client.Secret, "8888")
+	assert.False(t, resp.IsError, resp.InternalError)
+	assert.NotEmpty(t, resp.Output["access_token"])
+
+	// Only the client without a secret is public.
+	server.Config.RequirePKCEForPublicClients = true
+	for id, public := range map[string]bool{client.Id: false, public.Id: true} {
+		r := httptest.NewRequest(http.MethodGet, "/authorize?response_type=code&client_id="+id+"&redirect_uri=http%3A%2F%2Flocalhost%2F", nil)
+		authResp := server.NewResponse()
+		ar := server.HandleAuthorizeRequest(authResp, r)
+		authResp.Close()
+		assert.Equal(t, public, ar == nil, id)
+	}
+}
@@ -0,0 +1,43 @@
+// Package osinredisprom adapts osinredis metrics hooks to Prometheus.
+package osinredisprom
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ShaleApps/osinredis"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Result label values.
+const (
+	ResultOK       = "ok"
+	ResultNotFound = "not_found"
+	ResultError    = "error"
+)
+
+// NewHistogramVec returns a histogram of storage operation latencies in
+// seconds, labeled by operation and result, suitable for NewObserver.
+func NewHistogramVec(opts prometheus.HistogramOpts) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(opts, []string{"operation", "result"})
+}
+
+// NewObserver returns an osinredis.Observer that records every operation in
+// vec, which must have exactly the labels "operation" and "result". The result
+// is one of ResultOK, ResultNotFound or ResultError.
+func NewObserver(vec *prometheus.HistogramVec) osinredis.Observer {
+	return osinredis.ObserverFunc(func(op string, duration time.Duration, err error) {
+		vec.WithLabelValues(op, result(err)).Observe(duration.Seconds())
+	})
+}
+
+func result(err error) string {
+	switch {
+	case err == nil:
+		return ResultOK
+	case errors.Is(err, osinredis.ErrNotFound):
+		return ResultNotFound
+	default:
+		return ResultError
+	}
+}
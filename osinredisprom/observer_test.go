@@ -0,0 +1,30 @@
+package osinredisprom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ShaleApps/osinredis"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserver(t *testing.T) {
+	vec := NewHistogramVec(prometheus.HistogramOpts{Name: "osinredis_operation_seconds"})
+	observer := NewObserver(vec)
+
+	observer.Observe("GetClient", time.Millisecond, nil)
+	observer.Observe("GetClient", time.Millisecond, errors.Wrap(osinredis.ErrNotFound, "client"))
+	observer.Observe("LoadAccess", time.Millisecond, errors.New("connection refused"))
+	observer.Observe("LoadAccess", time.Millisecond, nil)
+
+	assert.Equal(t, 4, testutil.CollectAndCount(vec))
+}
+
+func TestResult(t *testing.T) {
+	assert.Equal(t, ResultOK, result(nil))
+	assert.Equal(t, ResultNotFound, result(errors.Wrap(osinredis.ErrNotFound, "access")))
+	assert.Equal(t, ResultError, result(errors.New("connection refused")))
+}
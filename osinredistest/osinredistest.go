@@ -0,0 +1,42 @@
+// Package osinredistest provides osinredis storages backed by an in-memory
+// miniredis server, so that code built on osinredis can be tested without a
+// running Redis.
+package osinredistest
+
+import (
+	"github.com/ShaleApps/osinredis"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+// NewStorage starts a miniredis server and returns a Storage whose pool
+// connects to it, configured with the given key prefix and options, together
+// with a teardown function that closes the pool and stops the server.
+// miniredis implements every command the storage sends, including the
+// transactions, Lua scripts, SCAN and streams. Time does not pass on its own
+// in miniredis; use NewServer to fast-forward it and test expiry.
+func NewStorage(keyPrefix string, opts ...osinredis.Option) (*osinredis.Storage, func()) {
+	storage, _, teardown := NewServer(keyPrefix, opts...)
+	return storage, teardown
+}
+
+// NewServer is NewStorage that also returns the miniredis server, for tests
+// that inspect the stored keys or call FastForward to let TTLs expire.
+func NewServer(keyPrefix string, opts ...osinredis.Option) (*osinredis.Storage, *miniredis.Miniredis, func()) {
+	server, err := miniredis.Run()
+	if err != nil {
+		panic("osinredistest: failed to start miniredis: " + err.Error())
+	}
+
+	pool := &redis.Pool{
+		MaxIdle: 3,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", server.Addr())
+		},
+	}
+	teardown := func() {
+		pool.Close()
+		server.Close()
+	}
+	return osinredis.New(pool, keyPrefix, opts...), server, teardown
+}
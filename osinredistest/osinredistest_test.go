@@ -0,0 +1,50 @@
+package osinredistest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ShaleApps/osinredis"
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStorage(t *testing.T) {
+	storage, teardown := NewStorage("test")
+	defer teardown()
+
+	client := &osin.DefaultClient{Id: "client", Secret: "secret", RedirectUri: "http://localhost/"}
+	assert.NoError(t, storage.CreateClient(client))
+
+	access := &osin.AccessData{Client: client, AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 60, CreatedAt: time.Now()}
+	assert.NoError(t, storage.SaveAccess(access))
+
+	loaded, err := storage.LoadAccess("access")
+	assert.NoError(t, err)
+	assert.Equal(t, client, loaded.Client)
+
+	clients, _, err := storage.ListClients(0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+
+	_, err = storage.LoadAccessAndExtend("access", time.Minute)
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.RemoveRefresh("refresh"))
+	_, err = storage.LoadAccess("access")
+	assert.True(t, errors.Is(err, osinredis.ErrNotFound))
+}
+
+func TestNewServerExpiry(t *testing.T) {
+	storage, server, teardown := NewServer("test")
+	defer teardown()
+
+	client := &osin.DefaultClient{Id: "client"}
+	assert.NoError(t, storage.CreateClient(client))
+	assert.NoError(t, storage.SaveAuthorize(&osin.AuthorizeData{Client: client, Code: "code", ExpiresIn: 60, CreatedAt: time.Now()}))
+
+	server.FastForward(time.Minute)
+	_, err := storage.LoadAuthorize("code")
+	assert.True(t, errors.Is(err, osinredis.ErrNotFound))
+}
@@ -0,0 +1,72 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// sendSaveChallenge queues the commands that store the PKCE code challenge of
+// data in a hash next to its authorize data, expiring with it after ttl
+// seconds, and returns how many it queued. Codes without a challenge get no
+// hash.
+func (s *Storage) sendSaveChallenge(conn redis.Conn, data *osin.AuthorizeData, ttl int64) int {
+	if data.CodeChallenge == "" {
+		return 0
+	}
+	key := s.tokenKey("auth_challenge", data.Code)
+	conn.Send("HSET", key, "challenge", data.CodeChallenge, "method", data.CodeChallengeMethod)
+	conn.Send("EXPIRE", key, ttl)
+	return 2
+}
+
+// GetAuthorizeChallenge returns the PKCE code challenge and challenge method
+// of the authorize data of code, without decoding the authorize data or
+// loading its client. Both are empty for a code issued without a challenge.
+// Like LoadAuthorize it returns ErrCodeRevoked for a code removed recently and
+// ErrNotFound for a code that does not exist.
+//
+// The challenge is kept in a small hash written by SaveAuthorize and
+// SaveAuthorizeNX. For codes stored without it, such as codes without a
+// challenge or saved by an earlier version, the authorize data is decoded
+// instead.
+func (s *Storage) GetAuthorizeChallenge(code string) (challenge, method string, err error) {
+	defer s.observe("GetAuthorizeChallenge", time.Now(), &err)
+	s, span := s.startSpan("GetAuthorizeChallenge", "HMGET", "auth_challenge")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return "", "", err
+	}
+
+	defer conn.Close()
+
+	fields, err := redis.Values(conn.Do("HMGET", s.tokenKey("auth_challenge", code), "challenge", "method"))
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to get auth challenge")
+	}
+	if fields[0] != nil {
+		if _, err := redis.Scan(fields, &challenge, &method); err != nil {
+			return "", "", errors.Wrap(err, "unable to parse auth challenge")
+		}
+		return challenge, method, nil
+	}
+
+	key := s.tokenKey("auth", code)
+	authGob, err := redis.Bytes(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		return "", "", s.authorizeMiss(conn, code)
+	}
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to GET auth")
+	}
+
+	var auth osin.AuthorizeData
+	if err := s.decodeRecord(s.tokenLogKey("auth", code), authGob, &auth); err != nil {
+		return "", "", errors.Wrap(err, "failed to decode auth")
+	}
+	return auth.CodeChallenge, auth.CodeChallengeMethod, nil
+}
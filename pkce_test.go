@@ -0,0 +1,82 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAuthorizeChallenge(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	authorizeData.CodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	authorizeData.CodeChallengeMethod = "S256"
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	ttl, err := redis.Int(conn.Do("TTL", storage.makeKey("auth_challenge", authorizeData.Code)))
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+
+	challenge, method, err := storage.GetAuthorizeChallenge(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, authorizeData.CodeChallenge, challenge)
+	assert.Equal(t, "S256", method)
+
+	assert.NoError(t, storage.RemoveAuthorize(authorizeData.Code))
+	exists, err := redis.Bool(conn.Do("EXISTS", storage.makeKey("auth_challenge", authorizeData.Code)))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	_, _, err = storage.GetAuthorizeChallenge(authorizeData.Code)
+	assert.True(t, errors.Is(err, ErrCodeRevoked))
+
+	_, _, err = storage.GetAuthorizeChallenge("missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestGetAuthorizeChallengeWithoutHash(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	plain := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(plain))
+	challenge, method, err := storage.GetAuthorizeChallenge(plain.Code)
+	assert.NoError(t, err)
+	assert.Empty(t, challenge)
+	assert.Empty(t, method)
+
+	// Codes saved before the hash existed are read from the authorize data.
+	legacy := newAuthorizeData(client)
+	legacy.Code = "legacyCode"
+	legacy.CodeChallenge = "challenge"
+	legacy.CodeChallengeMethod = "plain"
+	assert.NoError(t, storage.SaveAuthorizeNX(legacy))
+
+	conn := pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("DEL", storage.makeKey("auth_challenge", legacy.Code))
+	assert.NoError(t, err)
+
+	challenge, method, err = storage.GetAuthorizeChallenge(legacy.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, "challenge", challenge)
+	assert.Equal(t, "plain", method)
+
+	_, err = storage.LoadAndRemoveAuthorize(legacy.Code)
+	assert.NoError(t, err)
+	_, _, err = storage.GetAuthorizeChallenge(legacy.Code)
+	assert.True(t, errors.Is(err, ErrCodeRevoked))
+}
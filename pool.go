@@ -0,0 +1,182 @@
+package osinredis
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// newPool returns a pool with sensible defaults for the pool-constructing
+// helpers.
+func newPool(dial func() (redis.Conn, error)) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 240 * time.Second,
+		Dial:        dial,
+		// Check connections that sat idle for a while, so stale ones are
+		// replaced before they are handed out.
+		TestOnBorrow: func(conn redis.Conn, idleSince time.Time) error {
+			if time.Since(idleSince) < time.Minute {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+}
+
+// PoolStats returns the connection statistics of the pool the Storage was
+// constructed with, or zero statistics for storages without a redigo pool,
+// such as those created with NewGoRedis.
+func (s *Storage) PoolStats() redis.PoolStats {
+	if s.pool == nil {
+		return redis.PoolStats{}
+	}
+	return s.pool.Stats()
+}
+
+// NewStorage initializes and returns a new Storage whose pool connects to the
+// Redis server at addr. If password is not empty every new connection is
+// authenticated with AUTH, as the user set with WithUsername if any, and fails
+// to dial if the server rejects the credentials.
+func NewStorage(addr, password, keyPrefix string, opts ...Option) *Storage {
+	return dialStorage(addr, keyPrefix, opts, redis.DialPassword(password))
+}
+
+// NewTLSStorage initializes and returns a new Storage whose pool connects to
+// the Redis server at addr over TLS, as required by managed Redis offerings
+// with in-transit encryption. Certificate verification is configured through
+// tlsConfig, which may be nil to verify against the system roots.
+func NewTLSStorage(addr string, tlsConfig *tls.Config, keyPrefix string, opts ...Option) *Storage {
+	return dialStorage(addr, keyPrefix, opts, redis.DialUseTLS(true), redis.DialTLSConfig(tlsConfig))
+}
+
+// dialStorage returns a Storage whose pool dials addr with the given options,
+// adding the user set with WithUsername and those set with WithDialOptions.
+func dialStorage(addr, keyPrefix string, opts []Option, dialOpts ...redis.DialOption) *Storage {
+	s := newStorage(keyPrefix, opts)
+	dialOpts = s.extendDialOptions(dialOpts)
+	s.pool = newPool(func() (redis.Conn, error) {
+		return dial(addr, dialOpts...)
+	})
+	return s
+}
+
+// extendDialOptions appends the user set with WithUsername, if any, and the
+// options set with WithDialOptions to dialOpts.
+func (s *Storage) extendDialOptions(dialOpts []redis.DialOption) []redis.DialOption {
+	if s.username != "" {
+		dialOpts = append(dialOpts, redis.DialUsername(s.username))
+	}
+	return append(dialOpts, s.dialOptions...)
+}
+
+// dial connects to the Redis server at addr and pins the connection to the
+// RESP2 protocol with HELLO 2, since redigo cannot parse RESP3 replies. This
+// keeps the protocol explicit should a server default to RESP3. Servers
+// older than Redis 6 do not know HELLO and speak RESP2 anyway, so an unknown
+// command error is ignored. Applications that need RESP3, for example for
+// client-side caching, can use NewGoRedis with a go-redis client, which
+// negotiates it by default.
+func dial(addr string, dialOpts ...redis.DialOption) (redis.Conn, error) {
+	conn, err := redis.Dial("tcp", addr, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Do("HELLO", 2); err != nil && !isUnknownCommand(err) {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to negotiate RESP2")
+	}
+	return conn, nil
+}
+
+// NewSentinelStorage initializes and returns a new Storage whose pool connects
+// to the master of a Sentinel-managed deployment. Every new connection asks
+// the sentinels, in order, for the current address of masterName, so after a
+// failover new connections go to the promoted master. Credentials and TLS for
+// the master are set with WithUsername and WithDialOptions. A connection is
+// only used while the server reports the master role: one to a server the
+// sentinels no longer consider the master, or to a master demoted since, is
+// discarded when dialed or borrowed from the pool, at the cost of a ROLE
+// round trip per borrow.
+func NewSentinelStorage(sentinelAddrs []string, masterName, keyPrefix string, opts ...Option) *Storage {
+	s := newStorage(keyPrefix, opts)
+	dialOpts := s.extendDialOptions(nil)
+	s.pool = newPool(func() (redis.Conn, error) {
+		addr, err := sentinelMasterAddr(sentinelAddrs, masterName)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dial(addr, dialOpts...)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkMaster(conn); err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "sentinel master %q at %s", masterName, addr)
+		}
+		return conn, nil
+	})
+	s.pool.TestOnBorrow = func(conn redis.Conn, _ time.Time) error {
+		return checkMaster(conn)
+	}
+	return s
+}
+
+// checkMaster returns an error unless the server conn is connected to reports
+// the master role.
+func checkMaster(conn redis.Conn) error {
+	reply, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return errors.Wrap(err, "failed to query role")
+	}
+	if len(reply) == 0 {
+		return errors.New("empty ROLE reply")
+	}
+	role, err := redis.String(reply[0], nil)
+	if err != nil {
+		return errors.Wrap(err, "unexpected ROLE reply")
+	}
+	if role != "master" {
+		return errors.Errorf("server has role %q, not master", role)
+	}
+	return nil
+}
+
+// sentinelMasterAddr asks each sentinel in turn for the address of the named
+// master and returns the first answer.
+func sentinelMasterAddr(sentinelAddrs []string, masterName string) (string, error) {
+	var lastErr error = errors.New("no sentinel addresses configured")
+	for _, sentinelAddr := range sentinelAddrs {
+		addr, err := querySentinel(sentinelAddr, masterName)
+		if err == nil {
+			return addr, nil
+		}
+		lastErr = err
+	}
+	return "", errors.Wrapf(lastErr, "failed to resolve master %q", masterName)
+}
+
+func querySentinel(sentinelAddr, masterName string) (string, error) {
+	conn, err := redis.Dial("tcp", sentinelAddr, redis.DialConnectTimeout(time.Second))
+	if err != nil {
+		return "", err
+	}
+
+	defer conn.Close()
+
+	hostPort, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", masterName))
+	if err == redis.ErrNil {
+		return "", errors.Errorf("sentinel %s does not know master %q", sentinelAddr, masterName)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(hostPort) != 2 {
+		return "", errors.Errorf("unexpected sentinel reply %v", hostPort)
+	}
+	return net.JoinHostPort(hostPort[0], hostPort[1]), nil
+}
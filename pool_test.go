@@ -0,0 +1,243 @@
+package osinredis
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSentinel answers every command with the given master address, which is
+// all NewSentinelStorage asks of a sentinel.
+func fakeSentinel(t *testing.T, host, port string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				// SENTINEL get-master-addr-by-name <name> arrives as a
+				// three element array: a header line plus two lines each.
+				for i := 0; i < 7; i++ {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+				}
+				fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// fakeRedis serves the Redis protocol, answering each command with the raw
+// reply reply returns for its arguments.
+func fakeRedis(t *testing.T, reply func(args []string) string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					var n int
+					if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+						return
+					}
+					args := make([]string, n)
+					for i := range args {
+						var size int
+						if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+							return
+						}
+						buf := make([]byte, size+2)
+						if _, err := io.ReadFull(r, buf); err != nil {
+							return
+						}
+						args[i] = string(buf[:size])
+					}
+					fmt.Fprint(conn, reply(args))
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// fakeLegacyRedis answers like a server older than Redis 6: HELLO is an
+// unknown command and every other command gets +PONG.
+func fakeLegacyRedis(t *testing.T) string {
+	return fakeRedis(t, func(args []string) string {
+		if args[0] == "HELLO" {
+			return "-ERR unknown command 'HELLO'\r\n"
+		}
+		return "+PONG\r\n"
+	})
+}
+
+func testRedisAddr() string {
+	if redisAddr := os.Getenv("REDIS_ADDR"); redisAddr != "" {
+		return redisAddr
+	}
+	return ":6379"
+}
+
+func TestNewStorage(t *testing.T) {
+	flushAll()
+
+	storage := NewStorage(testRedisAddr(), "", "test123")
+	assert.NoError(t, storage.Ping())
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	_, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+
+	// Servers without HELLO are still dialed.
+	legacy := NewStorage(fakeLegacyRedis(t), "", "test123")
+	assert.NoError(t, legacy.Ping())
+
+	// The test server has no password configured, so AUTH fails the dial.
+	rejected := NewStorage(testRedisAddr(), "wrong", "test123", WithUsername("nobody"))
+	assert.Error(t, rejected.Ping())
+}
+
+func TestNewSentinelStorage(t *testing.T) {
+	var mu sync.Mutex
+	role, password := "master", ""
+	setRole := func(r string) {
+		mu.Lock()
+		defer mu.Unlock()
+		role = r
+	}
+	master := fakeRedis(t, func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch args[0] {
+		case "AUTH":
+			password = args[len(args)-1]
+			return "+OK\r\n"
+		case "ROLE":
+			return fmt.Sprintf("*3\r\n$%d\r\n%s\r\n:0\r\n*0\r\n", len(role), role)
+		}
+		return "+PONG\r\n"
+	})
+	host, port, err := net.SplitHostPort(master)
+	assert.NoError(t, err)
+
+	storage := NewSentinelStorage([]string{"127.0.0.1:1", fakeSentinel(t, host, port)}, "mymaster", "test123",
+		WithDialOptions(redis.DialPassword("secret")))
+	assert.NoError(t, storage.Ping())
+	mu.Lock()
+	assert.Equal(t, "secret", password)
+	mu.Unlock()
+
+	// A pooled connection to a demoted master is discarded, and so is a new
+	// one to a server that is not the master.
+	setRole("slave")
+	assert.Error(t, storage.Ping())
+
+	setRole("master")
+	assert.NoError(t, storage.Ping())
+
+	unresolvable := NewSentinelStorage([]string{"127.0.0.1:1"}, "mymaster", "test123")
+	assert.Error(t, unresolvable.Ping())
+}
+
+// tlsProxy terminates TLS in front of the test server and returns the
+// proxy's address and a client config trusting its certificate.
+func tlsProxy(t *testing.T) (string, *tls.Config) {
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				upstream, err := net.Dial("tcp", testRedisAddr())
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	return l.Addr().String(), &tls.Config{RootCAs: roots}
+}
+
+func TestNewTLSStorage(t *testing.T) {
+	flushAll()
+
+	addr, tlsConfig := tlsProxy(t)
+
+	storage := NewTLSStorage(addr, tlsConfig, "test123")
+	assert.NoError(t, storage.Ping())
+
+	untrusted := NewTLSStorage(addr, &tls.Config{}, "test123")
+	assert.Error(t, untrusted.Ping())
+
+	insecure := NewTLSStorage(addr, &tls.Config{InsecureSkipVerify: true}, "test123")
+	assert.NoError(t, insecure.Ping())
+}
+
+func TestPoolStats(t *testing.T) {
+	p := &redis.Pool{MaxIdle: 1, Dial: pool.Dial}
+	defer p.Close()
+	storage := New(p, "test123")
+
+	assert.NoError(t, storage.Ping())
+	stats := storage.PoolStats()
+	assert.Equal(t, 1, stats.ActiveCount)
+	assert.Equal(t, 1, stats.IdleCount)
+
+	conn := p.Get()
+	defer conn.Close()
+	assert.Equal(t, 0, storage.PoolStats().IdleCount)
+
+	assert.Equal(t, redis.PoolStats{}, NewGoRedis(nil, "test123").PoolStats())
+}
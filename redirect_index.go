@@ -0,0 +1,166 @@
+package osinredis
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// redirectURIs splits the redirect URI of a client into the URIs it
+// registers. osin allows a client to register several, separated by spaces.
+func redirectURIs(redirectURI string) []string {
+	return strings.Fields(redirectURI)
+}
+
+// storedRedirectURIs returns the redirect URIs of the clients currently
+// stored under ids, in order. Missing clients and clients that fail to decode
+// have none.
+func (s *Storage) storedRedirectURIs(conn redis.Conn, ids []string) ([][]string, error) {
+	keys := make([]interface{}, len(ids))
+	for i, id := range ids {
+		keys[i] = s.makeKey("client", id)
+	}
+
+	raws, err := redis.ByteSlices(conn.Do("MGET", keys...))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read stored clients")
+	}
+
+	uris := make([][]string, len(ids))
+	for i, raw := range raws {
+		if raw == nil {
+			continue
+		}
+		var client osin.DefaultClient
+		if err := s.decodeRecord(keys[i].(string), raw, &client); err != nil {
+			continue
+		}
+		uris[i] = redirectURIs(client.RedirectUri)
+	}
+	return uris, nil
+}
+
+// clientRedirectURIs returns the redirect URIs of the client stored under id.
+func (s *Storage) clientRedirectURIs(id string) ([]string, error) {
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	uris, err := s.storedRedirectURIs(conn, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	return uris[0], nil
+}
+
+// unindexRedirects drops the client id from the redirect index of each of
+// uris.
+func (s *Storage) unindexRedirects(id string, uris []string) error {
+	if len(uris) == 0 {
+		return nil
+	}
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	s.sendIndexRedirects(conn, id, uris, nil)
+	if _, err := flush(conn); err != nil {
+		return errors.Wrap(err, "failed to update redirect index")
+	}
+	return nil
+}
+
+// sendIndexRedirects queues the commands that move the client id from the
+// redirect index of each of the old URIs to that of each of the new ones, and
+// returns how many it queued.
+func (s *Storage) sendIndexRedirects(conn redis.Conn, id string, old, new []string) int {
+	keep := make(map[string]bool, len(new))
+	for _, uri := range new {
+		keep[uri] = true
+	}
+
+	sent := 0
+	for _, uri := range old {
+		if !keep[uri] {
+			conn.Send("SREM", s.makeKey("redirect", uri), id)
+			sent++
+		}
+	}
+	for _, uri := range new {
+		conn.Send("SADD", s.makeKey("redirect", uri), id)
+		sent++
+	}
+	return sent
+}
+
+// FindClientsByRedirectURI returns the clients that registered uri as one of
+// their redirect URIs, ordered by ID, for example to detect several clients
+// sharing a redirect URI. The index is kept by CreateClient, CreateClients,
+// UpdateClient and DeleteClient; entries it finds stale are dropped.
+func (s *Storage) FindClientsByRedirectURI(uri string) (_ []osin.Client, err error) {
+	defer s.observe("FindClientsByRedirectURI", time.Now(), &err)
+	s, span := s.startSpan("FindClientsByRedirectURI", "SMEMBERS", "redirect")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	indexKey := s.makeKey("redirect", uri)
+	ids, err := redis.Strings(conn.Do("SMEMBERS", indexKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read redirect index")
+	}
+	sort.Strings(ids)
+
+	var (
+		clients []osin.Client
+		stale   []interface{}
+	)
+	for _, id := range ids {
+		client, err := s.getClient(conn, id)
+		if errors.Is(err, ErrNotFound) {
+			stale = append(stale, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !hasRedirectURI(client, uri) {
+			stale = append(stale, id)
+			continue
+		}
+		clients = append(clients, client)
+	}
+
+	if len(stale) > 0 {
+		if _, err := conn.Do("SREM", append([]interface{}{indexKey}, stale...)...); err != nil {
+			return nil, errors.Wrap(err, "failed to prune redirect index")
+		}
+	}
+
+	return clients, nil
+}
+
+func hasRedirectURI(client osin.Client, uri string) bool {
+	for _, u := range redirectURIs(client.GetRedirectUri()) {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
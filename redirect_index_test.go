@@ -0,0 +1,56 @@
+package osinredis
+
+import (
+	"testing"
+
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func redirectClientIDs(t *testing.T, storage *Storage, uri string) []string {
+	clients, err := storage.FindClientsByRedirectURI(uri)
+	assert.NoError(t, err)
+
+	var ids []string
+	for _, client := range clients {
+		ids = append(ids, client.GetId())
+	}
+	return ids
+}
+
+func TestFindClientsByRedirectURI(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	a := &osin.DefaultClient{Id: "a", RedirectUri: "http://a/ http://shared/"}
+	b := &osin.DefaultClient{Id: "b", RedirectUri: "http://shared/"}
+	assert.NoError(t, storage.CreateClient(a))
+	assert.NoError(t, storage.CreateClients([]osin.Client{b}))
+
+	assert.Equal(t, []string{"a", "b"}, redirectClientIDs(t, storage, "http://shared/"))
+	assert.Equal(t, []string{"a"}, redirectClientIDs(t, storage, "http://a/"))
+	assert.Empty(t, redirectClientIDs(t, storage, "http://unknown/"))
+
+	a.RedirectUri = "http://a2/"
+	assert.NoError(t, storage.UpdateClient(a))
+	assert.Equal(t, []string{"b"}, redirectClientIDs(t, storage, "http://shared/"))
+	assert.Empty(t, redirectClientIDs(t, storage, "http://a/"))
+	assert.Equal(t, []string{"a"}, redirectClientIDs(t, storage, "http://a2/"))
+
+	assert.NoError(t, storage.DeleteClient(b))
+	assert.Empty(t, redirectClientIDs(t, storage, "http://shared/"))
+}
+
+func TestFindClientsByRedirectURICascadingDelete(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithCascadingClientDelete())
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	assert.Equal(t, []string{client.Id}, redirectClientIDs(t, storage, client.RedirectUri))
+
+	assert.NoError(t, storage.DeleteClient(client))
+	assert.Empty(t, redirectClientIDs(t, storage, client.RedirectUri))
+}
@@ -0,0 +1,139 @@
+package osinredis
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// maxRetryDelay caps the backoff between two attempts of WithRetry.
+const maxRetryDelay = 2 * time.Second
+
+// WithRetry makes the storage retry transient Redis failures up to attempts
+// times in total, waiting baseDelay before the second attempt and doubling the
+// wait before each further one, up to 2s. Transient failures are network
+// errors, redis.ErrPoolExhausted and the LOADING, READONLY, MASTERDOWN and
+// TRYAGAIN replies seen during failovers; logical errors such as ErrNotFound
+// and decode failures are never retried.
+//
+// Obtaining a connection is retried for every method. Failed commands are
+// retried only by the methods that are safe to repeat: GetClient,
+// LoadAuthorize, LoadAccess, LoadAccessInfo, LoadRefresh, SaveAuthorize and
+// RemoveAuthorize. Methods whose writes may or may not have been applied when
+// the error occurred, such as SaveAccess, SaveAuthorize with
+// WithCodeReuseCheck, and CreateClient and UpdateClient, which bump the client
+// version, are not repeated. Waits end early when the context bound with
+// WithContext is done.
+func WithRetry(attempts int, baseDelay time.Duration) Option {
+	if attempts < 1 {
+		panic("osinredis: retry attempts must be positive")
+	}
+	if baseDelay < 0 {
+		panic("osinredis: negative retry delay")
+	}
+	return func(s *Storage) {
+		s.retryAttempts = attempts
+		s.retryDelay = baseDelay
+	}
+}
+
+// retry runs op until it succeeds, fails with an error that is not
+// transient, or the attempts configured with WithRetry are used up.
+func (s *Storage) retry(op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !s.backoff(attempt, err) {
+			return err
+		}
+	}
+}
+
+// retryConn obtains a connection with get, retrying transient failures.
+func (s *Storage) retryConn(get func() redis.Conn) redis.Conn {
+	for attempt := 1; ; attempt++ {
+		conn := get()
+		err := conn.Err()
+		if err == nil {
+			return conn
+		}
+		if !s.backoff(attempt, err) {
+			if attempt > 1 {
+				// Keep retry from starting over on this error.
+				return failedConn{conn, retriedError{err}}
+			}
+			return conn
+		}
+		conn.Close()
+	}
+}
+
+// retriedError marks an error that has already been retried, or that must not
+// be because the command may have taken effect.
+type retriedError struct {
+	err error
+}
+
+func (e retriedError) Error() string { return e.err.Error() }
+func (e retriedError) Unwrap() error { return e.err }
+
+// failedConn is a connection that could not be obtained.
+type failedConn struct {
+	redis.Conn
+	err error
+}
+
+func (c failedConn) Err() error { return c.err }
+
+// backoff reports whether attempt, which failed with err, should be followed
+// by another one, after waiting for it.
+func (s *Storage) backoff(attempt int, err error) bool {
+	if attempt >= s.retryAttempts || !isTransient(err) {
+		return false
+	}
+
+	delay := s.retryDelay << (attempt - 1)
+	if delay > maxRetryDelay || delay < 0 {
+		delay = maxRetryDelay
+	}
+	s.logger.Log(LevelWarn, "retrying after transient error", "attempt", attempt, "delay", delay, "err", err)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.context().Done():
+		return false
+	}
+}
+
+// transientReplies are the prefixes of Redis error replies that go away once
+// a failover or restart completes.
+var transientReplies = []string{"LOADING", "READONLY", "MASTERDOWN", "TRYAGAIN"}
+
+func isTransient(err error) bool {
+	var retried retriedError
+	if errors.As(err, &retried) || errors.Is(err, ErrUnavailable) {
+		return false
+	}
+	if errors.Is(err, redis.ErrPoolExhausted) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var reply redis.Error
+	if errors.As(err, &reply) {
+		for _, prefix := range transientReplies {
+			if strings.HasPrefix(string(reply), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
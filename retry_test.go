@@ -0,0 +1,165 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyConn fails the first failures GET commands with a LOADING reply.
+type flakyConn struct {
+	redis.Conn
+	failures *int
+	gets     *int
+}
+
+func (c flakyConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "GET" {
+		*c.gets++
+		if *c.failures > 0 {
+			*c.failures--
+			return nil, redis.Error("LOADING Redis is loading the dataset in memory")
+		}
+	}
+	return c.Conn.Do(commandName, args...)
+}
+
+func newFlakyStorage(failures int, opts ...Option) (*Storage, *int) {
+	var gets int
+	storage := New(pool, "test123", opts...)
+	storage.connFunc = func() redis.Conn {
+		return flakyConn{pool.Get(), &failures, &gets}
+	}
+	return storage, &gets
+}
+
+func TestWithRetry(t *testing.T) {
+	flushAll()
+
+	client := newClient()
+	assert.NoError(t, initTestStorage().CreateClient(client))
+
+	storage, gets := newFlakyStorage(2, WithRetry(3, time.Millisecond))
+	_, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, *gets)
+
+	storage, gets = newFlakyStorage(2, WithRetry(2, time.Millisecond))
+	_, err = storage.GetClient(client.GetId())
+	assert.Error(t, err)
+	assert.Equal(t, 2, *gets)
+
+	// Without WithRetry nothing is retried.
+	storage, gets = newFlakyStorage(1)
+	_, err = storage.GetClient(client.GetId())
+	assert.Error(t, err)
+	assert.Equal(t, 1, *gets)
+
+	// Logical errors are not retried.
+	storage, gets = newFlakyStorage(0, WithRetry(3, time.Millisecond))
+	_, err = storage.GetClient("missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, 1, *gets)
+
+	assert.Panics(t, func() { WithRetry(0, time.Millisecond) })
+	assert.Panics(t, func() { WithRetry(1, -time.Millisecond) })
+}
+
+func TestWithRetryPoolExhausted(t *testing.T) {
+	flushAll()
+
+	exhausted := &redis.Pool{Dial: pool.Dial, MaxActive: 1}
+	defer exhausted.Close()
+	storage := New(exhausted, "test123", WithRetry(10, 10*time.Millisecond))
+
+	held := exhausted.Get()
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		held.Close()
+	}()
+
+	assert.NoError(t, storage.CreateClient(newClient()))
+
+	stillHeld := exhausted.Get()
+	defer stillHeld.Close()
+	_, err := New(exhausted, "test123", WithRetry(2, time.Millisecond)).GetClient("clientID")
+	assert.True(t, errors.Is(err, redis.ErrPoolExhausted))
+}
+
+// lostReplyConn runs command but reports its reply as lost.
+type lostReplyConn struct {
+	redis.Conn
+	command string
+	calls   *int
+}
+
+func (c lostReplyConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(commandName, args...)
+	if commandName == c.command {
+		*c.calls++
+		return nil, redis.Error("LOADING Redis is loading the dataset in memory")
+	}
+	return reply, err
+}
+
+func TestWithRetryCodeReuseCheck(t *testing.T) {
+	flushAll()
+
+	client := newClient()
+	assert.NoError(t, initTestStorage().CreateClient(client))
+
+	var execs int
+	storage := New(pool, "test123", WithRetry(3, time.Millisecond), WithCodeReuseCheck())
+	storage.connFunc = func() redis.Conn {
+		return lostReplyConn{pool.Get(), "EXEC", &execs}
+	}
+
+	// The code was saved, so a retry would wrongly report it as reused.
+	err := storage.SaveAuthorize(newAuthorizeData(client))
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrCodeExists))
+	assert.Equal(t, 1, execs)
+
+	_, err = initTestStorage().LoadAuthorize("8888")
+	assert.NoError(t, err)
+}
+
+func TestWithRetryRemoveAuthorize(t *testing.T) {
+	flushAll()
+
+	client := newClient()
+	assert.NoError(t, initTestStorage().CreateClient(client))
+	assert.NoError(t, initTestStorage().SaveAuthorize(newAuthorizeData(client)))
+
+	var evals int
+	storage := New(pool, "test123", WithRetry(2, time.Millisecond))
+	storage.connFunc = func() redis.Conn {
+		return lostReplyConn{pool.Get(), "EVALSHA", &evals}
+	}
+	assert.Error(t, storage.RemoveAuthorize("8888"))
+	assert.Equal(t, 2, evals)
+
+	// The code was deleted and marked revoked by the first attempt.
+	_, err := initTestStorage().LoadAuthorize("8888")
+	assert.True(t, errors.Is(err, ErrCodeRevoked))
+}
+
+func TestWithRetryCreateClient(t *testing.T) {
+	flushAll()
+
+	var flushes int
+	storage := New(pool, "test123", WithRetry(3, time.Millisecond))
+	storage.connFunc = func() redis.Conn {
+		return lostReplyConn{pool.Get(), "", &flushes}
+	}
+	assert.Error(t, storage.UpdateClient(newClient()))
+	assert.Equal(t, 1, flushes)
+
+	// The lost write was not repeated, so the version was bumped once.
+	version, err := initTestStorage().GetClientVersion("clientID")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), version)
+}
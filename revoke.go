@@ -0,0 +1,207 @@
+package osinredis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// revokeAccessScript deletes KEYS[1] through KEYS[ARGV[2]], removes the
+// access ID ARGV[3] from the sorted set KEYS[ARGV[2]+1] and from the sets in
+// the remaining keys, but only if KEYS[1], the access key, still holds ARGV[1]
+// or ARGV[1] is empty. It returns {1} if it removed the access data and
+// {0, current} if the access data changed since it was read, where current is
+// what the access key holds now, or nil if it is gone. The keys share a hash
+// slot only when WithClusterHashTag is set.
+var revokeAccessScript = redis.NewScript(-1, `
+if ARGV[1] ~= "" then
+	local current = redis.call("GET", KEYS[1])
+	if current ~= ARGV[1] then
+		return {0, current}
+	end
+end
+local ndel = tonumber(ARGV[2])
+redis.call("DEL", unpack(KEYS, 1, ndel))
+redis.call("ZREM", KEYS[ndel + 1], ARGV[3])
+for i = ndel + 2, #KEYS do
+	redis.call("SREM", KEYS[i], ARGV[3])
+end
+return {1}
+`)
+
+// revokeAccess atomically deletes the access data stored under accessID
+// together with its token pointers and index entries, provided the access key
+// still holds accessGob, the encoding access was decoded from. It reports
+// whether it did, so that a concurrent change makes the caller retry instead
+// of deleting keys of a half-updated state. If it did not, it also returns
+// what the access key holds now, nil if it is gone, so that the retry does not
+// have to read it again. A nil accessGob removes the access data whatever it
+// holds. The script is run with EVALSHA, falling back to EVAL when the server
+// does not have it cached yet.
+func (s *Storage) revokeAccess(conn redis.Conn, accessID string, accessGob []byte, access *osin.AccessData) (revoked bool, current []byte, err error) {
+	deleted := s.accessDataKeys(accessID, access)
+	keys := append(append(deleted, s.makeNamespaceKey("access_by_created")), s.tokenIndexKeys(access)...)
+
+	args := redis.Args{len(keys)}.Add(keys...).Add(accessGob, len(deleted), accessID)
+	reply, err := redis.Values(revokeAccessScript.Do(conn, args...))
+	if err != nil {
+		return false, nil, err
+	}
+	if len(reply) > 1 {
+		current, _ = redis.Bytes(reply[1], nil)
+	}
+	return len(reply) == 1, current, nil
+}
+
+// holdsToken reports whether token is the token of the given namespace,
+// "access_token" or "refresh_token", of access.
+func holdsToken(access *osin.AccessData, namespace, token string) bool {
+	if namespace == "access_token" {
+		return access.AccessToken == token
+	}
+	return access.RefreshToken == token
+}
+
+// revokeIndexedScript removes a chunk of the access data listed in the token
+// index KEYS[1]. ARGV[1] is the number of access data in the chunk, and each
+// is described by four further arguments: its access ID, the encoding it was
+// read with, empty if it was missing, and the number of keys to delete and of
+// token indexes to drop it from. Those keys follow in KEYS, the access key
+// first. Access data whose access key no longer holds the encoding it was read
+// with is left alone. The script returns the IDs of the access data it
+// removed. The keys share a hash slot only when WithClusterHashTag is set.
+var revokeIndexedScript = redis.NewScript(-1, `
+local removed = {}
+local k = 2
+local a = 2
+for i = 1, tonumber(ARGV[1]) do
+	local id, expected = ARGV[a], ARGV[a + 1]
+	local ndel, nidx = tonumber(ARGV[a + 2]), tonumber(ARGV[a + 3])
+	a = a + 4
+	local current = redis.call("GET", KEYS[k])
+	if (expected == "" and not current) or current == expected then
+		redis.call("DEL", unpack(KEYS, k, k + ndel - 1))
+		redis.call("ZREM", KEYS[k + ndel], id)
+		for j = k + ndel + 1, k + ndel + nidx do
+			redis.call("SREM", KEYS[j], id)
+		end
+		redis.call("SREM", KEYS[1], id)
+		if current then
+			removed[#removed + 1] = id
+		end
+	end
+	k = k + ndel + 1 + nidx
+end
+return removed
+`)
+
+// removeIndexedTokens deletes the index SET at indexKey together with every
+// access it references and the given extra keys. The access data is read with
+// one MGET and removed with one Lua script per chunk of the index, sized by
+// WithScanCount, so that a large index neither takes a round trip per token
+// nor blocks the server in a single long script. Once the index is empty, it
+// is deleted along with the extra keys in a MULTI/EXEC guarded by WATCH on
+// the index; tokens indexed in the meantime are removed in another pass.
+func (s *Storage) removeIndexedTokens(indexKey string, extraKeys ...interface{}) (int, error) {
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	removed := 0
+	for attempt := 0; attempt < maxWatchAttempts; attempt++ {
+		if _, err := conn.Do("WATCH", indexKey); err != nil {
+			return removed, errors.Wrap(err, "failed to watch token index")
+		}
+
+		accessIDs, err := redis.Strings(conn.Do("SMEMBERS", indexKey))
+		if err != nil {
+			conn.Do("UNWATCH")
+			return removed, errors.Wrap(err, "failed to read token index")
+		}
+
+		if len(accessIDs) == 0 {
+			conn.Send("MULTI")
+			conn.Send("DEL", append([]interface{}{indexKey}, extraKeys...)...)
+			reply, err := conn.Do("EXEC")
+			if err != nil {
+				return removed, errors.Wrap(err, "failed to remove tokens")
+			}
+			if reply != nil {
+				return removed, nil
+			}
+			continue
+		}
+
+		if _, err := conn.Do("UNWATCH"); err != nil {
+			return removed, errors.Wrap(err, "failed to unwatch token index")
+		}
+		for len(accessIDs) > 0 {
+			chunk := accessIDs
+			if len(chunk) > s.scanHint() {
+				chunk = chunk[:s.scanHint()]
+			}
+			accessIDs = accessIDs[len(chunk):]
+
+			n, err := s.revokeIndexedChunk(conn, indexKey, chunk)
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+
+	return removed, errors.New("failed to remove tokens: token index kept changing")
+}
+
+// revokeIndexedChunk removes the access data stored under accessIDs, listed
+// in the token index at indexKey, with revokeIndexedScript, and returns how
+// many it removed. Access data that fails to decode is logged and removed
+// along with the keys derived from its access ID.
+func (s *Storage) revokeIndexedChunk(conn redis.Conn, indexKey string, accessIDs []string) (int, error) {
+	accessKeys := make([]interface{}, len(accessIDs))
+	for i, accessID := range accessIDs {
+		accessKeys[i] = s.makeKey("access", accessID)
+	}
+	accessGobs, err := redis.ByteSlices(conn.Do("MGET", accessKeys...))
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get access gobs")
+	}
+
+	keys := redis.Args{indexKey}
+	args := redis.Args{len(accessIDs)}
+	accesses := make(map[string]*osin.AccessData, len(accessIDs))
+	for i, accessID := range accessIDs {
+		deleted := []interface{}{accessKeys[i], s.makeKey("access_created_at", accessID), s.makeKey("access_meta", accessID)}
+		if s.indexReaper {
+			deleted = append(deleted, s.makeKey("access_indexes", accessID))
+		}
+		var indexes []interface{}
+		if accessGobs[i] != nil {
+			var access osin.AccessData
+			if err := s.decodeRecord(accessKeys[i].(string), accessGobs[i], &access); err != nil {
+				// Its token pointers and other indexes cannot be found without
+				// decoding it, so only the keys derived from its ID go, and
+				// GarbageCollect and PruneIndexes clean up the rest.
+				s.logger.Log(LevelWarn, "failed to decode access, removing it by ID", "key", accessKeys[i], "err", err)
+			} else {
+				accesses[accessID] = &access
+				deleted = s.accessDataKeys(accessID, &access)
+				indexes = s.tokenIndexKeys(&access)
+			}
+		}
+		keys = keys.Add(deleted...).Add(s.makeNamespaceKey("access_by_created")).Add(indexes...)
+		args = args.Add(accessID, accessGobs[i], len(deleted), len(indexes))
+	}
+
+	reply, err := redis.Strings(revokeIndexedScript.Do(conn, append(redis.Args{len(keys)}, append(keys, args...)...)...))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to remove tokens")
+	}
+	for _, accessID := range reply {
+		s.emitAccess(EventRevoked, accessID, accesses[accessID])
+	}
+	return len(reply), nil
+}
@@ -0,0 +1,125 @@
+package osinredis
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevokeAccessChangedConcurrently(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithIDGenerator(func() string { return "id-1" }))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	accessGob, err := redis.Bytes(conn.Do("GET", storage.makeKey("access", "id-1")))
+	assert.NoError(t, err)
+
+	// A stale read must not delete anything.
+	revoked, current, err := storage.revokeAccess(conn, "id-1", append([]byte{0}, accessGob...), accessData)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	assert.Equal(t, accessGob, current)
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+
+	// Without the script cached EVALSHA falls back to EVAL.
+	_, err = conn.Do("SCRIPT", "FLUSH")
+	assert.NoError(t, err)
+	revoked, _, err = storage.revokeAccess(conn, "id-1", accessGob, accessData)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	indexed, err := redis.Strings(conn.Do("SMEMBERS", storage.makeKey("client_tokens", client.Id)))
+	assert.NoError(t, err)
+	assert.Empty(t, indexed)
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:access*"))
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestRemoveAllForClientChunked(t *testing.T) {
+	flushAll()
+
+	var commands []string
+	storage := New(pool, "test123", WithScanCount(4))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	for i := 0; i < 10; i++ {
+		accessData := newAccessData(newAuthorizeData(client))
+		accessData.AccessToken = fmt.Sprintf("access%d", i)
+		accessData.RefreshToken = fmt.Sprintf("refresh%d", i)
+		assert.NoError(t, storage.SaveAccess(accessData))
+	}
+	// An index entry whose access data already expired.
+	conn := pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SADD", storage.makeKey("client_tokens", client.GetId()), "expired")
+	assert.NoError(t, err)
+
+	storage.connFunc = func() redis.Conn {
+		return recordingConn{pool.Get(), &commands}
+	}
+	removed, err := storage.PurgeClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 10, removed)
+
+	// Two round trips per chunk of four instead of one per token.
+	evals := 0
+	for _, command := range commands {
+		if command == "EVALSHA" {
+			evals++
+		}
+	}
+	assert.Equal(t, 3, evals)
+
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:*"))
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestRemoveAllForClientUndecodable(t *testing.T) {
+	flushAll()
+
+	var warnings int
+	storage := New(pool, "test123", WithLogger(LoggerFunc(func(level Level, msg string, keyvals ...interface{}) {
+		if level == LevelWarn {
+			warnings++
+		}
+	})))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	indexKey := storage.makeKey("client_tokens", client.GetId())
+	_, err := conn.Do("SADD", indexKey, "corrupt")
+	assert.NoError(t, err)
+	_, err = conn.Do("SET", storage.makeKey("access", "corrupt"), "garbage")
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.RemoveAllForClient(client.GetId()))
+	assert.Equal(t, 1, warnings)
+
+	exists, err := redis.Int(conn.Do("EXISTS", indexKey, storage.makeKey("access", "corrupt")))
+	assert.NoError(t, err)
+	assert.Zero(t, exists)
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
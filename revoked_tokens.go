@@ -0,0 +1,78 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// ErrRevoked is returned, possibly wrapped, by the methods loading access data
+// by token, such as LoadAccess and LoadRefresh, for a token removed by
+// RemoveAccess or RemoveRefresh within the TTL set with WithRevokedTokenTTL.
+// Like ErrCodeRevoked it also matches ErrNotFound with errors.Is.
+var ErrRevoked error = tokenRevokedError{}
+
+type tokenRevokedError struct{}
+
+func (tokenRevokedError) Error() string { return "token revoked" }
+
+func (tokenRevokedError) Is(target error) bool { return target == ErrNotFound }
+
+// WithRevokedTokenTTL makes RemoveAccess and RemoveRefresh leave a tombstone
+// for the access and refresh token of the removed access data for ttl,
+// rounded down to whole seconds, so that loading either token reports
+// ErrRevoked rather than ErrNotFound in the meantime. Tokens revoked by other
+// means, such as PurgeClient or RotateAccess, and tokens that expired are
+// still reported as ErrNotFound. It defaults to 0, which writes no tombstones.
+func WithRevokedTokenTTL(ttl time.Duration) Option {
+	if ttl < 0 {
+		panic("osinredis: negative revoked token TTL")
+	}
+	return func(s *Storage) {
+		s.revokedTokenTTL = ttl
+	}
+}
+
+// markTokensRevoked writes the tombstones of the tokens of access, see
+// WithRevokedTokenTTL.
+func (s *Storage) markTokensRevoked(conn redis.Conn, access *osin.AccessData) error {
+	seconds := int64(s.revokedTokenTTL / time.Second)
+	if seconds <= 0 {
+		return nil
+	}
+
+	sent := 0
+	if access.AccessToken != "" {
+		conn.Send("SET", s.tokenKey("access_token_revoked", access.AccessToken), "1", "EX", seconds)
+		sent++
+	}
+	if access.RefreshToken != "" {
+		conn.Send("SET", s.tokenKey("refresh_token_revoked", access.RefreshToken), "1", "EX", seconds)
+		sent++
+	}
+	if sent == 0 {
+		return nil
+	}
+	if _, err := flush(conn); err != nil {
+		return errors.Wrap(err, "failed to mark token revoked")
+	}
+	return nil
+}
+
+// tokenMiss returns the error for a token of the given namespace,
+// "access_token" or "refresh_token", that is not stored: ErrRevoked if it
+// has a tombstone and ErrNotFound otherwise.
+func (s *Storage) tokenMiss(conn redis.Conn, namespace, token string) error {
+	if s.revokedTokenTTL >= time.Second {
+		revoked, err := redis.Bool(conn.Do("EXISTS", s.tokenKey(namespace+"_revoked", token)))
+		if err != nil {
+			return errors.Wrap(err, "unable to check token revocation")
+		}
+		if revoked {
+			return ErrRevoked
+		}
+	}
+	return errors.Wrap(ErrNotFound, "token not stored")
+}
@@ -0,0 +1,46 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRevokedTokenTTL(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithRevokedTokenTTL(time.Minute))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrRevoked))
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.True(t, errors.Is(err, ErrRevoked))
+	_, kind, err := storage.LookupToken(accessData.RefreshToken)
+	assert.Equal(t, ErrRevoked, err)
+	assert.Equal(t, TokenKindUnknown, kind)
+
+	_, err = storage.LoadAccess("missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrRevoked))
+
+	// Without the option no tombstones are written.
+	flushAll()
+	storage = initTestStorage()
+	assert.NoError(t, storage.CreateClient(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, storage.RemoveRefresh(accessData.RefreshToken))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrRevoked))
+
+	assert.Panics(t, func() { WithRevokedTokenTTL(-time.Second) })
+}
@@ -0,0 +1,99 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// ErrConcurrentRotation is returned by RotateAccess when the old access token
+// was changed or removed while it was being rotated.
+var ErrConcurrentRotation = errors.New("access token changed during rotation")
+
+// RotateAccess replaces the stored access data of old with next in a single
+// MULTI/EXEC transaction: the old access data, both of its token pointers and
+// its index entries are removed, and next is saved as by SaveAccess. Use it
+// instead of SaveAccess when rotating tokens to avoid leaving the old pointers
+// behind. It returns ErrNotFound if old is not stored.
+func (s *Storage) RotateAccess(old, next *osin.AccessData) (err error) {
+	defer s.observe("RotateAccess", time.Now(), &err)
+	s, span := s.startSpan("RotateAccess", "MULTI", "access")
+	defer span.end(&err)
+
+	if err := checkAccessTokens(next); err != nil {
+		return err
+	}
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if err := s.validateClient(conn, next); err != nil {
+		return err
+	}
+
+	s.applyClientTTL(next)
+	payload, err := s.encode(next)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode access")
+	}
+
+	oldKey := s.tokenKey("access_token", old.AccessToken)
+	if s.directAccessKeys {
+		oldKey = s.makeKey("access", directAccessID(old.AccessToken))
+	}
+	if _, err := conn.Do("WATCH", oldKey); err != nil {
+		return errors.Wrap(err, "failed to watch old access token")
+	}
+
+	oldID, err := s.resolveAccessID(conn, "access_token", old.AccessToken)
+	if err == redis.ErrNil {
+		conn.Do("UNWATCH")
+		return errors.Wrap(ErrNotFound, "old token not stored")
+	}
+	if err != nil {
+		conn.Do("UNWATCH")
+		return errors.Wrap(err, "unable to get old access ID")
+	}
+
+	stored, err := s.readAccessGob(conn, oldID)
+	if err != nil {
+		conn.Do("UNWATCH")
+		return err
+	}
+	if stored == nil {
+		if s.directAccessKeys {
+			conn.Do("UNWATCH")
+			return errors.Wrap(ErrNotFound, "old access not stored")
+		}
+		stored = old
+	}
+
+	nextID, err := s.accessIDFor(conn, next)
+	if err != nil {
+		conn.Do("UNWATCH")
+		return err
+	}
+
+	conn.Send("MULTI")
+	conn.Send("DEL", append(s.accessDataKeys(oldID, stored), s.tokenKey("refresh_token", old.RefreshToken))...)
+	conn.Send("ZREM", s.makeNamespaceKey("access_by_created"), oldID)
+	s.sendUnindexAccess(conn, oldID, stored)
+	s.sendSaveAccess(conn, nextID, next, payload)
+
+	_, err = exec(conn)
+	if err == errTransactionAborted {
+		return ErrConcurrentRotation
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to rotate access")
+	}
+	s.emitAccess(EventRevoked, oldID, stored)
+	s.emitAccess(EventCreated, nextID, next)
+	return nil
+}
@@ -0,0 +1,150 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// ErrRefreshReused is returned by RotateRefresh for a refresh token that was
+// already rotated, which per RFC 6819 suggests the token was stolen. It is
+// reported for as long as the rotated token would have lived. Like ErrRevoked
+// it also matches ErrNotFound with errors.Is.
+var ErrRefreshReused error = refreshReusedError{}
+
+type refreshReusedError struct{}
+
+func (refreshReusedError) Error() string { return "refresh token reused" }
+
+func (refreshReusedError) Is(target error) bool { return target == ErrNotFound }
+
+// RotateRefresh redeems the refresh token oldRefresh for next: the access
+// data of oldRefresh, both of its token pointers and its index entries are
+// removed and next is saved as by SaveAccess, in a single MULTI/EXEC
+// transaction. It returns the access data oldRefresh belonged to, with its
+// clients loaded as by LoadRefresh.
+//
+// The rotated token is remembered until it would have expired, and redeeming
+// it again fails with ErrRefreshReused, as does losing a race against a
+// concurrent RotateRefresh of the same token. A token that was never stored,
+// or was removed otherwise, is reported as by LoadRefresh.
+func (s *Storage) RotateRefresh(oldRefresh string, next *osin.AccessData) (_ *osin.AccessData, err error) {
+	defer s.observe("RotateRefresh", time.Now(), &err)
+	s, span := s.startSpan("RotateRefresh", "MULTI", "refresh_token")
+	defer span.end(&err)
+
+	if err := checkAccessTokens(next); err != nil {
+		return nil, err
+	}
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	if err := s.validateClient(conn, next); err != nil {
+		return nil, err
+	}
+
+	s.applyClientTTL(next)
+	payload, err := s.encode(next)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode access")
+	}
+
+	for attempt := 0; attempt < maxWatchAttempts; attempt++ {
+		old, oldID, ttl, err := s.watchRefresh(conn, oldRefresh)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return nil, err
+		}
+
+		nextID, err := s.accessIDFor(conn, next)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return nil, err
+		}
+
+		conn.Send("MULTI")
+		conn.Send("DEL", s.accessDataKeys(oldID, old)...)
+		conn.Send("ZREM", s.makeNamespaceKey("access_by_created"), oldID)
+		s.sendUnindexAccess(conn, oldID, old)
+		s.sendSaveAccess(conn, nextID, next, payload)
+		rotatedKey := s.tokenKey("refresh_token_rotated", oldRefresh)
+		if ttl > 0 {
+			conn.Send("SET", rotatedKey, nextID, "PX", ttl)
+		} else {
+			conn.Send("SET", rotatedKey, nextID)
+		}
+
+		_, err = exec(conn)
+		if err == errTransactionAborted {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to rotate refresh token")
+		}
+		s.emitAccess(EventRevoked, oldID, old)
+		s.emitAccess(EventCreated, nextID, next)
+
+		if err := s.refreshAccessClients(conn, old); err != nil {
+			return nil, err
+		}
+		return old, nil
+	}
+
+	return nil, errors.New("failed to rotate refresh token: watched keys kept changing")
+}
+
+// watchRefresh watches the refresh token pointer of token and the access data
+// it refers to, and returns that access data, its access ID and the remaining
+// TTL of the pointer in milliseconds, or -1 if it has none.
+func (s *Storage) watchRefresh(conn redis.Conn, token string) (*osin.AccessData, string, int64, error) {
+	pointerKey := s.tokenKey("refresh_token", token)
+	if _, err := conn.Do("WATCH", pointerKey); err != nil {
+		return nil, "", 0, errors.Wrap(err, "failed to watch refresh token")
+	}
+
+	conn.Send("GET", pointerKey)
+	conn.Send("PTTL", pointerKey)
+	replies, err := flush(conn)
+	if err != nil {
+		return nil, "", 0, errors.Wrap(err, "unable to get access ID")
+	}
+	if replies[0] == nil {
+		return nil, "", 0, s.refreshMiss(conn, token)
+	}
+	accessID, _ := redis.String(replies[0], nil)
+	ttl, _ := redis.Int64(replies[1], nil)
+
+	if _, err := conn.Do("WATCH", s.makeKey("access", accessID)); err != nil {
+		return nil, "", 0, errors.Wrap(err, "failed to watch access")
+	}
+	access, err := s.readAccessGob(conn, accessID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	// A concurrent rotation may have removed the access data since the
+	// pointer was read; it marks the token rotated in the same transaction.
+	if access == nil {
+		return nil, "", 0, s.refreshMiss(conn, token)
+	}
+	return access, accessID, ttl, nil
+}
+
+// refreshMiss returns the error for a refresh token that is not stored:
+// ErrRefreshReused if it was rotated, and the error of tokenMiss otherwise.
+func (s *Storage) refreshMiss(conn redis.Conn, token string) error {
+	rotated, err := redis.Bool(conn.Do("EXISTS", s.tokenKey("refresh_token_rotated", token)))
+	if err != nil {
+		return errors.Wrap(err, "unable to check refresh token rotation")
+	}
+	if rotated {
+		return ErrRefreshReused
+	}
+	return s.tokenMiss(conn, "refresh_token", token)
+}
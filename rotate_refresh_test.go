@@ -0,0 +1,86 @@
+package osinredis
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotateRefresh(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	old := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(old))
+
+	next := newAccessData(newAuthorizeData(client))
+	next.AccessToken = "rotatedAccessToken"
+	next.RefreshToken = "rotatedRefreshToken"
+	grant, err := storage.RotateRefresh(old.RefreshToken, next)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAccessData(grant, old))
+
+	_, err = storage.LoadAccess(old.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = storage.LoadRefresh(old.RefreshToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	loadData, err := storage.LoadRefresh(next.RefreshToken)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAccessData(loadData, next))
+
+	again := newAccessData(newAuthorizeData(client))
+	again.AccessToken = "reusedAccessToken"
+	again.RefreshToken = "reusedRefreshToken"
+	_, err = storage.RotateRefresh(old.RefreshToken, again)
+	assert.True(t, errors.Is(err, ErrRefreshReused))
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = storage.LoadAccess(again.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = storage.RotateRefresh("missing", again)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrRefreshReused))
+}
+
+func TestRotateRefreshConcurrent(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	old := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(old))
+
+	const workers = 8
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			next := newAccessData(newAuthorizeData(client))
+			next.AccessToken = "rotatedAccessToken" + string(rune('a'+i))
+			next.RefreshToken = "rotatedRefreshToken" + string(rune('a'+i))
+			_, errs[i] = storage.RotateRefresh(old.RefreshToken, next)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.True(t, errors.Is(err, ErrRefreshReused), "%v", err)
+	}
+	assert.Equal(t, 1, succeeded)
+}
@@ -0,0 +1,59 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotateAccess(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	old := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(old))
+
+	next := newAccessData(newAuthorizeData(client))
+	next.AccessToken = "rotatedAccessToken"
+	next.RefreshToken = "rotatedRefreshToken"
+	assert.NoError(t, storage.RotateAccess(old, next))
+
+	_, err := storage.LoadAccess(old.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = storage.LoadRefresh(old.RefreshToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	loadData, err := storage.LoadAccess(next.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAccessData(loadData, next))
+
+	conn := pool.Get()
+	defer conn.Close()
+	members, err := redis.Strings(conn.Do("SMEMBERS", storage.makeKey("client_tokens", client.GetId())))
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+	accessKeys, err := redis.Strings(conn.Do("KEYS", storage.makeKey("access", "*")))
+	assert.NoError(t, err)
+	assert.Len(t, accessKeys, 1)
+}
+
+func TestRotateAccessNonExistent(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	old := newAccessData(newAuthorizeData(client))
+	next := newAccessData(newAuthorizeData(client))
+	next.AccessToken = "rotatedAccessToken"
+
+	assert.True(t, errors.Is(storage.RotateAccess(old, next), ErrNotFound))
+	_, err := storage.LoadAccess(next.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
@@ -0,0 +1,263 @@
+package osinredis
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// defaultScanCount is the COUNT hint passed to SCAN unless WithScanCount is
+// set.
+const defaultScanCount = 100
+
+// WithScanCount sets the COUNT hint of the SCAN loops of ListClients without
+// an explicit count, IterateClients, Stats, Flush, GarbageCollect,
+// RebuildCreatedIndex and Backfill. It defaults to 100. The hint is not a page
+// size: Redis may return more or fewer keys per iteration, including none.
+// Larger values take fewer round trips on big keyspaces at the cost of longer
+// individual SCAN calls.
+func WithScanCount(n int) Option {
+	if n <= 0 {
+		panic("osinredis: scan count must be positive")
+	}
+	return func(s *Storage) {
+		s.scanCount = n
+	}
+}
+
+// scanHint returns the COUNT hint for SCAN.
+func (s *Storage) scanHint() int {
+	if s.scanCount == 0 {
+		return defaultScanCount
+	}
+	return s.scanCount
+}
+
+// scan runs a single SCAN iteration over keys matching pattern.
+func scan(conn redis.Conn, cursor uint64, pattern string, count int) (uint64, []string, error) {
+	values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", count))
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to scan keys")
+	}
+
+	var keys []string
+	if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+		return 0, nil, errors.Wrap(err, "failed to parse scan reply")
+	}
+	return cursor, keys, nil
+}
+
+// ListClients returns a page of stored clients. Pass a cursor of 0 to start
+// and the returned cursor to continue; a returned cursor of 0 means the
+// iteration is complete. It uses SCAN, so count is a hint and pages may hold
+// more or fewer clients, including none while the cursor is still non-zero. A
+// count of 0 or less uses the hint set with WithScanCount.
+func (s *Storage) ListClients(cursor uint64, count int) (_ []osin.Client, _ uint64, err error) {
+	defer s.observe("ListClients", time.Now(), &err)
+	s, span := s.startSpan("ListClients", "SCAN", "client")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	defer conn.Close()
+
+	if count <= 0 {
+		count = s.scanHint()
+	}
+
+	clientPrefix := s.makeKey("client", "")
+	cursor, keys, err := scan(conn, cursor, clientPrefix+"*", count)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(keys) == 0 {
+		return nil, cursor, nil
+	}
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+
+	clientGobs, err := redis.ByteSlices(conn.Do("MGET", args...))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to get client gobs")
+	}
+
+	clients := make([]osin.Client, 0, len(clientGobs))
+	for i, clientGob := range clientGobs {
+		if clientGob == nil {
+			// Deleted between SCAN and MGET.
+			continue
+		}
+
+		var client osin.DefaultClient
+		if err := s.decodeRecord(keys[i], clientGob, &client); err != nil {
+			s.logger.Log(LevelWarn, "failed to decode client", "key", keys[i], "err", err)
+			return nil, 0, errors.Wrapf(err, "failed to decode client %s", strings.TrimPrefix(keys[i], clientPrefix))
+		}
+		clients = append(clients, s.hashedClient(&client))
+	}
+
+	return clients, cursor, nil
+}
+
+// ForEachClient calls fn with every stored client, fetched page by page with
+// ListClients using the hint set with WithScanCount, for example to fill a
+// cache at startup in one pass without holding every client in memory. The
+// clients also fill the cache set with WithClientCache. It stops at the first
+// error of fn or of fetching a page and returns it. Like ListClients it may
+// pass a client twice if the keyspace is resized along the way.
+func (s *Storage) ForEachClient(fn func(osin.Client) error) (err error) {
+	defer s.observe("ForEachClient", time.Now(), &err)
+	s, span := s.startSpan("ForEachClient", "SCAN", "client")
+	defer span.end(&err)
+
+	var cursor uint64
+	for {
+		clients, next, err := s.ListClients(cursor, 0)
+		if err != nil {
+			return err
+		}
+		for _, client := range clients {
+			s.clientCache.put(client, s.now())
+			if err := fn(client); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// ClientIterator iterates over the stored clients page by page, hiding the
+// SCAN cursor of ListClients. Like ListClients it may return a client twice
+// if the keyspace is resized during the iteration.
+type ClientIterator struct {
+	s       *Storage
+	count   int
+	cursor  uint64
+	started bool
+	page    []osin.Client
+	err     error
+}
+
+// IterateClients returns a ClientIterator over the stored clients, fetching
+// about count clients per ListClients page. A count of 0 or less uses the
+// hint set with WithScanCount.
+//
+//	it := storage.IterateClients(100)
+//	for {
+//		client, ok, err := it.Next(ctx)
+//		if err != nil {
+//			return err
+//		}
+//		if !ok {
+//			break
+//		}
+//		// use client
+//	}
+func (s *Storage) IterateClients(count int) *ClientIterator {
+	if count <= 0 {
+		count = s.scanHint()
+	}
+	return &ClientIterator{s: s, count: count}
+}
+
+// Next returns the next client and true, or false once every client has been
+// returned. Fetching a page runs in ctx. After an error every further call
+// returns the same error.
+func (it *ClientIterator) Next(ctx context.Context) (osin.Client, bool, error) {
+	for len(it.page) == 0 {
+		if it.err != nil {
+			return nil, false, it.err
+		}
+		if it.started && it.cursor == 0 {
+			return nil, false, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+
+		page, cursor, err := it.s.WithContext(ctx).ListClients(it.cursor, it.count)
+		if err != nil {
+			it.err = err
+			return nil, false, err
+		}
+		it.started = true
+		it.cursor = cursor
+		it.page = page
+	}
+
+	client := it.page[0]
+	it.page = it.page[1:]
+	return client, true, nil
+}
+
+// Stats holds approximate record counts per namespace.
+type Stats struct {
+	Clients        int
+	AuthorizeCodes int
+	Accesses       int
+	AccessTokens   int
+	RefreshTokens  int
+}
+
+// Stats counts the stored records of every namespace using SCAN, without
+// blocking the server the way KEYS would. Since keys may be added or expire
+// during the scan, the counts are approximate.
+func (s *Storage) Stats() (_ *Stats, err error) {
+	defer s.observe("Stats", time.Now(), &err)
+	s, span := s.startSpan("Stats", "SCAN", "")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	var stats Stats
+	for namespace, count := range map[string]*int{
+		"client":        &stats.Clients,
+		"auth":          &stats.AuthorizeCodes,
+		"access":        &stats.Accesses,
+		"access_token":  &stats.AccessTokens,
+		"refresh_token": &stats.RefreshTokens,
+	} {
+		n, err := countKeys(conn, s.makeKey(namespace, "")+"*", s.scanHint())
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to count %s keys", namespace)
+		}
+		*count = n
+	}
+
+	return &stats, nil
+}
+
+func countKeys(conn redis.Conn, pattern string, count int) (int, error) {
+	var (
+		cursor uint64
+		total  int
+	)
+	for {
+		next, keys, err := scan(conn, cursor, pattern, count)
+		if err != nil {
+			return 0, err
+		}
+		total += len(keys)
+		if cursor = next; cursor == 0 {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package osinredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListClients(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	for i := 0; i < 25; i++ {
+		client := newClient()
+		client.Id = fmt.Sprintf("client%d", i)
+		assert.NoError(t, storage.CreateClient(client))
+	}
+
+	// Tokens live next to the clients and must not be listed.
+	assert.NoError(t, storage.SaveAccess(newAccessData(newAuthorizeData(newClient()))))
+
+	var (
+		all    []osin.Client
+		cursor uint64
+	)
+	for {
+		clients, next, err := storage.ListClients(cursor, 10)
+		assert.NoError(t, err)
+		all = append(all, clients...)
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	ids := make(map[string]bool)
+	for _, client := range all {
+		ids[client.GetId()] = true
+	}
+	assert.Len(t, ids, 25)
+	assert.True(t, ids["client7"])
+}
+
+func TestIterateClients(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	want := map[string]bool{}
+	for i := 0; i < 25; i++ {
+		id := fmt.Sprintf("client%d", i)
+		want[id] = true
+		assert.NoError(t, storage.CreateClient(&osin.DefaultClient{Id: id}))
+	}
+
+	got := map[string]bool{}
+	it := storage.IterateClients(10)
+	for {
+		client, ok, err := it.Next(context.Background())
+		assert.NoError(t, err)
+		if !ok {
+			break
+		}
+		got[client.GetId()] = true
+	}
+	assert.Equal(t, want, got)
+
+	// An exhausted iterator stays exhausted.
+	_, ok, err := it.Next(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok, err = storage.IterateClients(10).Next(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, ok)
+}
+
+func TestForEachClient(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithScanCount(10), WithClientCache(100, time.Minute))
+
+	want := map[string]bool{}
+	for i := 0; i < 25; i++ {
+		id := fmt.Sprintf("client%d", i)
+		want[id] = true
+		assert.NoError(t, storage.CreateClient(&osin.DefaultClient{Id: id}))
+	}
+
+	got := map[string]bool{}
+	assert.NoError(t, storage.ForEachClient(func(client osin.Client) error {
+		got[client.GetId()] = true
+		return nil
+	}))
+	assert.Equal(t, want, got)
+
+	// The clients were cached along the way.
+	conn := pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("DEL", "test123:client:client7")
+	assert.NoError(t, err)
+	client, err := storage.GetClient("client7")
+	assert.NoError(t, err)
+	assert.Equal(t, "client7", client.GetId())
+
+	stop := errors.New("stop")
+	var calls int
+	err = storage.ForEachClient(func(osin.Client) error {
+		calls++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestStats(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+	assert.NoError(t, storage.SaveAccess(newAccessData(authorizeData)))
+
+	stats, err := storage.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, &Stats{
+		Clients:        1,
+		AuthorizeCodes: 1,
+		Accesses:       1,
+		AccessTokens:   1,
+		RefreshTokens:  1,
+	}, stats)
+}
+
+// scanCountConn records the COUNT hints of the SCAN commands sent over it.
+type scanCountConn struct {
+	redis.Conn
+	counts *[]interface{}
+}
+
+func (c scanCountConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "SCAN" {
+		*c.counts = append(*c.counts, args[len(args)-1])
+	}
+	return c.Conn.Do(commandName, args...)
+}
+
+func TestWithScanCount(t *testing.T) {
+	flushAll()
+
+	var counts []interface{}
+	storage := New(pool, "test123", WithScanCount(7))
+	storage.connFunc = func() redis.Conn {
+		return scanCountConn{pool.Get(), &counts}
+	}
+
+	assert.NoError(t, storage.CreateClient(newClient()))
+
+	_, err := storage.Stats()
+	assert.NoError(t, err)
+	_, _, err = storage.ListClients(0, 0)
+	assert.NoError(t, err)
+	_, err = storage.GarbageCollect()
+	assert.NoError(t, err)
+	assert.NoError(t, storage.Flush())
+
+	assert.NotEmpty(t, counts)
+	for _, count := range counts {
+		assert.Equal(t, 7, count)
+	}
+
+	assert.Panics(t, func() { WithScanCount(0) })
+}
@@ -0,0 +1,279 @@
+package osinredis
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// schemaVersion1 marks a value whose remaining bytes are the output of the
+// configured Serializer. Every encoded value is prefixed with a one-byte schema
+// version so the format can evolve without old and new readers misinterpreting
+// each other's data. Values without one, written by versions of this package
+// that stored bare gob streams, are still read; see decodeLegacy.
+const schemaVersion1 byte = 1
+
+// schemaVersionEncrypted marks a value whose remaining bytes are a GCM nonce
+// followed by the encrypted output of the configured Serializer.
+const schemaVersionEncrypted byte = 2
+
+// schemaVersionTagged is schemaVersion1 with a record kind byte between the
+// schema version and the output of the Serializer, so that a record is never
+// decoded as one of another kind.
+const schemaVersionTagged byte = 3
+
+// schemaVersionTaggedEncrypted is schemaVersionEncrypted with a record kind
+// byte before the nonce, authenticated along with the ciphertext.
+const schemaVersionTaggedEncrypted byte = 4
+
+// Record kinds, as stored by the tagged schema versions.
+const (
+	recordClient    byte = 'c'
+	recordAuthorize byte = 'a'
+	recordAccess    byte = 't'
+)
+
+// ErrWrongRecordKind is returned, wrapped in a DecodeError, when a stored
+// value holds a different kind of record than the one being loaded, for
+// example access data found under a client key.
+var ErrWrongRecordKind = errors.New("stored record is of another kind")
+
+// recordKind returns the kind of the record v, or 0 if v is not one of the
+// osin types.
+func recordKind(v interface{}) byte {
+	switch v.(type) {
+	case osin.Client:
+		return recordClient
+	case *osin.AuthorizeData:
+		return recordAuthorize
+	case *osin.AccessData:
+		return recordAccess
+	}
+	return 0
+}
+
+// Serializer converts clients, authorize data and access data to and from the
+// bytes stored in Redis.
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var registerGobTypes sync.Once
+
+// gobBuffers holds the buffers GobSerializer.Marshal encodes into, to avoid
+// growing a fresh buffer for every write.
+var gobBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// gobSizeHint is a moving average of the sizes GobSerializer.Marshal
+// produced. Buffers fresh from gobBuffers are grown to it up front, so that
+// large records, such as clients with big metadata, do not make every new
+// buffer reallocate several times while encoding.
+var gobSizeHint atomic.Int64
+
+// learnGobSize adds an encoded size of n bytes to gobSizeHint, weighing it
+// by 1/8. Concurrent updates may be lost, which only delays the average.
+func learnGobSize(n int) {
+	hint := gobSizeHint.Load()
+	gobSizeHint.Store(hint + (int64(n)-hint)/8)
+}
+
+// GobSerializer is the default Serializer, based on encoding/gob. The osin
+// types it needs are registered with gob the first time it is used.
+type GobSerializer struct{}
+
+// Marshal encodes v as a gob stream.
+func (GobSerializer) Marshal(v interface{}) ([]byte, error) {
+	registerGobTypes.Do(gobRegisterOsinTypes)
+
+	buf := gobBuffers.Get().(*bytes.Buffer)
+	defer gobBuffers.Put(buf)
+	buf.Reset()
+	if hint := int(gobSizeHint.Load()); buf.Cap() < hint {
+		buf.Grow(hint)
+	}
+
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	learnGobSize(buf.Len())
+	// The buffer is reused, so hand out a copy.
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// Unmarshal decodes a gob stream into v.
+func (GobSerializer) Unmarshal(data []byte, v interface{}) error {
+	registerGobTypes.Do(gobRegisterOsinTypes)
+
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// RegisterType registers the concrete type of value with gob, so that
+// GobSerializer can encode and decode it when stored in an interface field,
+// such as a custom UserData type or osin.Client implementation. It must be
+// called before any storage operation that reads or writes such a value,
+// typically from an init function. Like gob.Register, it panics if the type
+// name is already registered for a different type.
+func RegisterType(value interface{}) {
+	gob.Register(value)
+}
+
+// WithGobTypes registers the concrete types of the given values with gob, as by
+// RegisterType, when the Storage is constructed.
+func WithGobTypes(values ...interface{}) Option {
+	return func(*Storage) {
+		for _, value := range values {
+			RegisterType(value)
+		}
+	}
+}
+
+func gobRegisterOsinTypes() {
+	gob.Register(map[string]interface{}{})
+	gob.Register(&osin.DefaultClient{})
+	gob.Register(&HashedSecretClient{})
+	gob.Register(osin.AuthorizeData{})
+	gob.Register(osin.AccessData{})
+	gob.RegisterName(encodedUserDataType, encodedUserData(nil))
+}
+
+// serializerFor returns the Serializer for records of the type of v: the
+// client Serializer for clients and the token Serializer otherwise.
+func (s *Storage) serializerFor(v interface{}) Serializer {
+	if _, ok := v.(osin.Client); ok {
+		return s.clientSerializer
+	}
+	return s.serializer
+}
+
+func (s *Storage) encode(v interface{}) ([]byte, error) {
+	if s.userDataCodec != nil {
+		var err error
+		if v, err = s.userDataCodec.detachUserData(v); err != nil {
+			return nil, err
+		}
+	}
+	payload, err := s.serializerFor(v).Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode")
+	}
+	kind := recordKind(v)
+	switch {
+	case s.encryptKey != nil:
+		payload, err = s.encrypt(payload, kind)
+		if err != nil {
+			return nil, err
+		}
+	case kind != 0:
+		payload = prependHeader(payload, schemaVersionTagged, kind)
+	default:
+		payload = prependHeader(payload, schemaVersion1)
+	}
+	if s.maxPayloadBytes > 0 && len(payload) > s.maxPayloadBytes {
+		return nil, errors.Wrapf(ErrPayloadTooLarge, "%d bytes exceed the limit of %d", len(payload), s.maxPayloadBytes)
+	}
+	return payload, nil
+}
+
+// prependHeader returns header followed by payload, allocated once at its
+// final size.
+func prependHeader(payload []byte, header ...byte) []byte {
+	return append(append(make([]byte, 0, len(header)+len(payload)), header...), payload...)
+}
+
+func (s *Storage) decode(data []byte, v interface{}) error {
+	payload, err := s.decodePayload(data, recordKind(v))
+	switch {
+	case errors.Is(err, ErrUnsupportedSchemaVersion) && decodeLegacy(data, v):
+		return nil
+	case err != nil:
+		return err
+	}
+	if err := s.unmarshal(payload, v); err != nil {
+		return errors.Wrap(err, "unable to decode")
+	}
+	s.attachSecretHasher(v)
+	if s.userDataCodec != nil {
+		return s.userDataCodec.attachUserData(v)
+	}
+	return nil
+}
+
+// decodeLegacy decodes data into the pointer v as a value written before
+// values carried a schema version, which were bare gob streams of the record,
+// and reports whether it succeeded. Such streams start with the length of a
+// type definition, which is never a known schema version, so decodePayload
+// rejects them as unsupported first. If it fails, v is left zero.
+func decodeLegacy(data []byte, v interface{}) bool {
+	if (GobSerializer{}).Unmarshal(data, v) == nil {
+		return true
+	}
+	target := reflect.ValueOf(v).Elem()
+	target.Set(reflect.Zero(target.Type()))
+	return false
+}
+
+// decodePayload strips the schema version of data, decrypting it if needed,
+// and returns the output of the Serializer that wrote it. If data is tagged
+// with a record kind other than kind, it fails with ErrWrongRecordKind. A kind
+// of 0 accepts any record, and untagged values are accepted for any kind.
+func (s *Storage) decodePayload(data []byte, kind byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("unable to decode: empty payload")
+	}
+
+	switch data[0] {
+	case schemaVersion1:
+		return data[1:], nil
+	case schemaVersionEncrypted:
+		payload, err := s.decrypt(data, 1)
+		return payload, errors.Wrap(err, "unable to decode")
+	case schemaVersionTagged, schemaVersionTaggedEncrypted:
+		if len(data) < 2 {
+			return nil, errors.New("unable to decode: truncated payload")
+		}
+		if kind != 0 && data[1] != kind {
+			return nil, errors.Wrapf(ErrWrongRecordKind, "unable to decode: record kind %q, want %q", data[1], kind)
+		}
+		if data[0] == schemaVersionTagged {
+			return data[2:], nil
+		}
+		payload, err := s.decrypt(data, 2)
+		return payload, errors.Wrap(err, "unable to decode")
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedSchemaVersion, "unable to decode version %d", data[0])
+	}
+}
+
+// unmarshal decodes payload into the pointer v with its primary Serializer,
+// trying the fallback Serializers and then the Serializer being migrated from
+// in turn if that fails. It returns the error of the primary Serializer if
+// none succeeds.
+func (s *Storage) unmarshal(payload []byte, v interface{}) error {
+	err := s.serializerFor(v).Unmarshal(payload, v)
+	if err == nil || (len(s.fallbackSerializers) == 0 && s.migrateFrom == nil) {
+		return err
+	}
+
+	fallbacks := s.fallbackSerializers
+	if s.migrateFrom != nil {
+		fallbacks = append(fallbacks[:len(fallbacks):len(fallbacks)], s.migrateFrom)
+	}
+
+	target := reflect.ValueOf(v).Elem()
+	for _, serializer := range fallbacks {
+		// Discard whatever the failed attempt decoded.
+		target.Set(reflect.Zero(target.Type()))
+		if serializer.Unmarshal(payload, v) == nil {
+			return nil
+		}
+	}
+	return err
+}
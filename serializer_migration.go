@@ -0,0 +1,169 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// WithMigratingSerializer switches the Storage from the Serializer from to the
+// Serializer to without a big-bang rewrite. Every write uses to, behind the
+// usual schema version and record kind bytes, while reads accept records
+// written by either: decoding tries to first, then the fallbacks set with
+// WithFallbackSerializers, then from. Backfill rewrites the records still in
+// the old format, after which the Storage can be configured with
+// WithSerializer(to) alone.
+func WithMigratingSerializer(from, to Serializer) Option {
+	return func(s *Storage) {
+		s.serializer = to
+		s.clientSerializer = to
+		s.migrateFrom = from
+	}
+}
+
+// Backfill rewrites every client, authorize data and access data record that
+// is not stored the way the Storage would write it now, and returns how many
+// it rewrote: records written by the Serializer being migrated from or a
+// fallback Serializer, records stored without a schema version or record kind
+// by older versions of this package, unencrypted records once WithEncryption
+// is set and records encrypted under an old key. Each is read like any load
+// would read it and written back with its expiry. Records already in the
+// current format are left alone, so Backfill can be stopped and run again
+// until it returns zero. It iterates with SCAN, so it can run in the
+// background against a live server; a record written while it is being
+// rewritten is left alone, since it is written in the current format anyway.
+// Records that cannot be decoded are logged and skipped. It stops with the
+// error of the context bound with WithContext once that is done.
+func (s *Storage) Backfill() (rewritten int, err error) {
+	defer s.observe("Backfill", time.Now(), &err)
+	s, span := s.startSpan("Backfill", "SCAN", "")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	records := []struct {
+		namespace string
+		newRecord func() interface{}
+	}{
+		{"client", func() interface{} { return new(osin.DefaultClient) }},
+		{"auth", func() interface{} { return new(osin.AuthorizeData) }},
+		{"access", func() interface{} { return new(osin.AccessData) }},
+	}
+	for _, record := range records {
+		var (
+			cursor uint64
+			keys   []string
+		)
+		for {
+			if err := s.context().Err(); err != nil {
+				return rewritten, err
+			}
+
+			cursor, keys, err = scan(conn, cursor, s.makeKey(record.namespace, "")+"*", s.scanHint())
+			if err != nil {
+				return rewritten, err
+			}
+
+			for _, key := range keys {
+				ok, err := s.backfillRecord(conn, key, record.newRecord)
+				if err != nil {
+					return rewritten, err
+				}
+				if ok {
+					rewritten++
+				}
+			}
+
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	return rewritten, nil
+}
+
+// backfillRecord rewrites the record at key in the current format if it is
+// stored in another one, and reports whether it did.
+func (s *Storage) backfillRecord(conn redis.Conn, key string, newRecord func() interface{}) (bool, error) {
+	if _, err := conn.Do("WATCH", key); err != nil {
+		return false, errors.Wrap(err, "failed to watch record")
+	}
+	defer conn.Do("UNWATCH")
+
+	data, err := redis.Bytes(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "unable to read record")
+	}
+
+	logKey := key
+	if recordKind(newRecord()) == recordAuthorize {
+		logKey = s.redactTokenKey("auth", key)
+	}
+
+	if s.encodedCurrently(data, newRecord()) {
+		return false, nil
+	}
+
+	v := newRecord()
+	if err := s.decode(data, v); err != nil {
+		s.logger.Log(LevelWarn, "skipping undecodable record", "key", logKey, "err", err)
+		return false, nil
+	}
+
+	encoded, err := s.encode(v)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to encode %s", logKey)
+	}
+
+	ttl, err := redis.Int64(conn.Do("PTTL", key))
+	if err != nil {
+		return false, errors.Wrap(err, "unable to read record TTL")
+	}
+
+	conn.Send("MULTI")
+	conn.Send("SET", key, encoded)
+	if ttl > 0 {
+		conn.Send("PEXPIRE", key, ttl)
+	}
+	if _, err := exec(conn); err != nil {
+		if err == errTransactionAborted {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "failed to rewrite %s", logKey)
+	}
+	return true, nil
+}
+
+// encodedCurrently reports whether data holds the record v the way encode
+// would store it now: tagged with its kind, encrypted under the current key
+// if encryption is enabled, and decodable by the current Serializer, which
+// decodes it into v.
+func (s *Storage) encodedCurrently(data []byte, v interface{}) bool {
+	if len(data) < 2 || data[1] != recordKind(v) {
+		return false
+	}
+
+	var payload []byte
+	switch {
+	case s.encryptKey != nil && data[0] == schemaVersionTaggedEncrypted:
+		var ok bool
+		if payload, ok = openSealed(s.encryptKey, data, 2); !ok {
+			return false
+		}
+	case s.encryptKey == nil && data[0] == schemaVersionTagged:
+		payload = data[2:]
+	default:
+		return false
+	}
+	return s.serializerFor(v).Unmarshal(payload, v) == nil
+}
@@ -0,0 +1,117 @@
+package osinredis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithMigratingSerializer(t *testing.T) {
+	flushAll()
+
+	old := New(pool, "test123")
+	client := newClient()
+	assert.NoError(t, old.CreateClient(client))
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, old.SaveAuthorize(authorizeData))
+	accessData := newAccessData(authorizeData)
+	assert.NoError(t, old.SaveAccess(accessData))
+
+	storage := New(pool, "test123", WithMigratingSerializer(GobSerializer{}, JSONSerializer{}))
+
+	// Records in the old format stay readable.
+	_, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	_, err = storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+
+	// New records are written in the new format.
+	other := newClient()
+	other.Id = "otherClient"
+	assert.NoError(t, storage.CreateClient(other))
+
+	conn := pool.Get()
+	defer conn.Close()
+	raw, err := redis.Bytes(conn.Do("GET", storage.makeKey("client", other.Id)))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{schemaVersionTagged, recordClient, '{'}, raw[:3])
+
+	rewritten, err := storage.Backfill()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, rewritten)
+
+	rewritten, err = storage.Backfill()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rewritten)
+
+	ttl, err := redis.Int(conn.Do("TTL", storage.makeKey("auth", authorizeData.Code)))
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+
+	// After the backfill the old Serializer is no longer needed.
+	migrated := New(pool, "test123", WithSerializer(JSONSerializer{}))
+	clientFound, err := migrated.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+	authFound, err := migrated.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAuthorizeData(authFound, authorizeData))
+	accessFound, err := migrated.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, accessFound.AccessToken)
+}
+
+func TestBackfillEncryption(t *testing.T) {
+	flushAll()
+
+	plain := initTestStorage()
+	client := newClient()
+	assert.NoError(t, plain.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, plain.SaveAccess(accessData))
+
+	rewritten, err := plain.Backfill()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rewritten)
+
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	encrypted := New(pool, "test123", WithEncryption(oldKey))
+	rewritten, err = encrypted.Backfill()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rewritten)
+
+	conn := pool.Get()
+	defer conn.Close()
+	raw, err := redis.Bytes(conn.Do("GET", "test123:client:clientID"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{schemaVersionTaggedEncrypted, recordClient}, raw[:2])
+
+	// Rotating the key rewrites the records again, once.
+	rotated := New(pool, "test123", WithEncryption(newKey, oldKey))
+	rewritten, err = rotated.Backfill()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rewritten)
+	rewritten, err = rotated.Backfill()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rewritten)
+
+	loaded, err := New(pool, "test123", WithEncryption(newKey)).LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loaded.AccessToken)
+	assert.InDelta(t, accessData.ExpiresIn, loaded.ExpiresIn, 1)
+	ttl, err := redis.Int(conn.Do("TTL", "test123:client:clientID"))
+	assert.NoError(t, err)
+	assert.Equal(t, -1, ttl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = rotated.WithContext(ctx).Backfill()
+	assert.True(t, errors.Is(err, context.Canceled))
+}
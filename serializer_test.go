@@ -0,0 +1,333 @@
+package osinredis
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodeSchemaVersion(t *testing.T) {
+	storage := initTestStorage()
+
+	payload, err := storage.encode(newClient())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{schemaVersionTagged, recordClient}, payload[:2])
+
+	var client osin.DefaultClient
+	assert.NoError(t, storage.decode(payload, &client))
+	assert.Equal(t, newClient(), &client)
+}
+
+func TestDecodeUnsupportedSchemaVersion(t *testing.T) {
+	storage := initTestStorage()
+
+	payload, err := storage.encode(newClient())
+	assert.NoError(t, err)
+	payload[0] = 0xff
+
+	var client osin.DefaultClient
+	err = storage.decode(payload, &client)
+	assert.True(t, errors.Is(err, ErrUnsupportedSchemaVersion))
+}
+
+// legacyGob encodes v the way values were stored before they carried a
+// schema version.
+func legacyGob(t *testing.T, v interface{}) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, gob.NewEncoder(&buf).Encode(v))
+	return buf.Bytes()
+}
+
+func TestDecodeLegacyGob(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+	accessData := newAccessData(authorizeData)
+	assert.NoError(t, storage.SaveAccess(accessData))
+	keys, err := storage.DescribeToken(accessData.AccessToken)
+	assert.NoError(t, err)
+
+	conn := pool.Get()
+	defer conn.Close()
+	legacy := map[string][]byte{
+		"test123:client:clientID": legacyGob(t, client),
+		"test123:auth:8888":       legacyGob(t, authorizeData),
+		keys["access"]:            legacyGob(t, accessData),
+	}
+	for key, value := range legacy {
+		assert.NotContains(t, []byte{schemaVersion1, schemaVersionEncrypted, schemaVersionTagged, schemaVersionTaggedEncrypted}, value[0])
+		_, err := conn.Do("SET", key, value)
+		assert.NoError(t, err)
+	}
+
+	loadedClient, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, loadedClient)
+	loadedAuthorize, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, authorizeData.RedirectUri, loadedAuthorize.RedirectUri)
+	loadedAccess, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loadedAccess.AccessToken)
+	assert.InDelta(t, accessData.ExpiresIn, loadedAccess.ExpiresIn, 1)
+
+	// Backfill gives them a schema version, after which they still load.
+	rewritten, err := storage.Backfill()
+	assert.NoError(t, err)
+	assert.Equal(t, len(legacy), rewritten)
+	for key := range legacy {
+		value, err := redis.Bytes(conn.Do("GET", key))
+		assert.NoError(t, err)
+		assert.Equal(t, schemaVersionTagged, value[0])
+	}
+	loadedAccess, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loadedAccess.AccessToken)
+	assert.InDelta(t, accessData.ExpiresIn, loadedAccess.ExpiresIn, 1)
+
+	// A value that is neither is still rejected.
+	_, err = conn.Do("SET", "test123:client:garbage", []byte{0x7f, 0x01, 0x02})
+	assert.NoError(t, err)
+	_, err = storage.GetClient("garbage")
+	assert.True(t, errors.Is(err, ErrUnsupportedSchemaVersion))
+}
+
+type session struct {
+	Username string
+}
+
+func TestJSONSerializer(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithSerializer(JSONSerializer{
+		NewUserData: func() interface{} { return &session{} },
+	}))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+
+	authorizeData := newAuthorizeData(client)
+	authorizeData.UserData = &session{Username: "jdoe"}
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	accessData := newAccessData(authorizeData)
+	accessData.UserData = &session{Username: "jdoe"}
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAccessData(loadData, accessData))
+	assert.Equal(t, &session{Username: "jdoe"}, loadData.UserData)
+	assert.Equal(t, &session{Username: "jdoe"}, loadData.AuthorizeData.UserData)
+	assert.Equal(t, client, loadData.Client)
+
+	conn := pool.Get()
+	defer conn.Close()
+	raw, err := redis.Bytes(conn.Do("GET", storage.makeKey("auth", authorizeData.Code)))
+	assert.NoError(t, err)
+	assert.True(t, json.Valid(raw[2:]))
+}
+
+type jsonClientSerializer struct{}
+
+func (jsonClientSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonClientSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func TestWithSerializer(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithSerializer(jsonClientSerializer{}))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	conn := pool.Get()
+	defer conn.Close()
+	raw, err := conn.Do("GET", storage.makeKey("client", client.GetId()))
+	assert.NoError(t, err)
+	assert.Equal(t, byte('{'), raw.([]byte)[2])
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+}
+
+func TestWithFallbackSerializers(t *testing.T) {
+	flushAll()
+
+	client := newClient()
+	assert.NoError(t, New(pool, "test123").CreateClient(client))
+
+	storage := New(pool, "test123",
+		WithSerializer(JSONSerializer{}),
+		WithFallbackSerializers(GobSerializer{}))
+
+	// Records written by the old serializer are still readable.
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	accessIDs, err := redis.Strings(conn.Do("SMEMBERS", storage.makeKey("client_tokens", client.GetId())))
+	assert.NoError(t, err)
+	raw, err := redis.Bytes(conn.Do("GET", storage.makeKey("access", accessIDs[0])))
+	assert.NoError(t, err)
+	assert.Equal(t, byte('{'), raw[2])
+
+	accessFound, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, accessFound.AccessToken)
+
+	// Without the fallback the old record cannot be decoded.
+	_, err = New(pool, "test123", WithSerializer(JSONSerializer{})).GetClient(client.GetId())
+	assert.Error(t, err)
+}
+
+func TestWithClientAndTokenSerializers(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123",
+		WithClientSerializer(JSONSerializer{}),
+		WithTokenSerializer(MsgpackSerializer{}))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	raw, err := redis.Bytes(conn.Do("GET", storage.makeKey("client", client.GetId())))
+	assert.NoError(t, err)
+	assert.Equal(t, byte('{'), raw[2])
+
+	raw, err = redis.Bytes(conn.Do("GET", storage.makeKey("auth", authorizeData.Code)))
+	assert.NoError(t, err)
+	assert.NoError(t, (MsgpackSerializer{}).Unmarshal(raw[2:], &osin.AuthorizeData{}))
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+
+	authFound, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAuthorizeData(authFound, authorizeData))
+}
+
+func BenchmarkGobSerializerMarshal(b *testing.B) {
+	accessData := newAccessData(newAuthorizeData(newClient()))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := (GobSerializer{}).Marshal(accessData); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGobSerializerMarshalLargeClient(b *testing.B) {
+	client := newClient()
+	client.UserData = strings.Repeat("metadata", 8<<10)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := (GobSerializer{}).Marshal(client); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestGobSerializerLearnsSize(t *testing.T) {
+	client := newClient()
+	client.UserData = strings.Repeat("metadata", 8<<10)
+
+	for i := 0; i < 64; i++ {
+		_, err := (GobSerializer{}).Marshal(client)
+		assert.NoError(t, err)
+	}
+	assert.InDelta(t, 64<<10, gobSizeHint.Load(), 1<<10)
+
+	payload, err := (GobSerializer{}).Marshal(client)
+	assert.NoError(t, err)
+	var decoded osin.DefaultClient
+	assert.NoError(t, (GobSerializer{}).Unmarshal(payload, &decoded))
+	assert.Equal(t, client.UserData, decoded.UserData)
+}
+
+type appSession struct {
+	UserID string
+	Roles  []string
+}
+
+type appClient struct {
+	osin.DefaultClient
+	Tenant string
+}
+
+func TestWithGobTypes(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithGobTypes(&appSession{}, &appClient{}))
+
+	client := &appClient{DefaultClient: *newClient(), Tenant: "acme"}
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(newClient())
+	authorizeData.Client = client
+	accessData := newAccessData(authorizeData)
+	accessData.Client = client
+	accessData.UserData = &appSession{UserID: "42", Roles: []string{"admin"}}
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	accessID, err := redis.String(conn.Do("GET", storage.makeKey("access_token", accessData.AccessToken)))
+	assert.NoError(t, err)
+	access, err := storage.readAccessGob(conn, accessID)
+	assert.NoError(t, err)
+	assert.Equal(t, &appSession{UserID: "42", Roles: []string{"admin"}}, access.UserData)
+	assert.Equal(t, client, access.Client)
+}
+
+func TestWithMaxPayloadBytes(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithMaxPayloadBytes(4096))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	accessData.AccessToken = "huge"
+	accessData.UserData = map[string]interface{}{"blob": strings.Repeat("x", 8192)}
+	err := storage.SaveAccess(accessData)
+	assert.True(t, errors.Is(err, ErrPayloadTooLarge))
+	_, err = storage.LoadAccess("huge")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.Panics(t, func() { WithMaxPayloadBytes(-1) })
+}
@@ -1,329 +1,1586 @@
 package osinredis
 
 import (
-	"bytes"
-	"encoding/gob"
-	"fmt"
+	"context"
+	"crypto/cipher"
+	"strings"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/openshift/osin"
 	"github.com/pkg/errors"
 	"github.com/satori/go.uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func init() {
-	gob.Register(map[string]interface{}{})
-	gob.Register(&osin.DefaultClient{})
-	gob.Register(osin.AuthorizeData{})
-	gob.Register(osin.AccessData{})
-}
+var (
+	// ErrUnsupportedSchemaVersion is returned when a stored value carries a
+	// schema version this package does not know how to decode.
+	ErrUnsupportedSchemaVersion = errors.New("unsupported schema version")
+
+	// ErrNotFound is returned when the requested client, authorize data or
+	// access data does not exist. Redis errors are never reported as
+	// ErrNotFound. It is osin.ErrNotFound itself, which osin compares by
+	// identity, so GetClient returns it unwrapped.
+	ErrNotFound = osin.ErrNotFound
+
+	// ErrTokenExpired is returned by LoadAccess and LoadAccessInfo for access
+	// data past its CreatedAt+ExpiresIn that is still stored, for example
+	// because it was saved without a TTL.
+	ErrTokenExpired = errors.New("token expired")
+
+	// ErrClientNotFound is returned by SaveAccess when client validation is
+	// enabled and the access data references a client that is not stored.
+	ErrClientNotFound = errors.New("client not found")
+
+	// ErrPayloadTooLarge is returned when an encoded record exceeds the limit
+	// set with WithMaxPayloadBytes. Nothing is written in that case.
+	ErrPayloadTooLarge = errors.New("payload too large")
+
+	// ErrPoolExhausted is returned when the pool has reached its MaxActive
+	// connections and does not Wait for one to be returned, so that callers
+	// can shed load rather than report an internal error. It is
+	// redis.ErrPoolExhausted itself, so matching either with errors.Is works.
+	ErrPoolExhausted = redis.ErrPoolExhausted
+
+	// ErrEmptyToken is returned when authorize data without a code, or access
+	// data without an access token or with an empty refresh token, is saved.
+	// Such records would all share the same key, so nothing is written.
+	ErrEmptyToken = errors.New("empty token")
+)
 
 // Storage implements "github.com/openshift/osin".Storage
 type Storage struct {
-	pool      *redis.Pool
-	keyPrefix string
+	pool       *redis.Pool
+	readPool   *redis.Pool
+	connFunc   func() redis.Conn
+	connGetter func(ctx context.Context) (redis.Conn, func(), error)
+	keyPrefix  string
+	keySep     string
+	hashTag    bool
+	serializer Serializer
+	namespaces Namespaces
+
+	clientSerializer    Serializer
+	fallbackSerializers []Serializer
+	migrateFrom         Serializer
+
+	encryptKey  cipher.AEAD
+	decryptKeys []cipher.AEAD
+
+	ctx        context.Context
+	observer   Observer
+	logger     Logger
+	tracer     trace.Tracer
+	newID      func() string
+	now        func() time.Time
+	userIDFunc func(osin.AccessData) (string, bool)
+	tagsFunc   func(*osin.AccessData) []string
+
+	usernameFunc         func(userData interface{}) string
+	validateClientOnSave bool
+	refreshTTL           time.Duration
+	slidingRefreshTTL    time.Duration
+	username             string
+	dialOptions          []redis.DialOption
+	maxTokensPerClient   int
+	cascadeClientDelete  bool
+	flushBatchSize       int
+	scanCount            int
+	hashTokenKeys        bool
+	poolSelector         func(ctx context.Context) *redis.Pool
+	strictRemove         bool
+	embeddedClients      bool
+	rejectCodeReuse      bool
+	clientCache          *clientCache
+	tokenKeyFunc         func(token string) string
+	events               chan<- Event
+	auditWriter          *auditWriter
+	directAccessKeys     bool
+	orphanTokenPolicy    OrphanTokenPolicy
+	auditMaxLen          int
+	operationTimeout     time.Duration
+	secretHasher         func(plaintext string) string
+	clientTTLFunc        func(osin.Client) time.Duration
+	maxPayloadBytes      int
+	userDataCodec        *userDataCodec
+	retryAttempts        int
+	retryDelay           time.Duration
+	intercept            CommandInterceptor
+	revokedTokenTTL      time.Duration
+	failFast             bool
+	keyFunc              func(namespace, id string) string
+	indexReaper          bool
+	authorizeTTL         time.Duration
+	ttlJitter            float64
+}
+
+// New initializes and returns a new Storage. It panics if pool is nil; use
+// NewChecked to also reject an empty key prefix.
+func New(pool *redis.Pool, keyPrefix string, opts ...Option) *Storage {
+	if pool == nil {
+		panic("osinredis: nil pool")
+	}
+	s := newStorage(keyPrefix, opts)
+	s.pool = pool
+	return s
+}
+
+// NewChecked is like New but returns an error instead of panicking if pool is
+// nil, and also returns an error if keyPrefix is empty or only whitespace,
+// since unprefixed keys collide with those of other applications sharing the
+// Redis instance.
+func NewChecked(pool *redis.Pool, keyPrefix string, opts ...Option) (*Storage, error) {
+	if pool == nil {
+		return nil, errors.New("osinredis: nil pool")
+	}
+	if strings.TrimSpace(keyPrefix) == "" {
+		return nil, errors.New("osinredis: empty key prefix")
+	}
+	return New(pool, keyPrefix, opts...), nil
+}
+
+// newStorage returns a Storage with the given options applied on top of the
+// defaults, leaving the connection source to the caller.
+func newStorage(keyPrefix string, opts []Option) *Storage {
+	s := &Storage{
+		keyPrefix:  keyPrefix,
+		keySep:     ":",
+		serializer: GobSerializer{},
+		logger:     nopLogger{},
+		newID:      newUUID,
+		now:        time.Now,
+		namespaces: DefaultNamespaces(),
+
+		clientSerializer: GobSerializer{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.checkNamespaces()
+	s.checkKeyFunc()
+	return s
+}
+
+func newUUID() string {
+	return uuid.NewV4().String()
 }
 
-// New initializes and returns a new Storage
-func New(pool *redis.Pool, keyPrefix string) *Storage {
-	return &Storage{
-		pool:      pool,
-		keyPrefix: keyPrefix,
+// getConn returns a connection from the pool, or from the connection source
+// or getter the Storage was constructed with.
+func (s *Storage) getConn() redis.Conn {
+	if s.connFunc != nil {
+		return s.interceptConn(s.retryConn(s.connFunc))
 	}
+	if s.connGetter != nil {
+		return s.interceptConn(s.retryConn(s.getterConn))
+	}
+	return s.interceptConn(s.retryConn(func() redis.Conn { return s.getPoolConn(s.selectPool()) }))
+}
+
+// getReadConn returns a connection for read-only commands, from the read pool
+// if one is configured with WithReadPool.
+func (s *Storage) getReadConn() redis.Conn {
+	if s.readPool != nil && s.connGetter == nil && s.selectedPool() == nil {
+		return s.interceptConn(s.retryConn(func() redis.Conn { return s.getPoolConn(s.readPool) }))
+	}
+	return s.getConn()
 }
 
 // Clone the storage if needed. For example, using mgo, you can clone the session with session.Clone
 // to avoid concurrent access problems.
 // This is to avoid cloning the connection at each method access.
 // Can return itself if not a problem.
+//
+// Clone does not check the connection; stale pooled connections are only
+// detected if the pool sets TestOnBorrow, as the pools built by NewStorage and
+// its siblings do. Use ClonePinged to fail fast instead.
 func (s *Storage) Clone() osin.Storage {
 	return s
 }
 
+// ClonePinged is like Clone, but first checks with Ping that the storage can
+// obtain a live connection, and returns the error if not.
+func (s *Storage) ClonePinged() (osin.Storage, error) {
+	if err := s.Ping(); err != nil {
+		return nil, err
+	}
+	return s.Clone(), nil
+}
+
+// Namespaced returns a shallow copy of s whose key prefix is extended by
+// subPrefix, joined with the key separator, for example to serve many tenants
+// from one configured Storage: New(pool, "oauth", opts...).Namespaced("acme")
+// stores its keys under "oauth:acme:". The copy shares the pool, options and
+// context of s, but has a client cache of its own if WithClientCache is set,
+// so the clients of different tenants are never confused. Keys built by the
+// function set with WithKeyFunc do not use the prefix.
+//
+// The keys of every copy are under the prefix of s, so Flush and Export of s
+// include them, and records stored through s itself may collide with them,
+// for example a client of s with ID "client:1" and a client of the copy with
+// ID "1" if subPrefix is "client". Keep s for deriving copies only. Namespaced
+// panics if subPrefix is empty.
+func (s *Storage) Namespaced(subPrefix string) *Storage {
+	if subPrefix == "" {
+		panic("osinredis: empty sub-prefix")
+	}
+	s2 := *s
+	s2.keyPrefix = s.keyPrefix + s.keySep + subPrefix
+	if s.clientCache != nil {
+		s2.clientCache = newClientCache(s.clientCache.size, s.clientCache.ttl)
+	}
+	return &s2
+}
+
 // Close the resources the Storage potentially holds (using Clone for example)
+//
+// osin calls Close on the clone it took at the end of every request, so Close
+// does nothing: in particular it never closes the pool, which may be shared
+// with the rest of the application and stays with whoever created it.
 func (s *Storage) Close() {}
 
-// CreateClient inserts a new client
-func (s *Storage) CreateClient(client osin.Client) error {
-	conn := s.pool.Get()
+// Ping checks that a connection can be obtained from the pool and that the
+// server answers PING, for use in health checks.
+func (s *Storage) Ping() (err error) {
+	defer s.observe("Ping", time.Now(), &err)
+	s, span := s.startSpan("Ping", "PING", "")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return errors.Wrap(err, "failed to get connection")
+	}
+
+	defer conn.Close()
+
+	reply, err := redis.String(conn.Do("PING"))
+	if err != nil {
+		return errors.Wrap(err, "failed to ping")
+	}
+	if reply != "PONG" {
+		return errors.Errorf("unexpected ping reply %q", reply)
+	}
+	return nil
+}
+
+// CreateClient inserts a new client. A failed write is not retried, since
+// repeating it would bump the client version twice.
+func (s *Storage) CreateClient(client osin.Client) (err error) {
+	defer s.observe("CreateClient", time.Now(), &err)
+	s, span := s.startSpan("CreateClient", "SET", "client")
+	defer span.end(&err)
+
+	conn := s.getConn()
 	if err := conn.Err(); err != nil {
 		return err
 	}
 
 	defer conn.Close()
 
-	payload, err := encode(client)
+	payload, err := s.encodeClient(client)
 	if err != nil {
 		return errors.Wrap(err, "failed to encode client")
 	}
 
-	_, err = conn.Do("SET", s.makeKey("client", client.GetId()), payload)
-	return errors.Wrap(err, "failed to save client")
+	previous, err := s.storedRedirectURIs(conn, []string{client.GetId()})
+	if err != nil {
+		return err
+	}
+
+	s.sendSaveClient(conn, client, payload, previous[0])
+	_, err = flush(conn)
+	s.clientCache.remove(client.GetId())
+	if err != nil {
+		return errors.Wrap(err, "failed to save client")
+	}
+	s.emit(EventCreated, "client", client.GetId(), client.GetId())
+	return nil
 }
 
-// GetClient gets a client by ID
-func (s *Storage) GetClient(id string) (osin.Client, error) {
-	conn := s.pool.Get()
-	if err := conn.Err(); err != nil {
-		return nil, err
+// GetClient gets a client by ID. It returns ErrNotFound if there is no such
+// client.
+func (s *Storage) GetClient(id string) (_ osin.Client, err error) {
+	defer s.observe("GetClient", time.Now(), &err)
+	s, span := s.startSpan("GetClient", "GET", "client")
+	defer span.end(&err)
+
+	if client, ok := s.clientCache.get(id, s.now()); ok {
+		return s.hashedClient(client), nil
 	}
 
-	defer conn.Close()
+	var client osin.Client
+	err = s.retry(func() (err error) {
+		conn := s.getReadConn()
+		if err := conn.Err(); err != nil {
+			return err
+		}
 
-	var (
-		rawClientGob interface{}
-		err          error
-	)
+		defer conn.Close()
 
-	if rawClientGob, err = conn.Do("GET", s.makeKey("client", id)); err != nil {
+		client, err = s.getClient(conn, id)
+		return err
+	})
+	if errors.Is(err, ErrNotFound) {
+		// osin tells an unknown client from a failure with ==.
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.clientCache.put(client, s.now())
+	return client, nil
+}
+
+// getClient reads and decodes the client with the given ID over conn.
+func (s *Storage) getClient(conn redis.Conn, id string) (osin.Client, error) {
+	key := s.makeKey("client", id)
+	rawClientGob, err := conn.Do("GET", key)
+	if err != nil {
 		return nil, errors.Wrap(err, "unable to GET client")
 	}
 	if rawClientGob == nil {
-		return nil, nil
+		s.logger.Log(LevelDebug, "client miss", "key", key)
+		return nil, errors.Wrap(ErrNotFound, "client not stored")
 	}
 
-	clientGob, _ := redis.Bytes(rawClientGob, err)
+	clientGob, err := redis.Bytes(rawClientGob, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read client")
+	}
 
 	var client osin.DefaultClient
-	err = decode(clientGob, &client)
-	return &client, errors.Wrap(err, "failed to decode client gob")
+	if err := s.decodeRecord(key, clientGob, &client); err != nil {
+		s.logger.Log(LevelWarn, "failed to decode client", "key", key, "err", err)
+		return nil, errors.Wrap(err, "failed to decode client gob")
+	}
+	s.logger.Log(LevelDebug, "client hit", "key", key)
+	return s.hashedClient(&client), nil
+}
+
+// sendSaveClient queues the commands that store client from its encoded
+// payload, bump its version and move it from the redirect indexes of the
+// previous redirect URIs to those of its current ones. It returns how many
+// commands it queued.
+func (s *Storage) sendSaveClient(conn redis.Conn, client osin.Client, payload []byte, previousURIs []string) int {
+	conn.Send("SET", s.makeKey("client", client.GetId()), payload)
+	conn.Send("SETNX", s.makeKey("client_created_at", client.GetId()), s.formatCreatedAt())
+	conn.Send("INCR", s.makeKey("client_version", client.GetId()))
+	return 3 + s.sendIndexRedirects(conn, client.GetId(), previousURIs, redirectURIs(client.GetRedirectUri()))
 }
 
-// UpdateClient updates a client
-func (s *Storage) UpdateClient(client osin.Client) error {
+// UpdateClient updates a client. It overwrites concurrent updates; use
+// UpdateClientCAS to detect them.
+func (s *Storage) UpdateClient(client osin.Client) (err error) {
+	defer s.observe("UpdateClient", time.Now(), &err)
+	s, span := s.startSpan("UpdateClient", "SET", "client")
+	defer span.end(&err)
+
 	return errors.Wrap(s.CreateClient(client), "failed to update client")
 }
 
-// DeleteClient deletes given client
-func (s *Storage) DeleteClient(client osin.Client) error {
-	conn := s.pool.Get()
+// DeleteClient deletes given client. By default the tokens issued to the
+// client stay usable until they expire; use WithCascadingClientDelete, or
+// PurgeClient, to revoke them as well. Deleting a client that does not exist
+// succeeds, unless WithStrictRemove is set, which makes it return
+// ErrNotFound.
+func (s *Storage) DeleteClient(client osin.Client) (err error) {
+	defer s.observe("DeleteClient", time.Now(), &err)
+	s, span := s.startSpan("DeleteClient", "DEL", "client")
+	defer span.end(&err)
+
+	if s.cascadeClientDelete {
+		if s.strictRemove {
+			exists, err := s.ClientExists(client.GetId())
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return errors.Wrap(ErrNotFound, "client not stored")
+			}
+		}
+		if _, err := s.removeClientTokens(client.GetId(), true); err != nil {
+			return errors.Wrap(err, "failed to delete client")
+		}
+		s.emit(EventRevoked, "client", client.GetId(), client.GetId())
+		return nil
+	}
+
+	conn := s.getConn()
 	if err := conn.Err(); err != nil {
 		return err
 	}
 
 	defer conn.Close()
 
-	_, err := conn.Do("DEL", s.makeKey("client", client.GetId()))
-	return errors.Wrap(err, "failed to delete client")
+	previous, err := s.storedRedirectURIs(conn, []string{client.GetId()})
+	if err != nil {
+		return err
+	}
+
+	conn.Send("DEL", s.makeKey("client", client.GetId()))
+	conn.Send("DEL", s.makeKey("client_created_at", client.GetId()), s.makeKey("client_version", client.GetId()))
+	s.sendIndexRedirects(conn, client.GetId(), previous[0], nil)
+	replies, err := flush(conn)
+	s.clientCache.remove(client.GetId())
+	if err != nil {
+		return errors.Wrap(err, "failed to delete client")
+	}
+	if deleted, _ := redis.Int(replies[0], nil); deleted == 0 && s.strictRemove {
+		return errors.Wrap(ErrNotFound, "client not stored")
+	}
+	s.emit(EventRevoked, "client", client.GetId(), client.GetId())
+	return nil
 }
 
-// SaveAuthorize saves authorize data.
+// SaveAuthorize saves authorize data, expiring it after data.ExpiresIn
+// seconds or the TTL set with WithAuthorizeTTL. It returns ErrEmptyToken if
+// data has no code, and with WithCodeReuseCheck ErrCodeExists if the code was
+// used before.
 func (s *Storage) SaveAuthorize(data *osin.AuthorizeData) (err error) {
-	conn := s.pool.Get()
-	if err := conn.Err(); err != nil {
+	defer s.observe("SaveAuthorize", time.Now(), &err)
+	s, span := s.startSpan("SaveAuthorize", "SETEX", "auth")
+	defer span.end(&err)
+
+	if err := checkAuthorizeCode(data); err != nil {
 		return err
 	}
-
-	defer conn.Close()
-
-	payload, err := encode(data)
+	ttl, err := s.authorizeExpiry(data)
 	if err != nil {
-		return errors.Wrap(err, "failed to encode data")
+		return err
 	}
 
-	_, err = conn.Do("SETEX", s.makeKey("auth", data.Code), data.ExpiresIn, string(payload))
-	return errors.Wrap(err, "failed to set auth")
+	return s.retry(func() error {
+		conn := s.getConn()
+		if err := conn.Err(); err != nil {
+			return err
+		}
+
+		defer conn.Close()
+
+		payload, err := s.encode(data)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode data")
+		}
+
+		key := s.tokenKey("auth", data.Code)
+		switch {
+		case s.rejectCodeReuse:
+			if err := s.saveUnusedAuthorize(conn, data, ttl, payload); err != nil {
+				if isTransient(err) {
+					// A retry could find the code this attempt saved before
+					// its reply was lost, and report it as reused.
+					return retriedError{err}
+				}
+				return err
+			}
+		case data.CodeChallenge == "" && s.userIDFunc == nil:
+			_, err = conn.Do("SETEX", key, ttl, string(payload))
+		default:
+			conn.Send("MULTI")
+			conn.Send("SETEX", key, ttl, string(payload))
+			s.sendSaveChallenge(conn, data, ttl)
+			s.sendIndexAuthorize(conn, data)
+			_, err = exec(conn)
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to set auth")
+		}
+		s.emitAuthorize(EventCreated, data)
+		return nil
+	})
 }
 
 // LoadAuthorize looks up AuthorizeData by a code.
 // Client information MUST be loaded together.
-// Optionally can return error if expired.
-func (s *Storage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
-	conn := s.pool.Get()
-	if err := conn.Err(); err != nil {
-		return nil, err
-	}
+// It returns ErrCodeRevoked if the code was removed recently, and ErrNotFound
+// if the code or its client does not exist. Redis drops expired codes like
+// any other key, so an expired code is reported as ErrNotFound.
+func (s *Storage) LoadAuthorize(code string) (_ *osin.AuthorizeData, err error) {
+	defer s.observe("LoadAuthorize", time.Now(), &err)
+	s, span := s.startSpan("LoadAuthorize", "GET", "auth")
+	defer span.end(&err)
+
+	var auth *osin.AuthorizeData
+	err = s.retry(func() error {
+		conn := s.getReadConn()
+		if err := conn.Err(); err != nil {
+			return err
+		}
 
-	defer conn.Close()
+		defer conn.Close()
 
-	var (
-		rawAuthGob interface{}
-		err        error
-	)
+		rawAuthGob, err := conn.Do("GET", s.tokenKey("auth", code))
+		if err != nil {
+			return errors.Wrap(err, "unable to GET auth")
+		}
 
-	if rawAuthGob, err = conn.Do("GET", s.makeKey("auth", code)); err != nil {
-		return nil, errors.Wrap(err, "unable to GET auth")
-	}
+		auth, err = s.decodeAuthorize(conn, code, rawAuthGob)
+		return err
+	})
+	return auth, err
+}
+
+// decodeAuthorize decodes the authorize data read from the auth key of code
+// and loads its client over conn. A nil reply yields ErrCodeRevoked or
+// ErrNotFound, see authorizeMiss.
+func (s *Storage) decodeAuthorize(conn redis.Conn, code string, rawAuthGob interface{}) (*osin.AuthorizeData, error) {
 	if rawAuthGob == nil {
-		return nil, nil
+		s.logger.Log(LevelDebug, "auth miss", "namespace", "auth")
+		return nil, s.authorizeMiss(conn, code)
 	}
 
-	authGob, _ := redis.Bytes(rawAuthGob, err)
+	key := s.tokenLogKey("auth", code)
+	authGob, err := redis.Bytes(rawAuthGob, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read auth")
+	}
 
 	var auth osin.AuthorizeData
-	err = decode(authGob, &auth)
-	return &auth, errors.Wrap(err, "failed to decode auth")
+	if err := s.decodeRecord(key, authGob, &auth); err != nil {
+		s.logger.Log(LevelWarn, "failed to decode auth", "namespace", "auth", "err", err)
+		return nil, errors.Wrap(err, "failed to decode auth")
+	}
+	s.logger.Log(LevelDebug, "auth hit", "namespace", "auth")
+
+	if err := s.refreshAuthorizeClient(conn, &auth); err != nil {
+		return nil, err
+	}
+	s.emitAuthorize(EventLoaded, &auth)
+	return &auth, nil
 }
 
-// RemoveAuthorize revokes or deletes the authorization code.
+// RemoveAuthorize revokes or deletes the authorization code. A removed code
+// is remembered for a while, so that LoadAuthorize reports it as
+// ErrCodeRevoked rather than ErrNotFound; the code is deleted and marked in
+// one Lua script, so neither happens without the other. Removing a code that
+// does not exist succeeds, unless WithStrictRemove is set, which makes it
+// return the error LoadAuthorize would.
 func (s *Storage) RemoveAuthorize(code string) (err error) {
-	conn := s.pool.Get()
-	if err := conn.Err(); err != nil {
-		return err
-	}
+	defer s.observe("RemoveAuthorize", time.Now(), &err)
+	s, span := s.startSpan("RemoveAuthorize", "EVALSHA", "auth")
+	defer span.end(&err)
+
+	return s.retry(func() error {
+		conn := s.getConn()
+		if err := conn.Err(); err != nil {
+			return err
+		}
 
-	defer conn.Close()
+		defer conn.Close()
 
-	_, err = conn.Do("DEL", s.makeKey("auth", code))
-	return errors.Wrap(err, "failed to delete auth")
+		removed, err := redis.Int(removeAuthorizeScript.Do(conn,
+			s.tokenKey("auth", code),
+			s.tokenKey("auth_challenge", code),
+			s.tokenKey("auth_revoked", code),
+			int64(revokedCodeTTL/time.Second)))
+		if err != nil {
+			return errors.Wrap(err, "failed to delete auth")
+		}
+		if removed == 0 {
+			if s.strictRemove {
+				return s.authorizeMiss(conn, code)
+			}
+			return nil
+		}
+		s.emit(EventRevoked, "auth", "", "")
+		return nil
+	})
 }
 
-// SaveAccess creates AccessData.
+// SaveAccess creates AccessData. The access data and its access token expire
+// after data.ExpiresIn seconds, and the refresh token after the TTL configured
+// with WithRefreshTTL, defaulting to the same lifetime. A zero ExpiresIn stores
+// the records without expiry. The access ID is also added to the client's
+// token index so the client's tokens can be found again by PurgeClient and
+// RemoveAllForClient, and to the creation-time index used by
+// ListAccessCreatedBetween. All of these writes happen in a single MULTI/EXEC
+// transaction, so they are stored all or none. The generated access ID is
+// checked to be unused, and replaced if it is, so a colliding ID generator
+// cannot overwrite other access data.
 func (s *Storage) SaveAccess(data *osin.AccessData) (err error) {
-	conn := s.pool.Get()
+	defer s.observe("SaveAccess", time.Now(), &err)
+	s, span := s.startSpan("SaveAccess", "MULTI", "access")
+	defer span.end(&err)
+
+	if err := checkAccessTokens(data); err != nil {
+		return err
+	}
+
+	conn := s.getConn()
 	if err := conn.Err(); err != nil {
 		return err
 	}
 
 	defer conn.Close()
 
-	payload, err := encode(data)
+	if err := s.validateClient(conn, data); err != nil {
+		return err
+	}
+
+	s.applyClientTTL(data)
+	payload, err := s.encode(data)
 	if err != nil {
 		return errors.Wrap(err, "failed to encode access")
 	}
 
-	accessID := uuid.NewV4().String()
+	limit := s.maxTokensPerClient > 0 && data.Client != nil
+	for attempt := 0; attempt < maxWatchAttempts; attempt++ {
+		var evict map[string]*osin.AccessData
+		if limit {
+			if evict, err = s.tokensToEvict(conn, data.Client.GetId()); err != nil {
+				conn.Do("UNWATCH")
+				return err
+			}
+		}
+
+		accessID, err := s.accessIDFor(conn, data)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+
+		conn.Send("MULTI")
+		for evictedID, evicted := range evict {
+			s.sendRemoveAccess(conn, evictedID, evicted)
+		}
+		s.sendSaveAccess(conn, accessID, data, payload)
+
+		_, err = exec(conn)
+		if err == errTransactionAborted {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to save access")
+		}
+		for evictedID, evicted := range evict {
+			s.emitAccess(EventRevoked, evictedID, evicted)
+		}
+		s.emitAccess(EventCreated, accessID, data)
+		return nil
+	}
+
+	return errors.New("failed to save access: watched keys kept changing")
+}
+
+// maxAccessIDAttempts bounds how many generated access IDs claimAccessID tries
+// before giving up.
+const maxAccessIDAttempts = 5
+
+// claimAccessID generates an access ID that is not in use yet and watches its
+// access key, so that the transaction storing the access data aborts if the
+// same ID gets taken concurrently.
+func (s *Storage) claimAccessID(conn redis.Conn) (string, error) {
+	for attempt := 0; attempt < maxAccessIDAttempts; attempt++ {
+		accessID := s.newID()
+		key := s.makeKey("access", accessID)
+		if _, err := conn.Do("WATCH", key); err != nil {
+			return "", errors.Wrap(err, "failed to watch access ID")
+		}
+		exists, err := redis.Bool(conn.Do("EXISTS", key))
+		if err != nil {
+			return "", errors.Wrap(err, "failed to check access ID")
+		}
+		if !exists {
+			return accessID, nil
+		}
+		s.logger.Log(LevelWarn, "access ID collision", "key", key)
+	}
+	return "", errors.Errorf("failed to generate an unused access ID in %d attempts", maxAccessIDAttempts)
+}
+
+// validateClient returns ErrClientNotFound if WithValidateClientOnSave is set
+// and the client of data is not stored.
+func (s *Storage) validateClient(conn redis.Conn, data *osin.AccessData) error {
+	if !s.validateClientOnSave {
+		return nil
+	}
+	if data.Client == nil {
+		return ErrClientNotFound
+	}
 
-	if _, err := conn.Do("SETEX", s.makeKey("access", accessID), data.ExpiresIn, string(payload)); err != nil {
-		return errors.Wrap(err, "failed to save access")
+	exists, err := redis.Bool(conn.Do("EXISTS", s.makeKey("client", data.Client.GetId())))
+	if err != nil {
+		return errors.Wrap(err, "failed to check client existence")
+	}
+	if !exists {
+		return ErrClientNotFound
 	}
+	return nil
+}
 
-	if _, err := conn.Do("SETEX", s.makeKey("access_token", data.AccessToken), data.ExpiresIn, accessID); err != nil {
-		return errors.Wrap(err, "failed to register access token")
+// checkAuthorizeCode returns ErrEmptyToken if data has no code.
+func checkAuthorizeCode(data *osin.AuthorizeData) error {
+	if data.Code == "" {
+		return errors.Wrap(ErrEmptyToken, "authorize data has no code")
 	}
+	return nil
+}
 
-	_, err = conn.Do("SETEX", s.makeKey("refresh_token", data.RefreshToken), data.ExpiresIn, accessID)
-	return errors.Wrap(err, "failed to register refresh token")
+// authorizeExpiry returns the number of seconds authorize data is stored for:
+// the TTL set with WithAuthorizeTTL, or else data.ExpiresIn. It fails if that
+// is not positive, so that a code is never stored without an expiry.
+func (s *Storage) authorizeExpiry(data *osin.AuthorizeData) (int64, error) {
+	if s.authorizeTTL > 0 {
+		return int64(s.authorizeTTL / time.Second), nil
+	}
+	if data.ExpiresIn <= 0 {
+		return 0, errors.New("authorize data must expire")
+	}
+	return int64(data.ExpiresIn), nil
 }
 
-// LoadAccess gets access data with given access token
-func (s *Storage) LoadAccess(token string) (*osin.AccessData, error) {
-	return s.loadAccessByKey(s.makeKey("access_token", token))
+// checkAccessTokens returns ErrEmptyToken if data has no access token. An
+// empty refresh token means the grant has none, and gets no pointer.
+func checkAccessTokens(data *osin.AccessData) error {
+	if data.AccessToken == "" {
+		return errors.Wrap(ErrEmptyToken, "access data has no access token")
+	}
+	return nil
 }
 
-// RemoveAccess deletes AccessData with given access token
-func (s *Storage) RemoveAccess(token string) error {
-	return s.removeAccessByKey(s.makeKey("access_token", token))
+// applyClientTTL overrides the lifetime of data with the one WithClientTTLFunc
+// returns for its client, if any.
+func (s *Storage) applyClientTTL(data *osin.AccessData) {
+	if s.clientTTLFunc == nil || data.Client == nil {
+		return
+	}
+	if ttl := s.clientTTLFunc(data.Client); ttl > 0 {
+		data.ExpiresIn = int32(ttl / time.Second)
+	}
 }
 
-// LoadRefresh gets access data with given refresh token
-func (s *Storage) LoadRefresh(token string) (*osin.AccessData, error) {
-	return s.loadAccessByKey(s.makeKey("refresh_token", token))
+// sendSaveAccess queues the commands that store the encoded access data under
+// accessID and index it.
+func (s *Storage) sendSaveAccess(conn redis.Conn, accessID string, data *osin.AccessData, payload []byte) {
+	accessTTL := s.jitterTTL(int64(data.ExpiresIn))
+	refreshTTL := accessTTL
+	if s.refreshTTL > 0 {
+		refreshTTL = s.jitterTTL(int64(s.refreshTTL / time.Second))
+	}
+
+	// The access data stays around for as long as a refresh token can still
+	// load it, even after the access token expired.
+	dataTTL := accessTTL
+	if data.RefreshToken != "" && accessTTL > 0 && refreshTTL > accessTTL {
+		dataTTL = refreshTTL
+	}
+
+	pairs := redis.Args{}.
+		Add(s.makeKey("access", accessID), payload).
+		Add(s.makeKey("access_created_at", accessID), s.formatCreatedAt())
+	ttls := []int64{dataTTL, dataTTL}
+	// Grants without a refresh token, such as client_credentials grants,
+	// would otherwise all share one pointer key.
+	if !s.directAccessKeys {
+		pairs = pairs.Add(s.tokenKey("access_token", data.AccessToken), accessID)
+		ttls = append(ttls, accessTTL)
+	}
+	if data.RefreshToken != "" {
+		pairs = pairs.Add(s.tokenKey("refresh_token", data.RefreshToken), accessID)
+		ttls = append(ttls, refreshTTL)
+	}
+	sendSetsWithTTL(conn, pairs, ttls)
+	conn.Send("ZADD", s.makeNamespaceKey("access_by_created"), createdScore(data.CreatedAt), accessID)
+	if data.Client != nil {
+		conn.Send("SADD", s.makeKey("client_tokens", data.Client.GetId()), accessID)
+	}
+	if userID, ok := s.userID(data); ok {
+		conn.Send("SADD", s.makeKey("user_tokens", userID), accessID)
+	}
+	for _, tag := range s.tokenTags(data) {
+		conn.Send("SADD", s.makeKey("tag", tag), accessID)
+	}
+	if s.indexReaper && dataTTL > 0 {
+		s.sendIndexReaperKey(conn, accessID, dataTTL, s.tokenIndexKeys(data))
+	}
 }
 
-// RemoveRefresh deletes AccessData with given refresh token
-func (s *Storage) RemoveRefresh(token string) error {
-	return s.removeAccessByKey(s.makeKey("refresh_token", token))
+// LoadAccess gets access data with given access token. It returns ErrNotFound
+// if the token does not exist and ErrTokenExpired if it has expired.
+func (s *Storage) LoadAccess(token string) (_ *osin.AccessData, err error) {
+	defer s.observe("LoadAccess", time.Now(), &err)
+	s, span := s.startSpan("LoadAccess", "GET", "access_token")
+	defer span.end(&err)
+
+	access, _, err := s.loadAccessByToken("access_token", token, accessLookup{checkExpiry: true})
+	return access, err
 }
 
-func (s *Storage) removeAccessByKey(key string) error {
-	conn := s.pool.Get()
-	if err := conn.Err(); err != nil {
+// LoadAccessWithID is LoadAccess that also returns the internal access ID the
+// access data is stored under, as used by GetAccessByID and reported in
+// events.
+func (s *Storage) LoadAccessWithID(token string) (_ *osin.AccessData, accessID string, err error) {
+	defer s.observe("LoadAccessWithID", time.Now(), &err)
+	s, span := s.startSpan("LoadAccessWithID", "GET", "access_token")
+	defer span.end(&err)
+
+	return s.loadAccessByToken("access_token", token, accessLookup{checkExpiry: true})
+}
+
+// AccessInfo is a lightweight view of stored access data, carrying what an
+// RFC 7662 introspection response needs without the hydrated client.
+type AccessInfo struct {
+	// AccessID is the internal ID the access data is stored under, see
+	// GetAccessByID.
+	AccessID  string
+	ClientID  string
+	Scope     string
+	Username  string
+	ExpiresIn int32
+	CreatedAt time.Time
+}
+
+// LoadAccessInfo gets the introspection-relevant fields of the access data
+// with given access token. Unlike LoadAccess it does not re-fetch the embedded
+// clients, saving a round trip per client.
+func (s *Storage) LoadAccessInfo(token string) (_ *AccessInfo, err error) {
+	defer s.observe("LoadAccessInfo", time.Now(), &err)
+	s, span := s.startSpan("LoadAccessInfo", "GET", "access_token")
+	defer span.end(&err)
+
+	lookup := accessLookup{checkExpiry: true}
+	var (
+		access   *osin.AccessData
+		accessID string
+	)
+	err = s.retry(func() (err error) {
+		conn := s.lookupConn(lookup)
+		if err := conn.Err(); err != nil {
+			return err
+		}
+
+		defer conn.Close()
+
+		access, accessID, err = s.readAccessByToken(conn, "access_token", token, lookup)
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := &AccessInfo{
+		AccessID:  accessID,
+		Scope:     access.Scope,
+		ExpiresIn: access.ExpiresIn,
+		CreatedAt: access.CreatedAt,
+	}
+	if access.Client != nil {
+		info.ClientID = access.Client.GetId()
+	}
+	if s.usernameFunc != nil {
+		info.Username = s.usernameFunc(access.UserData)
+	}
+
+	return info, nil
+}
+
+// GetAccessByID gets access data by the internal access ID it is stored
+// under, as found in logs and events, rather than by one of its tokens. Like
+// LoadAccess it re-fetches the embedded clients, but it does not check
+// expiry. It returns ErrNotFound if there is no such access data.
+func (s *Storage) GetAccessByID(accessID string) (_ *osin.AccessData, err error) {
+	defer s.observe("GetAccessByID", time.Now(), &err)
+	s, span := s.startSpan("GetAccessByID", "GET", "access")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return nil, err
 	}
 
 	defer conn.Close()
 
-	accessID, err := redis.String(conn.Do("GET", key))
+	access, err := s.readAccessGob(conn, accessID)
 	if err != nil {
-		return errors.Wrap(err, "failed to get access")
+		return nil, err
+	}
+	if access == nil {
+		return nil, errors.Wrap(ErrNotFound, "access not stored")
 	}
 
-	access, err := s.loadAccessByKey(key)
-	if err != nil {
-		return errors.Wrap(err, "unable to load access for removal")
+	if err := s.refreshAccessClients(conn, access); err != nil {
+		return nil, err
 	}
+	return access, nil
+}
 
-	if access == nil {
+// RemoveAccess deletes AccessData with given access token. Removing a token
+// that does not exist, for example because it expired or was removed before,
+// succeeds, so that repeated logouts are safe; use WithStrictRemove to get
+// ErrNotFound instead.
+func (s *Storage) RemoveAccess(token string) (err error) {
+	defer s.observe("RemoveAccess", time.Now(), &err)
+	s, span := s.startSpan("RemoveAccess", "DEL", "access_token")
+	defer span.end(&err)
+
+	return s.removeResult(s.removeAccessByToken("access_token", token))
+}
+
+// LoadRefresh gets access data with given refresh token. It returns
+// ErrNotFound if the token does not exist.
+func (s *Storage) LoadRefresh(token string) (_ *osin.AccessData, err error) {
+	defer s.observe("LoadRefresh", time.Now(), &err)
+	s, span := s.startSpan("LoadRefresh", "GET", "refresh_token")
+	defer span.end(&err)
+
+	access, _, err := s.loadAccessByToken("refresh_token", token, accessLookup{slide: s.slidingRefreshTTL})
+	return access, err
+}
+
+// RemoveRefresh deletes AccessData with given refresh token. Like
+// RemoveAccess it succeeds for a token that does not exist unless
+// WithStrictRemove is set.
+func (s *Storage) RemoveRefresh(token string) (err error) {
+	defer s.observe("RemoveRefresh", time.Now(), &err)
+	s, span := s.startSpan("RemoveRefresh", "DEL", "refresh_token")
+	defer span.end(&err)
+
+	return s.removeResult(s.removeAccessByToken("refresh_token", token))
+}
+
+// removeResult returns the error RemoveAccess and RemoveRefresh report for
+// err, see WithStrictRemove.
+func (s *Storage) removeResult(err error) error {
+	if !s.strictRemove && errors.Is(err, ErrNotFound) {
 		return nil
 	}
+	return err
+}
 
-	accessKey := s.makeKey("access", accessID)
-	if _, err := conn.Do("DEL", accessKey); err != nil {
-		return errors.Wrap(err, "failed to delete access")
+func (s *Storage) removeAccessByToken(namespace, token string) error {
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
 	}
 
-	accessTokenKey := s.makeKey("access_token", access.AccessToken)
-	if _, err := conn.Do("DEL", accessTokenKey); err != nil {
-		return errors.Wrap(err, "failed to deregister access_token")
+	defer conn.Close()
+
+	var (
+		accessID  string
+		accessGob []byte
+	)
+	for attempt := 1; ; attempt++ {
+		reused := accessGob != nil
+		if !reused {
+			var err error
+			accessID, err = s.resolveAccessID(conn, namespace, token)
+			if err == redis.ErrNil {
+				return errors.Wrap(ErrNotFound, "token not stored")
+			}
+			if err != nil {
+				return errors.Wrap(err, "failed to get access")
+			}
+
+			accessGob, err = redis.Bytes(conn.Do("GET", s.makeKey("access", accessID)))
+			if err == redis.ErrNil {
+				if namespace == "access_token" && s.directAccessKeys {
+					return errors.Wrap(ErrNotFound, "access not stored")
+				}
+				// The access data already expired; only the dangling pointer is left.
+				_, err := conn.Do("DEL", s.tokenKey(namespace, token))
+				return errors.Wrap(err, "failed to delete dangling token")
+			}
+			if err != nil {
+				return errors.Wrap(err, "unable to load access for removal")
+			}
+		}
+
+		accessKey := s.makeKey("access", accessID)
+		var access osin.AccessData
+		if err := s.decodeRecord(accessKey, accessGob, &access); err != nil {
+			s.logger.Log(LevelWarn, "failed to decode access", "key", accessKey, "err", err)
+			return errors.Wrap(err, "unable to load access for removal")
+		}
+		if reused && !holdsToken(&access, namespace, token) {
+			// The access ID was reused for other tokens; look the token up
+			// again.
+			accessGob = nil
+			continue
+		}
+
+		expected := accessGob
+		if attempt >= maxWatchAttempts {
+			// Rather than retrying forever against a token saved over and
+			// over, remove what was read last. Index entries of a newer
+			// version may be left behind, like those of expired tokens.
+			expected = nil
+		}
+		revoked, current, err := s.revokeAccess(conn, accessID, expected, &access)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete access")
+		}
+		if revoked {
+			if err := s.markTokensRevoked(conn, &access); err != nil {
+				return err
+			}
+			s.emitAccess(EventRevoked, accessID, &access)
+			return nil
+		}
+		if current == nil {
+			// Removed concurrently, which is as good as removing it here.
+			return errors.Wrap(ErrNotFound, "access not stored")
+		}
+		// The access data changed since it was read: retry with what it
+		// holds now.
+		accessGob = current
 	}
+}
+
+// maxWatchAttempts bounds how often the operations that guard their writes
+// against concurrent changes start over when the keys they read change
+// underneath them: the WATCH loops of SaveAccess, RotateRefresh, PurgeClient
+// and RemoveAllForClient, and the checked removals of RemoveAccess and
+// RemoveRefresh.
+const maxWatchAttempts = 5
+
+// PurgeClient deletes the client record together with every access token,
+// refresh token and index entry belonging to it. The tokens are removed by a
+// Lua script per chunk of the client's token index, and the client record in
+// a MULTI/EXEC guarded by WATCH on the emptied index, so a token issued
+// concurrently either gets purged as well or the purge goes on until the
+// index stays empty. It returns the number of tokens removed.
+func (s *Storage) PurgeClient(id string) (tokensRemoved int, err error) {
+	defer s.observe("PurgeClient", time.Now(), &err)
+	s, span := s.startSpan("PurgeClient", "MULTI", "client_tokens")
+	defer span.end(&err)
+
+	return s.removeClientTokens(id, true)
+}
+
+// RemoveAllForClient revokes every access and refresh token issued to the
+// given client, leaving the client itself in place. Like PurgeClient it relies
+// on the client token index maintained by SaveAccess.
+func (s *Storage) RemoveAllForClient(clientID string) (err error) {
+	defer s.observe("RemoveAllForClient", time.Now(), &err)
+	s, span := s.startSpan("RemoveAllForClient", "MULTI", "client_tokens")
+	defer span.end(&err)
+
+	_, err = s.removeClientTokens(clientID, false)
+	return err
+}
+
+// LoadClientTokens returns the stored access data issued to the given client,
+// as indexed by SaveAccess. Index entries whose access data has already
+// expired are dropped from the index along the way.
+func (s *Storage) LoadClientTokens(clientID string) (_ []*osin.AccessData, err error) {
+	defer s.observe("LoadClientTokens", time.Now(), &err)
+	s, span := s.startSpan("LoadClientTokens", "SMEMBERS", "client_tokens")
+	defer span.end(&err)
 
-	refreshTokenKey := s.makeKey("refresh_token", access.RefreshToken)
-	_, err = conn.Do("DEL", refreshTokenKey)
-	return errors.Wrap(err, "failed to deregister refresh_token")
+	return s.loadIndexedAccess(s.makeKey("client_tokens", clientID))
 }
 
-func (s *Storage) loadAccessByKey(key string) (*osin.AccessData, error) {
-	conn := s.pool.Get()
+// ClientTokenCount returns the number of access data entries in the token
+// index of the given client, in constant time. Entries are only dropped from
+// the index when their access data is removed, so access data that expired
+// is still counted until LoadClientTokens, PruneIndexes or the reaper started
+// with StartIndexReaper drops it: the count is an upper bound on the
+// client's live tokens.
+func (s *Storage) ClientTokenCount(clientID string) (_ int, err error) {
+	defer s.observe("ClientTokenCount", time.Now(), &err)
+	s, span := s.startSpan("ClientTokenCount", "SCARD", "client_tokens")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	count, err := redis.Int(conn.Do("SCARD", s.makeKey("client_tokens", clientID)))
+	return count, errors.Wrap(err, "failed to count client tokens")
+}
+
+func (s *Storage) removeClientTokens(id string, deleteClient bool) (int, error) {
+	if !deleteClient {
+		return s.removeIndexedTokens(s.makeKey("client_tokens", id))
+	}
+
+	previous, err := s.clientRedirectURIs(id)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := s.removeIndexedTokens(s.makeKey("client_tokens", id), s.makeKey("client", id), s.makeKey("client_created_at", id), s.makeKey("client_version", id))
+	s.clientCache.remove(id)
+	if err != nil {
+		return removed, err
+	}
+	return removed, s.unindexRedirects(id, previous)
+}
+
+// loadIndexedAccess returns the access data referenced by the index SET at
+// indexKey with its clients refreshed, pruning entries that have expired.
+func (s *Storage) loadIndexedAccess(indexKey string) ([]*osin.AccessData, error) {
+	conn := s.getConn()
 	if err := conn.Err(); err != nil {
 		return nil, err
 	}
 
 	defer conn.Close()
 
+	accessIDs, err := redis.Strings(conn.Do("SMEMBERS", indexKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read token index")
+	}
+
 	var (
-		rawAuthGob interface{}
-		err        error
+		accesses []*osin.AccessData
+		expired  []interface{}
 	)
+	for _, accessID := range accessIDs {
+		access, err := s.readAccessGob(conn, accessID)
+		if err != nil {
+			return nil, err
+		}
+		if access == nil {
+			expired = append(expired, accessID)
+			continue
+		}
+		accesses = append(accesses, access)
+	}
 
-	if rawAuthGob, err = conn.Do("GET", key); err != nil {
-		return nil, errors.Wrap(err, "unable to GET auth")
+	accesses, err = s.refreshAccessListClients(conn, accesses)
+	if err != nil {
+		return nil, err
 	}
-	if rawAuthGob == nil {
+
+	if len(expired) > 0 {
+		if _, err := conn.Do("SREM", append([]interface{}{indexKey}, expired...)...); err != nil {
+			return nil, errors.Wrap(err, "failed to prune token index")
+		}
+	}
+
+	return accesses, nil
+}
+
+// sendRemoveAccess queues the deletion of the access data stored under
+// accessID together with its token pointers and index entries.
+func (s *Storage) sendRemoveAccess(conn redis.Conn, accessID string, access *osin.AccessData) {
+	conn.Send("DEL", s.accessDataKeys(accessID, access)...)
+	conn.Send("ZREM", s.makeNamespaceKey("access_by_created"), accessID)
+	s.sendUnindexAccess(conn, accessID, access)
+}
+
+// sendUnindexAccess sends the commands that drop accessID from the client,
+// user and tag token indexes of access.
+func (s *Storage) sendUnindexAccess(conn redis.Conn, accessID string, access *osin.AccessData) {
+	for _, key := range s.tokenIndexKeys(access) {
+		conn.Send("SREM", key, accessID)
+	}
+}
+
+// tokenIndexKeys returns the keys of the client, user and tag token indexes
+// that list access.
+func (s *Storage) tokenIndexKeys(access *osin.AccessData) []interface{} {
+	var keys []interface{}
+	if access.Client != nil {
+		keys = append(keys, s.makeKey("client_tokens", access.Client.GetId()))
+	}
+	if userID, ok := s.userID(access); ok {
+		keys = append(keys, s.makeKey("user_tokens", userID))
+	}
+	for _, tag := range s.tokenTags(access) {
+		keys = append(keys, s.makeKey("tag", tag))
+	}
+	return keys
+}
+
+// readAccessGob decodes the access data stored under the given access ID, or
+// returns nil if it no longer exists.
+func (s *Storage) readAccessGob(conn redis.Conn, accessID string) (*osin.AccessData, error) {
+	accessGob, err := redis.Bytes(conn.Do("GET", s.makeKey("access", accessID)))
+	if err == redis.ErrNil {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get access gob")
+	}
 
-	accessID, err := redis.String(conn.Do("GET", key))
+	var access osin.AccessData
+	if err := s.decodeRecord(s.makeKey("access", accessID), accessGob, &access); err != nil {
+		s.logger.Log(LevelWarn, "failed to decode access", "key", s.makeKey("access", accessID), "err", err)
+		return nil, errors.Wrap(err, "failed to decode access gob")
+	}
+	return &access, nil
+}
+
+// accessDataKeys returns the keys of the access gob, its creation time and the
+// token pointers of the given access data.
+func (s *Storage) accessDataKeys(accessID string, access *osin.AccessData) []interface{} {
+	keys := []interface{}{
+		s.makeKey("access", accessID),
+		s.makeKey("access_created_at", accessID),
+		s.makeKey("access_meta", accessID),
+	}
+	if s.indexReaper {
+		keys = append(keys, s.makeKey("access_indexes", accessID))
+	}
+	if access.AccessToken != "" && !s.directAccessKeys {
+		keys = append(keys, s.tokenKey("access_token", access.AccessToken))
+	}
+	if access.RefreshToken != "" {
+		keys = append(keys, s.tokenKey("refresh_token", access.RefreshToken))
+	}
+	return keys
+}
+
+// accessLookup controls how readAccessByToken treats the access data it finds.
+type accessLookup struct {
+	// checkExpiry makes the lookup fail with ErrTokenExpired for access data
+	// past CreatedAt+ExpiresIn, in case the keys outlived it.
+	checkExpiry bool
+	// slide, if positive, resets the TTL of the access data keys.
+	slide time.Duration
+}
+
+func (s *Storage) loadAccessByToken(namespace, token string, lookup accessLookup) (*osin.AccessData, string, error) {
+	var (
+		access   *osin.AccessData
+		accessID string
+	)
+	err := s.retry(func() (err error) {
+		conn := s.lookupConn(lookup)
+		if err := conn.Err(); err != nil {
+			return err
+		}
+
+		defer conn.Close()
+
+		if access, accessID, err = s.readAccessByToken(conn, namespace, token, lookup); err != nil {
+			return err
+		}
+		return s.refreshAccessClients(conn, access)
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to get access ID")
+		return nil, "", errors.Wrap(err, lookupLabel(namespace))
 	}
+	return access, accessID, nil
+}
+
+// lookupLabel names the lookup of a token of the given pointer namespace in
+// errors, which never include the token itself.
+func lookupLabel(namespace string) string {
+	if namespace == "refresh_token" {
+		return "refresh token lookup"
+	}
+	return "access token lookup"
+}
+
+// lookupConn returns a connection suitable for the given lookup.
+func (s *Storage) lookupConn(lookup accessLookup) redis.Conn {
+	if lookup.slide > 0 {
+		// Sliding the expiry writes.
+		return s.getConn()
+	}
+	return s.getReadConn()
+}
+
+// readAccessByToken resolves the token of the given namespace, "access_token"
+// or "refresh_token", and decodes the access gob it refers to, leaving the
+// embedded clients as they were encoded. It also returns the access ID.
+func (s *Storage) readAccessByToken(conn redis.Conn, namespace, token string, lookup accessLookup) (*osin.AccessData, string, error) {
+	accessID, err := s.resolveAccessID(conn, namespace, token)
+	if err == redis.ErrNil {
+		s.logger.Log(LevelDebug, "token miss", "namespace", namespace)
+		return nil, "", s.tokenMiss(conn, namespace, token)
+	}
+	if err != nil {
+		return nil, "", errors.Wrap(err, "unable to get access ID")
+	}
+
+	access, err := s.readAccessByID(conn, accessID, lookup)
+	if errors.Is(err, ErrNotFound) && namespace == "access_token" && s.directAccessKeys {
+		// The access token was resolved without checking it is stored.
+		return nil, "", s.tokenMiss(conn, namespace, token)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return access, accessID, nil
+}
 
+// readAccessByID decodes the access gob stored under accessID like
+// readAccessByToken, once the token has been resolved.
+func (s *Storage) readAccessByID(conn redis.Conn, accessID string, lookup accessLookup) (*osin.AccessData, error) {
 	accessIDKey := s.makeKey("access", accessID)
-	accessGob, err := redis.Bytes(conn.Do("GET", accessIDKey))
+	conn.Send("GET", accessIDKey)
+	conn.Send("TTL", accessIDKey)
+	replies, err := flush(conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get access gob")
+	}
+	if replies[0] == nil {
+		// The token pointer outlived the access data.
+		s.logger.Log(LevelDebug, "access miss", "key", accessIDKey)
+		return nil, errors.Wrap(ErrNotFound, "access not stored")
+	}
+
+	accessGob, err := redis.Bytes(replies[0], nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get access gob")
 	}
 
 	var access osin.AccessData
-	if err := decode(accessGob, &access); err != nil {
+	if err := s.decodeRecord(accessIDKey, accessGob, &access); err != nil {
+		s.logger.Log(LevelWarn, "failed to decode access", "key", accessIDKey, "err", err)
 		return nil, errors.Wrap(err, "failed to decode access gob")
 	}
+	if lookup.checkExpiry && access.ExpiresIn > 0 && access.IsExpiredAt(s.now()) {
+		s.logger.Log(LevelDebug, "access expired", "key", accessIDKey)
+		return nil, ErrTokenExpired
+	}
+	s.logger.Log(LevelDebug, "access hit", "key", accessIDKey)
+	s.emitAccess(EventLoaded, accessID, &access)
 
-	ttl, err := redis.Int(conn.Do("TTL", accessIDKey))
+	ttl, err := redis.Int(replies[1], nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get access TTL")
 	}
 
-	access.ExpiresIn = int32(ttl)
+	if lookup.slide > 0 {
+		seconds := int64(lookup.slide / time.Second)
+		for _, key := range s.accessDataKeys(accessID, &access) {
+			conn.Send("EXPIRE", key, seconds)
+		}
+		if _, err := flush(conn); err != nil {
+			return nil, errors.Wrap(err, "failed to extend access TTL")
+		}
+		ttl = int(seconds)
+	}
 
-	access.Client, err = s.GetClient(access.Client.GetId())
+	if lookup.checkExpiry {
+		ttl = s.accessTokenTTL(&access, ttl)
+	}
+	if ttl >= 0 {
+		access.ExpiresIn = int32(ttl)
+	}
+
+	return &access, nil
+}
+
+// accessTokenTTL returns the remaining lifetime in seconds of the access token
+// of access, given the ttl of its access key. The access key of access data
+// whose refresh token outlives the access token, see WithRefreshTTL, lives as
+// long as the refresh token, and WithTTLJitter extends it further, so the
+// access token expires at CreatedAt plus ExpiresIn instead.
+func (s *Storage) accessTokenTTL(access *osin.AccessData, ttl int) int {
+	if access.ExpiresIn <= 0 {
+		return ttl
+	}
+	if s.ttlJitter == 0 && (access.RefreshToken == "" || s.refreshTTL <= time.Duration(access.ExpiresIn)*time.Second) {
+		return ttl
+	}
+	remaining := int(access.ExpireAt().Sub(s.now()) / time.Second)
+	if remaining < 0 {
+		remaining = 0
+	}
+	if ttl < 0 || remaining < ttl {
+		return remaining
+	}
+	return ttl
+}
+
+// refreshAccessClients replaces the clients embedded in access with their
+// current stored versions, see applyAccessClients.
+func (s *Storage) refreshAccessClients(conn redis.Conn, access *osin.AccessData) error {
+	if s.embeddedClients {
+		return nil
+	}
+	clients, err := s.getClients(conn, accessClientIDs(access))
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to get client for access")
+		return err
+	}
+	return s.applyAccessClients(access, clients)
+}
+
+// refreshAccessListClients replaces the clients embedded in each of accesses
+// with their current stored versions, fetching every distinct client once.
+// Under OrphanTokenInvalid access data whose client no longer exists is
+// dropped from the returned list.
+func (s *Storage) refreshAccessListClients(conn redis.Conn, accesses []*osin.AccessData) ([]*osin.AccessData, error) {
+	if s.embeddedClients {
+		return accesses, nil
+	}
+	var ids []string
+	for _, access := range accesses {
+		ids = append(ids, accessClientIDs(access)...)
+	}
+	clients, err := s.getClients(conn, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := accesses[:0]
+	for _, access := range accesses {
+		if err := s.applyAccessClients(access, clients); err != nil {
+			if errors.Is(err, errOrphanToken) {
+				continue
+			}
+			return nil, err
+		}
+		refreshed = append(refreshed, access)
 	}
+	return refreshed, nil
+}
 
+// accessClientIDs returns the IDs of the clients embedded in access.
+func accessClientIDs(access *osin.AccessData) []string {
+	var ids []string
+	if access.Client != nil {
+		ids = append(ids, access.Client.GetId())
+	}
 	if access.AuthorizeData != nil && access.AuthorizeData.Client != nil {
-		access.AuthorizeData.Client, err = s.GetClient(access.AuthorizeData.Client.GetId())
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to get client for access authorize data")
+		ids = append(ids, access.AuthorizeData.Client.GetId())
+	}
+	return ids
+}
+
+// applyAccessClients replaces the clients embedded in access with those of
+// clients, keyed by ID. The client of the embedded authorize data is always
+// replaced, falling back to the access client when it was stored without
+// one, so that it is never served stale. Missing clients, and access data
+// stored without a client, are handled by the orphan token policy.
+func (s *Storage) applyAccessClients(access *osin.AccessData, clients map[string]osin.Client) error {
+	var client osin.Client
+	clientID, err := "", errors.Wrap(ErrNotFound, "access data has no client")
+	if access.Client != nil {
+		clientID = access.Client.GetId()
+		client, err = lookupClient(clients, clientID)
+	}
+	if err != nil {
+		if err := s.orphanClient(err); err != nil {
+			return errors.Wrapf(err, "unable to get client %q for access", clientID)
 		}
 	}
+	access.Client = client
 
-	return &access, nil
+	if access.AuthorizeData == nil {
+		return nil
+	}
+
+	authClientID := clientID
+	if access.AuthorizeData.Client != nil {
+		authClientID = access.AuthorizeData.Client.GetId()
+	}
+	authClient, err := lookupClient(clients, authClientID)
+	if err != nil {
+		if err := s.orphanClient(err); err != nil {
+			return errors.Wrapf(err, "unable to get client %q for access authorize data", authClientID)
+		}
+	}
+	access.AuthorizeData.Client = authClient
+	return nil
+}
+
+// lookupClient returns the client with the given ID from clients, or an
+// error wrapping ErrNotFound. A missing client is returned as a nil interface.
+func lookupClient(clients map[string]osin.Client, id string) (osin.Client, error) {
+	client, ok := clients[id]
+	if !ok {
+		return nil, errors.Wrap(ErrNotFound, "client not stored")
+	}
+	return client, nil
+}
+
+// refreshAuthorizeClient replaces the client embedded in auth with its current
+// stored version.
+func (s *Storage) refreshAuthorizeClient(conn redis.Conn, auth *osin.AuthorizeData) (err error) {
+	if auth.Client == nil {
+		return nil
+	}
+
+	auth.Client, err = s.getClient(conn, auth.Client.GetId())
+	return errors.Wrap(err, "unable to get client for authorize data")
+}
+
+// sendSetsWithTTL queues storing the key value pairs, expiring the i-th pair
+// after ttls[i] seconds. Without any expiry the pairs are stored with a single
+// MSET.
+func sendSetsWithTTL(conn redis.Conn, pairs redis.Args, ttls []int64) {
+	expiring := false
+	for _, ttl := range ttls {
+		expiring = expiring || ttl > 0
+	}
+	if !expiring {
+		conn.Send("MSET", pairs...)
+		return
+	}
+	for i, ttl := range ttls {
+		sendSetWithTTL(conn, pairs[2*i].(string), pairs[2*i+1], ttl)
+	}
+}
+
+// sendSetWithTTL queues storing value at key, expiring it after ttl seconds. A
+// ttl of zero or less stores the value without expiry.
+func sendSetWithTTL(conn redis.Conn, key string, value interface{}, ttl int64) error {
+	if ttl <= 0 {
+		return conn.Send("SET", key, value)
+	}
+	return conn.Send("SETEX", key, ttl, value)
+}
+
+// flush sends the commands queued on conn and returns their replies. Unlike
+// conn.Do(""), an error reply to any of the commands is returned as an error.
+func flush(conn redis.Conn) ([]interface{}, error) {
+	replies, err := redis.Values(conn.Do(""))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return nil, err
+		}
+	}
+	return replies, nil
+}
+
+// errTransactionAborted is returned by exec when a watched key changed.
+var errTransactionAborted = errors.New("transaction aborted")
+
+// exec runs the transaction queued on conn since MULTI and returns the
+// replies of its commands. A transaction aborted by WATCH and any failed
+// command are reported as errors.
+func exec(conn redis.Conn) ([]interface{}, error) {
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err == redis.ErrNil {
+		return nil, errTransactionAborted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return nil, err
+		}
+	}
+	return replies, nil
 }
 
 func (s *Storage) makeKey(namespace, id string) string {
-	return fmt.Sprintf("%s:%s:%s", s.keyPrefix, namespace, id)
+	if s.keyFunc != nil {
+		return s.keyFunc(s.namespace(namespace), id)
+	}
+	return s.prefix() + s.keySep + s.namespace(namespace) + s.keySep + id
 }
 
-func encode(v interface{}) ([]byte, error) {
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
-		return nil, errors.Wrap(err, "unable to encode")
+// makeNamespaceKey builds the key of a record that exists once per namespace,
+// such as an index over all tokens.
+func (s *Storage) makeNamespaceKey(namespace string) string {
+	if s.keyFunc != nil {
+		return s.keyFunc(namespace, "")
 	}
-	return buf.Bytes(), nil
+	return s.prefix() + s.keySep + namespace
 }
 
-func decode(data []byte, v interface{}) error {
-	err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(v)
-	return errors.Wrap(err, "unable to decode")
+// prefix returns the leading key segment, wrapped in a Redis Cluster hash tag
+// if WithClusterHashTag is set.
+func (s *Storage) prefix() string {
+	if s.hashTag {
+		return "{" + s.keyPrefix + "}"
+	}
+	return s.keyPrefix
 }
@@ -1,6 +1,8 @@
 package osinredis
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -128,8 +130,8 @@ func TestGetClientNotFound(t *testing.T) {
 	storage := initTestStorage()
 
 	clientFound, err := storage.GetClient("notthere")
-	assert.NoError(t, err)
 	assert.Nil(t, clientFound)
+	assert.True(t, errors.Is(err, ErrNotFound))
 }
 
 func TestUpdateClient(t *testing.T) {
@@ -159,6 +161,46 @@ func TestDeleteClient(t *testing.T) {
 
 	err := storage.DeleteClient(client)
 	assert.NoError(t, err)
+	assert.NoError(t, storage.DeleteClient(client))
+}
+
+func TestDeleteClientStrict(t *testing.T) {
+	for name, storage := range map[string]*Storage{
+		"plain":     New(pool, "test123", WithStrictRemove()),
+		"cascading": New(pool, "test123", WithStrictRemove(), WithCascadingClientDelete()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			flushAll()
+
+			client := newClient()
+			assert.NoError(t, storage.CreateClient(client))
+			assert.NoError(t, storage.DeleteClient(client))
+
+			err := storage.DeleteClient(client)
+			assert.True(t, errors.Is(err, ErrNotFound))
+		})
+	}
+}
+
+func TestDeleteClientCascading(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithCascadingClientDelete())
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	assert.NoError(t, storage.DeleteClient(client))
+
+	_, err := storage.GetClient(client.GetId())
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
 }
 
 func TestSaveAuthorize(t *testing.T) {
@@ -179,7 +221,7 @@ func TestLoadAuthorizeNonExistent(t *testing.T) {
 	storage := initTestStorage()
 	loadData, err := storage.LoadAuthorize("nonExistentCode")
 	assert.Nil(t, loadData)
-	assert.NoError(t, err)
+	assert.True(t, errors.Is(err, ErrNotFound))
 }
 
 func TestLoadAuthorize(t *testing.T) {
@@ -198,11 +240,46 @@ func TestLoadAuthorize(t *testing.T) {
 	assert.True(t, isEqualAuthorizeData(loadData, authorizeData))
 }
 
+func TestLoadAuthorizeRefreshesClient(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	client.RedirectUri = "http://localhost/updated"
+	assert.NoError(t, storage.UpdateClient(client))
+
+	loadData, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, client.RedirectUri, loadData.Client.GetRedirectUri())
+
+	assert.NoError(t, storage.DeleteClient(client))
+	loadData, err = storage.LoadAuthorize(authorizeData.Code)
+	assert.Nil(t, loadData)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
 func TestRemoveAuthorizeNonExistent(t *testing.T) {
 	flushAll()
 
 	storage := initTestStorage()
 	assert.NoError(t, storage.RemoveAuthorize("nonExistentCode"))
+
+	strict := New(pool, "test123", WithStrictRemove())
+	err := strict.RemoveAuthorize("nonExistentCode")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	client := newClient()
+	assert.NoError(t, strict.CreateClient(client))
+	assert.NoError(t, strict.SaveAuthorize(newAuthorizeData(client)))
+	assert.NoError(t, strict.RemoveAuthorize("8888"))
+	err = strict.RemoveAuthorize("8888")
+	assert.True(t, errors.Is(err, ErrCodeRevoked))
 }
 
 func TestRemoveAuthorize(t *testing.T) {
@@ -219,7 +296,7 @@ func TestRemoveAuthorize(t *testing.T) {
 
 	loadData, err := storage.LoadAuthorize(authorizeData.Code)
 	assert.Nil(t, loadData)
-	assert.NoError(t, err)
+	assert.True(t, errors.Is(err, ErrNotFound))
 }
 
 func TestSaveAccess(t *testing.T) {
@@ -244,7 +321,9 @@ func TestLoadAccessNonExistent(t *testing.T) {
 
 	loadData, err := storage.LoadAccess("nonExistentToken")
 	assert.Nil(t, loadData)
-	assert.NoError(t, err)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Contains(t, err.Error(), "access token lookup")
+	assert.NotContains(t, err.Error(), "nonExistentToken")
 }
 
 func TestLoadAccess(t *testing.T) {
@@ -276,8 +355,11 @@ func TestRemoveAccessNonExistent(t *testing.T) {
 
 	storage := initTestStorage()
 
-	err := storage.RemoveAccess("nonExistentToken")
-	assert.Error(t, err)
+	assert.NoError(t, storage.RemoveAccess("nonExistentToken"))
+
+	strict := New(pool, "test123", WithStrictRemove())
+	err := strict.RemoveAccess("nonExistentToken")
+	assert.True(t, errors.Is(err, ErrNotFound))
 }
 
 func TestRemoveAccess(t *testing.T) {
@@ -297,7 +379,7 @@ func TestRemoveAccess(t *testing.T) {
 
 	loadData, err := storage.LoadAccess(accessData.AccessToken)
 	assert.Nil(t, loadData)
-	assert.NoError(t, err)
+	assert.True(t, errors.Is(err, ErrNotFound))
 }
 
 func TestLoadRefreshNonExistent(t *testing.T) {
@@ -307,7 +389,9 @@ func TestLoadRefreshNonExistent(t *testing.T) {
 
 	loadData, err := storage.LoadRefresh("nonExistentToken")
 	assert.Nil(t, loadData)
-	assert.NoError(t, err)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Contains(t, err.Error(), "refresh token lookup")
+	assert.NotContains(t, err.Error(), "nonExistentToken")
 }
 
 func TestLoadRefresh(t *testing.T) {
@@ -334,8 +418,11 @@ func TestRemoveRefreshNonExistent(t *testing.T) {
 
 	storage := initTestStorage()
 
-	err := storage.RemoveRefresh("nonExistentToken")
-	assert.Error(t, err)
+	assert.NoError(t, storage.RemoveRefresh("nonExistentToken"))
+
+	strict := New(pool, "test123", WithStrictRemove())
+	err := strict.RemoveRefresh("nonExistentToken")
+	assert.True(t, errors.Is(err, ErrNotFound))
 }
 
 func TestRemoveRefresh(t *testing.T) {
@@ -357,5 +444,1015 @@ func TestRemoveRefresh(t *testing.T) {
 
 	loadData, err := storage.LoadRefresh(accessData.RefreshToken)
 	assert.Nil(t, loadData)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestPurgeClient(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	accessData := newAccessData(authorizeData)
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	secondAccess := newAccessData(authorizeData)
+	secondAccess.AccessToken = "9999"
+	secondAccess.RefreshToken = "r9999"
+	assert.NoError(t, storage.SaveAccess(secondAccess))
+
+	removed, err := storage.PurgeClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.Nil(t, clientFound)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	conn := pool.Get()
+	defer conn.Close()
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:*"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{storage.makeKey("auth", authorizeData.Code)}, keys)
+}
+
+func TestPurgeClientNonExistent(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	removed, err := storage.PurgeClient("notthere")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+}
+
+func TestLoadAccessInfo(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithUsernameFunc(func(userData interface{}) string {
+		return userData.(map[string]interface{})["username"].(string)
+	}))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	accessData := newAccessData(authorizeData)
+	accessData.Scope = "read write"
+	accessData.UserData = map[string]interface{}{"username": "jdoe"}
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	info, err := storage.LoadAccessInfo(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, client.GetId(), info.ClientID)
+	assert.Equal(t, "read write", info.Scope)
+	assert.Equal(t, "jdoe", info.Username)
+	assert.Equal(t, accessData.ExpiresIn, info.ExpiresIn)
+}
+
+func TestLoadAccessInfoNonExistent(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	info, err := storage.LoadAccessInfo("nonExistentToken")
+	assert.Nil(t, info)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestSaveEmptyTokens(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	conn := pool.Get()
+	defer conn.Close()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authData := newAuthorizeData(client)
+	authData.Code = ""
+	assert.True(t, errors.Is(storage.SaveAuthorize(authData), ErrEmptyToken))
+	assert.True(t, errors.Is(storage.SaveAuthorizeNX(authData), ErrEmptyToken))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.AccessToken = ""
+	assert.True(t, errors.Is(storage.SaveAccess(accessData), ErrEmptyToken))
+
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:a*"))
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// A grant without a refresh token is fine.
+	accessData = newAccessData(newAuthorizeData(client))
+	accessData.RefreshToken = ""
+	assert.NoError(t, storage.SaveAccess(accessData))
+	exists, err := redis.Bool(conn.Do("EXISTS", "test123:refresh_token:"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestSaveAccessValidateClient(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithValidateClientOnSave())
+
+	client := newClient()
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.True(t, errors.Is(storage.SaveAccess(accessData), ErrClientNotFound))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.Nil(t, loadData)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.NoError(t, storage.CreateClient(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+}
+
+func TestSaveAccessTTL(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithRefreshTTL(24*time.Hour))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	ttl, err := redis.Int(conn.Do("TTL", storage.makeKey("access_token", accessData.AccessToken)))
+	assert.NoError(t, err)
+	assert.Equal(t, int(accessData.ExpiresIn), ttl)
+
+	ttl, err = redis.Int(conn.Do("TTL", storage.makeKey("refresh_token", accessData.RefreshToken)))
+	assert.NoError(t, err)
+	assert.Equal(t, int((24 * time.Hour).Seconds()), ttl)
+
+	// The access data lives as long as the refresh token.
+	accessKeys, err := redis.Strings(conn.Do("KEYS", storage.makeKey("access", "*")))
+	assert.NoError(t, err)
+	ttl, err = redis.Int(conn.Do("TTL", accessKeys[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, int((24 * time.Hour).Seconds()), ttl)
+
+	// The access token still reports its own lifetime.
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.InDelta(t, accessData.ExpiresIn, loadData.ExpiresIn, 1)
+	tokenTTL, err := storage.TokenTTL(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Duration(accessData.ExpiresIn)*time.Second, tokenTTL, float64(time.Second))
+
+	// Once the access token expired, the refresh token still loads the grant.
+	_, err = conn.Do("DEL", storage.makeKey("access_token", accessData.AccessToken))
+	assert.NoError(t, err)
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	loadData, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loadData.AccessToken)
+}
+
+func TestSaveAccessWithoutExpiry(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.ExpiresIn = 0
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	ttl, err := redis.Int(conn.Do("TTL", storage.makeKey("access_token", accessData.AccessToken)))
+	assert.NoError(t, err)
+	assert.Equal(t, -1, ttl)
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAccessData(loadData, accessData))
+}
+
+func TestGetClientDecodeFailure(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	conn := pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", storage.makeKey("client", "corrupt"), []byte{schemaVersion1, 0xde, 0xad})
+	assert.NoError(t, err)
+
+	clientFound, err := storage.GetClient("corrupt")
+	assert.Error(t, err)
+	assert.Nil(t, clientFound)
+
+	// A reply that is not a string is reported as such.
+	storage.connFunc = func() redis.Conn {
+		return integerGetConn{pool.Get()}
+	}
+	clientFound, err = storage.GetClient("corrupt")
+	assert.ErrorContains(t, err, "unable to read client")
+	assert.Nil(t, clientFound)
+}
+
+// integerGetConn answers GET with an integer reply.
+type integerGetConn struct {
+	redis.Conn
+}
+
+func (c integerGetConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "GET" {
+		return int64(1), nil
+	}
+	return c.Conn.Do(commandName, args...)
+}
+
+func TestLoadAuthorizeDecodeFailure(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	conn := pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", storage.makeKey("auth", "corrupt"), []byte{schemaVersion1, 0xde, 0xad})
+	assert.NoError(t, err)
+
+	loadData, err := storage.LoadAuthorize("corrupt")
+	assert.Error(t, err)
+	assert.Nil(t, loadData)
+}
+
+type failingExecConn struct {
+	redis.Conn
+}
+
+func (c failingExecConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "EXEC" {
+		c.Conn.Do("DISCARD")
+		return nil, errors.New("simulated EXEC failure")
+	}
+	return c.Conn.Do(commandName, args...)
+}
+
+func TestSaveAccessAtomic(t *testing.T) {
+	flushAll()
+
+	failingPool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			conn, err := pool.Dial()
+			return failingExecConn{conn}, err
+		},
+	}
+	storage := New(failingPool, "test123")
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.Error(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:*"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		storage.makeKey("client", client.GetId()),
+		storage.makeKey("client_created_at", client.GetId()),
+		storage.makeKey("client_version", client.GetId()),
+		storage.makeKey("redirect", client.GetRedirectUri()),
+	}, keys)
+}
+
+func TestRemoveAllForClient(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+
+	accessData := newAccessData(authorizeData)
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	otherClient := newClient()
+	otherClient.Id = "otherClientID"
+	assert.NoError(t, storage.CreateClient(otherClient))
+
+	otherAccess := newAccessData(newAuthorizeData(otherClient))
+	otherAccess.AccessToken = "9999"
+	otherAccess.RefreshToken = "r9999"
+	assert.NoError(t, storage.SaveAccess(otherAccess))
+
+	assert.NoError(t, storage.RemoveAllForClient(client.GetId()))
+
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = storage.LoadAccess(otherAccess.AccessToken)
+	assert.NoError(t, err)
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+}
+
+func TestPing(t *testing.T) {
+	storage := initTestStorage()
+	assert.NoError(t, storage.Ping())
+
+	unreachable := New(&redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", "127.0.0.1:1")
+		},
+	}, "test123")
+	assert.Error(t, unreachable.Ping())
+}
+
+func TestClonePinged(t *testing.T) {
+	storage := initTestStorage()
+	clone, err := storage.ClonePinged()
 	assert.NoError(t, err)
+	assert.Equal(t, storage, clone)
+
+	unreachable := New(&redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", "127.0.0.1:1")
+		},
+	}, "test123")
+	clone, err = unreachable.ClonePinged()
+	assert.Error(t, err)
+	assert.Nil(t, clone)
+}
+
+func TestNamespaced(t *testing.T) {
+	flushAll()
+
+	base := New(pool, "test123", WithClientCache(10, time.Minute))
+	acme := base.Namespaced("acme")
+	globex := base.Namespaced("globex")
+
+	acmeClient := newClient()
+	globexClient := newClient()
+	globexClient.RedirectUri = "http://globex.example/"
+	assert.NoError(t, acme.CreateClient(acmeClient))
+	assert.NoError(t, globex.CreateClient(globexClient))
+	assert.NoError(t, acme.SaveAccess(newAccessData(newAuthorizeData(acmeClient))))
+
+	client, err := acme.GetClient(acmeClient.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, acmeClient, client)
+	client, err = globex.GetClient(globexClient.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, globexClient, client)
+
+	_, err = acme.LoadAccess("8888")
+	assert.NoError(t, err)
+	_, err = globex.LoadAccess("8888")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = base.GetClient(acmeClient.GetId())
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	conn := pool.Get()
+	defer conn.Close()
+	exists, err := redis.Bool(conn.Do("EXISTS", "test123:acme:client:clientID"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.Panics(t, func() { base.Namespaced("") })
+}
+
+func TestNewChecked(t *testing.T) {
+	storage, err := NewChecked(pool, "test123")
+	assert.NoError(t, err)
+	assert.NotNil(t, storage)
+
+	_, err = NewChecked(nil, "test123")
+	assert.Error(t, err)
+	_, err = NewChecked(pool, "")
+	assert.Error(t, err)
+	_, err = NewChecked(pool, " \t")
+	assert.Error(t, err)
+
+	assert.Panics(t, func() { New(nil, "test123") })
+}
+
+func TestWithKeySeparator(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithKeySeparator("/"))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	conn := pool.Get()
+	defer conn.Close()
+	exists, err := redis.Bool(conn.Do("EXISTS", "test123/client/"+client.GetId()))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	clients, _, err := storage.ListClients(0, 100)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+
+	assert.Panics(t, func() { WithKeySeparator("") })
+}
+
+func TestWithClusterHashTag(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithClusterHashTag())
+	assert.Equal(t, "{test123}:access_token:8888", storage.makeKey("access_token", "8888"))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, isEqualAccessData(loadData, accessData))
+
+	clients, _, err := storage.ListClients(0, 100)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+}
+
+func TestWithIDGenerator(t *testing.T) {
+	flushAll()
+
+	var n int
+	storage := New(pool, "test123", WithIDGenerator(func() string {
+		n++
+		return fmt.Sprintf("id-%d", n)
+	}))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	accessID, err := redis.String(conn.Do("GET", storage.makeKey("access_token", accessData.AccessToken)))
+	assert.NoError(t, err)
+	assert.Equal(t, "id-1", accessID)
+
+	exists, err := redis.Bool(conn.Do("EXISTS", "test123:access:id-1"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.Panics(t, func() { WithIDGenerator(nil) })
+}
+
+func TestLoadAccessExpired(t *testing.T) {
+	flushAll()
+
+	now := time.Now()
+	storage := New(pool, "test123", WithClock(func() time.Time { return now }))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.CreatedAt = now
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+
+	// The keys are still stored, as if their TTL had been lost.
+	now = now.Add(time.Duration(accessData.ExpiresIn+1) * time.Second)
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.Nil(t, loadData)
+	assert.True(t, errors.Is(err, ErrTokenExpired))
+
+	_, err = storage.LoadAccessInfo(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrTokenExpired))
+
+	// Refresh tokens outlive the access token they were issued with.
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+
+	assert.Panics(t, func() { WithClock(nil) })
+}
+
+func TestWithSlidingRefresh(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithSlidingRefresh(24*time.Hour))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	ttlOf := func(key string) int {
+		ttl, err := redis.Int(conn.Do("TTL", key))
+		assert.NoError(t, err)
+		return ttl
+	}
+	refreshKey := storage.makeKey("refresh_token", accessData.RefreshToken)
+
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, ttlOf(refreshKey) <= int(accessData.ExpiresIn))
+
+	loadData, err := storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(24*60*60), loadData.ExpiresIn)
+	assert.InDelta(t, 24*60*60, ttlOf(refreshKey), 5)
+	assert.InDelta(t, 24*60*60, ttlOf(storage.makeKey("access_token", accessData.AccessToken)), 5)
+}
+
+func BenchmarkCreateClient(b *testing.B) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := storage.CreateClient(client); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSaveAccess(b *testing.B) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	if err := storage.CreateClient(client); err != nil {
+		b.Fatal(err)
+	}
+	accessData := newAccessData(newAuthorizeData(client))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := storage.SaveAccess(accessData); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadAccess(b *testing.B) {
+	benchmarkLoadAccess(b, initTestStorage())
+}
+
+// BenchmarkLoadAccessDirect loads the access data with a single GET, against
+// the pointer GET followed by the access GET of BenchmarkLoadAccess.
+func BenchmarkLoadAccessDirect(b *testing.B) {
+	benchmarkLoadAccess(b, New(pool, "test123", WithDirectAccessKeys()))
+}
+
+func benchmarkLoadAccess(b *testing.B, storage *Storage) {
+	flushAll()
+
+	var (
+		gets     int
+		commands []string
+	)
+	storage.connFunc = func() redis.Conn {
+		gets++
+		return recordingConn{pool.Get(), &commands}
+	}
+
+	client := newClient()
+	if err := storage.CreateClient(client); err != nil {
+		b.Fatal(err)
+	}
+	accessData := newAccessData(newAuthorizeData(client))
+	if err := storage.SaveAccess(accessData); err != nil {
+		b.Fatal(err)
+	}
+
+	gets = 0
+	commands = nil
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := storage.LoadAccess(accessData.AccessToken); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(gets)/float64(b.N), "gets/op")
+	b.ReportMetric(float64(len(commands))/float64(b.N), "cmds/op")
+}
+
+func TestLoadAccessSingleConn(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	var gets int
+	storage.connFunc = func() redis.Conn {
+		gets++
+		return pool.Get()
+	}
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	authData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authData))
+	accessData := newAccessData(authData)
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	gets = 0
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	_, err = storage.LoadAuthorize(authData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, gets)
+}
+
+func TestLoadClientTokens(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	var tokens []string
+	for i := 0; i < 3; i++ {
+		accessData := newAccessData(newAuthorizeData(client))
+		accessData.AccessToken = fmt.Sprintf("access%d", i)
+		accessData.RefreshToken = fmt.Sprintf("refresh%d", i)
+		assert.NoError(t, storage.SaveAccess(accessData))
+		tokens = append(tokens, accessData.AccessToken)
+	}
+
+	// Simulate the expiry of one access gob.
+	conn := pool.Get()
+	defer conn.Close()
+	expiredID, err := redis.String(conn.Do("GET", storage.makeKey("access_token", "access0")))
+	assert.NoError(t, err)
+	_, err = conn.Do("DEL", storage.makeKey("access", expiredID))
+	assert.NoError(t, err)
+
+	accesses, err := storage.LoadClientTokens(client.GetId())
+	assert.NoError(t, err)
+	var loaded []string
+	for _, access := range accesses {
+		loaded = append(loaded, access.AccessToken)
+		assert.Equal(t, client, access.Client)
+	}
+	assert.ElementsMatch(t, tokens[1:], loaded)
+
+	isMember, err := redis.Bool(conn.Do("SISMEMBER", storage.makeKey("client_tokens", client.GetId()), expiredID))
+	assert.NoError(t, err)
+	assert.False(t, isMember)
+}
+
+func TestClientTokenCount(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	count, err := storage.ClientTokenCount(client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	var accessIDs []string
+	for i := 0; i < 2; i++ {
+		accessData := newAccessData(newAuthorizeData(client))
+		accessData.AccessToken = fmt.Sprintf("access%d", i)
+		accessData.RefreshToken = fmt.Sprintf("refresh%d", i)
+		assert.NoError(t, storage.SaveAccess(accessData))
+		_, accessID, err := storage.LoadAccessWithID(accessData.AccessToken)
+		assert.NoError(t, err)
+		accessIDs = append(accessIDs, accessID)
+	}
+	count, err = storage.ClientTokenCount(client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Access data that expired is counted until the index is pruned.
+	conn := pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("DEL", "test123:access:"+accessIDs[0])
+	assert.NoError(t, err)
+	count, err = storage.ClientTokenCount(client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	_, err = storage.LoadClientTokens(client.Id)
+	assert.NoError(t, err)
+	count, err = storage.ClientTokenCount(client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+// recordingConn records the commands sent over it.
+type recordingConn struct {
+	redis.Conn
+	commands *[]string
+}
+
+func (c recordingConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName != "" {
+		*c.commands = append(*c.commands, commandName)
+	}
+	return c.Conn.Do(commandName, args...)
+}
+
+func (c recordingConn) Send(commandName string, args ...interface{}) error {
+	*c.commands = append(*c.commands, commandName)
+	return c.Conn.Send(commandName, args...)
+}
+
+func TestWithReadPool(t *testing.T) {
+	flushAll()
+
+	var reads []string
+	readPool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			conn, err := pool.Dial()
+			return recordingConn{conn, &reads}, err
+		},
+	}
+	storage := New(pool, "test123", WithReadPool(readPool))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, storage.RemoveAuthorize("code"))
+	assert.Empty(t, reads)
+
+	_, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	exists, err := storage.ClientExists(client.GetId())
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.NotEmpty(t, reads)
+	for _, command := range reads {
+		assert.Contains(t, []string{"GET", "MGET", "TTL", "EXISTS"}, command)
+	}
+}
+
+func TestGetAccessByID(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithIDGenerator(func() string { return "id-1" }))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadData, err := storage.GetAccessByID("id-1")
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loadData.AccessToken)
+	assert.Equal(t, client, loadData.Client)
+
+	_, err = storage.GetAccessByID("id-2")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestLoadAccessRefreshesAuthorizeClient(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	updated := newClient()
+	updated.Secret = "rotated"
+	assert.NoError(t, storage.UpdateClient(updated))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated", loadData.AuthorizeData.Client.GetSecret())
+
+	// Authorize data stored without its client gets the access client.
+	accessData = newAccessData(&osin.AuthorizeData{Code: "code"})
+	accessData.Client = client
+	accessData.AccessToken = "noAuthClient"
+	accessData.RefreshToken = ""
+	assert.NoError(t, storage.SaveAccess(accessData))
+	loadData, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated", loadData.AuthorizeData.Client.GetSecret())
+
+	// A deleted authorize client fails the load with its ID.
+	other := &osin.DefaultClient{Id: "otherClient"}
+	accessData = newAccessData(&osin.AuthorizeData{Client: other, Code: "code"})
+	accessData.Client = client
+	accessData.AccessToken = "otherAuthClient"
+	accessData.RefreshToken = ""
+	assert.NoError(t, storage.SaveAccess(accessData))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Contains(t, err.Error(), `"otherClient"`)
+}
+
+func TestLoadAccessWithID(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithIDGenerator(func() string { return "id-1" }))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadData, accessID, err := storage.LoadAccessWithID(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "id-1", accessID)
+	assert.Equal(t, accessData.AccessToken, loadData.AccessToken)
+
+	info, err := storage.LoadAccessInfo(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "id-1", info.AccessID)
+
+	_, accessID, err = storage.LoadAccessWithID("nonExistentToken")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Empty(t, accessID)
+}
+
+func TestSaveAccessWithoutRefreshToken(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123")
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	// client_credentials grants carry no authorize data or refresh token.
+	first := &osin.AccessData{Client: client, AccessToken: "first", ExpiresIn: 3600, CreatedAt: time.Now()}
+	second := &osin.AccessData{Client: client, AccessToken: "second", ExpiresIn: 3600, CreatedAt: time.Now()}
+	assert.NoError(t, storage.SaveAccess(first))
+	assert.NoError(t, storage.SaveAccess(second))
+
+	conn := pool.Get()
+	defer conn.Close()
+	exists, err := redis.Bool(conn.Do("EXISTS", "test123:refresh_token:"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.NoError(t, storage.RemoveAccess("second"))
+	loadData, err := storage.LoadAccess("first")
+	assert.NoError(t, err)
+	assert.Equal(t, "first", loadData.AccessToken)
+
+	_, err = storage.LoadRefresh("")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestSaveAccessIDCollision(t *testing.T) {
+	flushAll()
+
+	ids := []string{"id-1", "id-1", "id-2"}
+	storage := New(pool, "test123", WithIDGenerator(func() string {
+		id := ids[0]
+		if len(ids) > 1 {
+			ids = ids[1:]
+		}
+		return id
+	}))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	first := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(first))
+	second := newAccessData(newAuthorizeData(client))
+	second.AccessToken = "9999"
+	second.RefreshToken = "r9999"
+	assert.NoError(t, storage.SaveAccess(second))
+
+	loadData, err := storage.GetAccessByID("id-1")
+	assert.NoError(t, err)
+	assert.Equal(t, first.AccessToken, loadData.AccessToken)
+	loadData, err = storage.GetAccessByID("id-2")
+	assert.NoError(t, err)
+	assert.Equal(t, second.AccessToken, loadData.AccessToken)
+
+	// The generator is stuck on id-2 now.
+	third := newAccessData(newAuthorizeData(client))
+	third.AccessToken = "7777"
+	assert.Error(t, storage.SaveAccess(third))
+	_, err = storage.LoadAccess(third.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestErrorsIsPoolExhausted(t *testing.T) {
+	exhausted := &redis.Pool{Dial: pool.Dial, MaxActive: 1}
+	defer exhausted.Close()
+	storage := New(exhausted, "test123")
+
+	conn := exhausted.Get()
+	defer conn.Close()
+
+	_, err := storage.GetClient("1")
+	assert.True(t, errors.Is(err, redis.ErrPoolExhausted))
+	err = storage.RemoveAccess("8888")
+	assert.True(t, errors.Is(err, redis.ErrPoolExhausted))
+}
+
+func TestWithClientTTLFunc(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithClientTTLFunc(func(client osin.Client) time.Duration {
+		if client.GetId() == "short" {
+			return 10 * time.Minute
+		}
+		return 0
+	}))
+
+	short := &osin.DefaultClient{Id: "short", Secret: "secret", RedirectUri: "http://localhost/"}
+	long := &osin.DefaultClient{Id: "long", Secret: "secret", RedirectUri: "http://localhost/"}
+	assert.NoError(t, storage.CreateClient(short))
+	assert.NoError(t, storage.CreateClient(long))
+
+	shortData := &osin.AccessData{Client: short, AccessToken: "short", ExpiresIn: 3600, CreatedAt: time.Now()}
+	longData := &osin.AccessData{Client: long, AccessToken: "long", ExpiresIn: 3600, CreatedAt: time.Now()}
+	assert.NoError(t, storage.SaveAccess(shortData))
+	assert.NoError(t, storage.SaveAccess(longData))
+	assert.Equal(t, int32(600), shortData.ExpiresIn)
+	assert.Equal(t, int32(3600), longData.ExpiresIn)
+
+	conn := pool.Get()
+	defer conn.Close()
+	ttl, err := redis.Int(conn.Do("TTL", "test123:access_token:short"))
+	assert.NoError(t, err)
+	assert.InDelta(t, 600, ttl, 2)
+	ttl, err = redis.Int(conn.Do("TTL", "test123:access_token:long"))
+	assert.NoError(t, err)
+	assert.InDelta(t, 3600, ttl, 2)
+
+	loadData, err := storage.LoadAccess("short")
+	assert.NoError(t, err)
+	assert.InDelta(t, 600, loadData.ExpiresIn, 2)
+}
+
+func TestSaveAccessWithoutExpiryUsesMSET(t *testing.T) {
+	flushAll()
+
+	var commands []string
+	storage := initTestStorage()
+	storage.connFunc = func() redis.Conn {
+		return recordingConn{pool.Get(), &commands}
+	}
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.ExpiresIn = 0
+	commands = nil
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.Contains(t, commands, "MSET")
+	assert.NotContains(t, commands, "SET")
+	assert.NotContains(t, commands, "SETEX")
+
+	loadData, err := storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loadData.AccessToken)
+
+	conn := pool.Get()
+	defer conn.Close()
+	ttl, err := redis.Int(conn.Do("TTL", "test123:access_token:8888"))
+	assert.NoError(t, err)
+	assert.Equal(t, -1, ttl)
+
+	accessData = newAccessData(newAuthorizeData(client))
+	commands = nil
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NotContains(t, commands, "MSET")
+	assert.Contains(t, commands, "SETEX")
 }
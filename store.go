@@ -0,0 +1,87 @@
+package osinredis
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+)
+
+// Store is the method set of *Storage, so that code built on this package
+// can depend on an interface and replace the Storage with a mock in tests.
+// WithContext and Namespaced return the concrete *Storage and are left out;
+// obtain the Storage to pass on before converting it to a Store.
+type Store interface {
+	osin.Storage
+
+	// Clients.
+	CreateClient(client osin.Client) error
+	CreateClients(clients []osin.Client) error
+	UpdateClient(client osin.Client) error
+	UpdateClientCAS(client osin.Client, expectedVersion int64) error
+	DeleteClient(client osin.Client) error
+	PurgeClient(id string) (tokensRemoved int, err error)
+	ClientExists(id string) (bool, error)
+	ValidateClientSecret(id, secret string) (bool, error)
+	GetClientCreatedAt(id string) (time.Time, error)
+	GetClientVersion(id string) (int64, error)
+	FindClientsByRedirectURI(uri string) ([]osin.Client, error)
+	ListClients(cursor uint64, count int) ([]osin.Client, uint64, error)
+	IterateClients(count int) *ClientIterator
+	ForEachClient(fn func(osin.Client) error) error
+
+	// Authorize data.
+	SaveAuthorizeNX(data *osin.AuthorizeData) error
+	LoadAndRemoveAuthorize(code string) (*osin.AuthorizeData, error)
+	TouchAuthorize(code string, ttl time.Duration) error
+	AuthorizeExists(code string) (bool, error)
+	AuthorizeTTL(code string) (time.Duration, error)
+	GetAuthorizeChallenge(code string) (challenge, method string, err error)
+	ListAuthorizeForUser(userID string) ([]*osin.AuthorizeData, error)
+
+	// Access data.
+	LoadAccessWithID(token string) (_ *osin.AccessData, accessID string, err error)
+	LoadAccessAndExtend(token string, ttl time.Duration) (*osin.AccessData, error)
+	LoadAccessInfo(token string) (*AccessInfo, error)
+	GetAccessByID(accessID string) (*osin.AccessData, error)
+	GetAccessCreatedAt(token string) (time.Time, error)
+	LookupToken(token string) (*osin.AccessData, TokenKind, error)
+	DescribeToken(token string) (map[string]string, error)
+	TokenTTL(accessToken string) (time.Duration, error)
+	ExtendAccess(token string, ttl time.Duration) error
+	RotateAccess(old, next *osin.AccessData) error
+	RotateRefresh(oldRefresh string, next *osin.AccessData) (*osin.AccessData, error)
+	TokensExpiringWithin(d time.Duration) ([]string, error)
+	SetTokenMeta(token string, meta map[string]string) error
+	GetTokenMeta(token string) (map[string]string, error)
+
+	// Token indexes.
+	LoadClientTokens(clientID string) ([]*osin.AccessData, error)
+	ClientTokenCount(clientID string) (int, error)
+	LoadAccessByUser(userID string) ([]*osin.AccessData, error)
+	LoadByTag(tag string) ([]*osin.AccessData, error)
+	ListAccessCreatedBetween(start, end time.Time) ([]*osin.AccessData, error)
+	RemoveAllForClient(clientID string) error
+	RemoveAllForUser(userID string) error
+	RemoveByTag(tag string) error
+	RevokeAllTokens() (count int, err error)
+
+	// Maintenance.
+	ClonePinged() (osin.Storage, error)
+	Ping() error
+	PoolStats() redis.PoolStats
+	Stats() (*Stats, error)
+	GarbageCollect() (removed int, err error)
+	PruneIndexes() (removed int, err error)
+	RebuildCreatedIndex() error
+	Backfill() (rewritten int, err error)
+	Verify() (VerifyReport, error)
+	StartIndexReaper(ctx context.Context) error
+	Flush() error
+	Export(w io.Writer) error
+	Import(r io.Reader) error
+}
+
+var _ Store = (*Storage)(nil)
@@ -0,0 +1,116 @@
+package osinredis
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConcurrentAccessLifecycle saves, loads and removes access data for the
+// same few tokens from many goroutines at once. Run it with -race. The only
+// error any operation may return is ErrNotFound, and whatever a token loads
+// must carry that token.
+func TestConcurrentAccessLifecycle(t *testing.T) {
+	const (
+		workers    = 16
+		iterations = 50
+		tokens     = 3
+	)
+
+	for name, storage := range map[string]*Storage{
+		"pointer": New(pool, "test123", WithStrictRemove()),
+		"direct":  New(pool, "test123", WithDirectAccessKeys(), WithStrictRemove()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			flushAll()
+
+			client := newClient()
+			assert.NoError(t, storage.CreateClient(client))
+
+			var (
+				wg   sync.WaitGroup
+				mu   sync.Mutex
+				errs []error
+			)
+			fail := func(op string, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, fmt.Errorf("%s: %w", op, err))
+			}
+
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < iterations; i++ {
+						n := (w + i) % tokens
+						accessToken := fmt.Sprintf("stressAccess%d", n)
+						refreshToken := fmt.Sprintf("stressRefresh%d", n)
+
+						switch (w * i) % 5 {
+						case 0:
+							accessData := newAccessData(newAuthorizeData(client))
+							accessData.AccessToken = accessToken
+							accessData.RefreshToken = refreshToken
+							if err := storage.SaveAccess(accessData); err != nil {
+								fail("SaveAccess", err)
+							}
+						case 1:
+							access, err := storage.LoadAccess(accessToken)
+							if err == nil && access.AccessToken != accessToken {
+								err = errors.New("loaded access data of " + access.AccessToken)
+							}
+							if err != nil && !errors.Is(err, ErrNotFound) {
+								fail("LoadAccess", err)
+							}
+						case 2:
+							access, err := storage.LoadRefresh(refreshToken)
+							if err == nil && access.RefreshToken != refreshToken {
+								err = errors.New("loaded access data of " + access.RefreshToken)
+							}
+							if err != nil && !errors.Is(err, ErrNotFound) {
+								fail("LoadRefresh", err)
+							}
+						case 3:
+							if err := storage.RemoveAccess(accessToken); err != nil && !errors.Is(err, ErrNotFound) {
+								fail("RemoveAccess", err)
+							}
+						case 4:
+							if err := storage.RemoveRefresh(refreshToken); err != nil && !errors.Is(err, ErrNotFound) {
+								fail("RemoveRefresh", err)
+							}
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			for _, err := range errs {
+				t.Error(err)
+			}
+
+			// Removing every token must leave no token pointers behind.
+			for n := 0; n < tokens; n++ {
+				for {
+					if err := storage.RemoveAccess(fmt.Sprintf("stressAccess%d", n)); err != nil {
+						break
+					}
+				}
+				for {
+					if err := storage.RemoveRefresh(fmt.Sprintf("stressRefresh%d", n)); err != nil {
+						break
+					}
+				}
+			}
+			conn := pool.Get()
+			defer conn.Close()
+			keys, err := redis.Strings(conn.Do("KEYS", "test123:*_token:stress*"))
+			assert.NoError(t, err)
+			assert.Empty(t, keys)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package osinredis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// WithTokenKeyHashing stores the hex encoded SHA-256 digest of access and
+// refresh tokens in their pointer keys, and of authorization codes in the keys
+// of their authorize data, instead of the tokens and codes themselves, so
+// that read access to Redis does not expose live bearer tokens or in-flight
+// codes. Lookups hash the incoming token or code the same way. The access and
+// authorize data themselves still hold the tokens and the code.
+//
+// Tokens and codes stored before hashing was enabled, or after it was
+// disabled, can no longer be found and have to be re-issued.
+func WithTokenKeyHashing() Option {
+	return func(s *Storage) {
+		s.hashTokenKeys = true
+	}
+}
+
+// WithTokenKeyFunc derives the part of a key named by a token or code from
+// what keyFunc returns for it rather than from the token itself, for example
+// the jti claim of a JWT access token, so that long tokens do not bloat the
+// keys. Lookups apply keyFunc to the incoming token the same way, so it must
+// return the same result for the same token and different results for
+// different tokens. The access and authorize data still hold the tokens.
+// With WithTokenKeyHashing the result of keyFunc is hashed, and access keys
+// stored with WithDirectAccessKeys are still derived from the whole token.
+// WithTokenKeyFunc panics if keyFunc is nil.
+func WithTokenKeyFunc(keyFunc func(token string) string) Option {
+	if keyFunc == nil {
+		panic("osinredis: nil token key func")
+	}
+	return func(s *Storage) {
+		s.tokenKeyFunc = keyFunc
+	}
+}
+
+// tokenKey builds the key of a record named by a secret: an access_token or
+// refresh_token pointer, or the authorize data of a code and its revocation
+// marker.
+func (s *Storage) tokenKey(namespace, token string) string {
+	if s.tokenKeyFunc != nil {
+		token = s.tokenKeyFunc(token)
+	}
+	if s.hashTokenKeys {
+		sum := sha256.Sum256([]byte(token))
+		token = hex.EncodeToString(sum[:])
+	}
+	return s.makeKey(namespace, token)
+}
+
+// tokenLogKey returns the key tokenKey builds, for log entries and errors,
+// see redactTokenKey.
+func (s *Storage) tokenLogKey(namespace, token string) string {
+	return s.redactTokenKey(namespace, s.tokenKey(namespace, token))
+}
+
+// redactTokenKey returns key, built by tokenKey for namespace, for log entries
+// and errors: unchanged with WithTokenKeyHashing, and otherwise with the token
+// replaced by a short fingerprint of its SHA-256 digest, so that logs and
+// errors never reveal tokens or codes but entries of the same token still
+// match up.
+func (s *Storage) redactTokenKey(namespace, key string) string {
+	if s.hashTokenKeys {
+		return key
+	}
+	sum := sha256.Sum256([]byte(strings.TrimPrefix(key, s.makeKey(namespace, ""))))
+	return s.makeKey(namespace, "sha256-"+hex.EncodeToString(sum[:6]))
+}
@@ -0,0 +1,134 @@
+package osinredis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTokenKeyHashing(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithTokenKeyHashing())
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	exists, err := redis.Bool(conn.Do("EXISTS", "test123:access_token:"+accessData.AccessToken))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	sum := sha256.Sum256([]byte(accessData.AccessToken))
+	exists, err = redis.Bool(conn.Do("EXISTS", "test123:access_token:"+hex.EncodeToString(sum[:])))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loadData.AccessToken)
+
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+
+	// Without hashing the token keys are not found.
+	_, err = New(pool, "test123").LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.NoError(t, storage.RemoveRefresh(accessData.RefreshToken))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:*token*"))
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestWithTokenKeyHashingAuthorize(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithTokenKeyHashing())
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	exists, err := redis.Bool(conn.Do("EXISTS", "test123:auth:"+authorizeData.Code))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	sum := sha256.Sum256([]byte(authorizeData.Code))
+	exists, err = redis.Bool(conn.Do("EXISTS", "test123:auth:"+hex.EncodeToString(sum[:])))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	loadData, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, authorizeData.Code, loadData.Code)
+
+	assert.NoError(t, storage.RemoveAuthorize(authorizeData.Code))
+	_, err = storage.LoadAuthorize(authorizeData.Code)
+	assert.True(t, errors.Is(err, ErrCodeRevoked))
+
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:auth*:"+authorizeData.Code))
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestWithTokenKeyFunc(t *testing.T) {
+	flushAll()
+
+	// Key JWT-like tokens on their last segment.
+	jti := func(token string) string { return token[strings.LastIndex(token, ".")+1:] }
+	storage := New(pool, "test123", WithTokenKeyFunc(jti))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.AccessToken = "header.claims.access1"
+	accessData.RefreshToken = "header.claims.refresh1"
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	keys, err := redis.Strings(conn.Do("KEYS", "test123:*_token:*"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"test123:access_token:access1", "test123:refresh_token:refresh1"}, keys)
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loadData.AccessToken)
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.Panics(t, func() { WithTokenKeyFunc(nil) })
+}
+
+func TestRedactTokenKey(t *testing.T) {
+	storage := initTestStorage()
+	key := storage.tokenLogKey("auth", "secret-code")
+	assert.True(t, strings.HasPrefix(key, "test123:auth:sha256-"))
+	assert.NotContains(t, key, "secret-code")
+	assert.Equal(t, key, storage.redactTokenKey("auth", storage.tokenKey("auth", "secret-code")))
+
+	hashing := New(pool, "test123", WithTokenKeyHashing())
+	assert.Equal(t, hashing.tokenKey("auth", "secret-code"), hashing.tokenLogKey("auth", "secret-code"))
+}
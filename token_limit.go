@@ -0,0 +1,70 @@
+package osinredis
+
+import (
+	"sort"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// WithMaxTokensPerClient caps the number of access tokens a client can hold
+// at n. When SaveAccess would exceed the cap, it removes the client's oldest
+// access data, by CreatedAt, in the same transaction. Every save then reads
+// all of the client's access data, so the cap should be small. A value of 0
+// means unlimited.
+func WithMaxTokensPerClient(n int) Option {
+	if n < 0 {
+		panic("osinredis: negative token limit")
+	}
+	return func(s *Storage) {
+		s.maxTokensPerClient = n
+	}
+}
+
+// tokensToEvict watches the token index of the client and returns the access
+// data that must go for one more token to fit under the cap.
+func (s *Storage) tokensToEvict(conn redis.Conn, clientID string) (map[string]*osin.AccessData, error) {
+	indexKey := s.makeKey("client_tokens", clientID)
+	if _, err := conn.Do("WATCH", indexKey); err != nil {
+		return nil, errors.Wrap(err, "failed to watch client token index")
+	}
+
+	accessIDs, err := redis.Strings(conn.Do("SMEMBERS", indexKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read client token index")
+	}
+	if len(accessIDs) < s.maxTokensPerClient {
+		return nil, nil
+	}
+
+	type token struct {
+		id     string
+		access *osin.AccessData
+	}
+	var live []token
+	for _, accessID := range accessIDs {
+		access, err := s.readAccessGob(conn, accessID)
+		if err != nil {
+			return nil, err
+		}
+		if access != nil {
+			live = append(live, token{accessID, access})
+		}
+	}
+
+	excess := len(live) - s.maxTokensPerClient + 1
+	if excess <= 0 {
+		return nil, nil
+	}
+
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].access.CreatedAt.Before(live[j].access.CreatedAt)
+	})
+
+	evict := make(map[string]*osin.AccessData, excess)
+	for _, t := range live[:excess] {
+		evict[t.id] = t.access
+	}
+	return evict, nil
+}
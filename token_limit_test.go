@@ -0,0 +1,42 @@
+package osinredis
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithMaxTokensPerClient(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithMaxTokensPerClient(2))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	createdAt := time.Now()
+	for i := 0; i < 4; i++ {
+		accessData := newAccessData(newAuthorizeData(client))
+		accessData.AccessToken = fmt.Sprintf("access%d", i)
+		accessData.RefreshToken = fmt.Sprintf("refresh%d", i)
+		accessData.CreatedAt = createdAt.Add(time.Duration(i) * time.Second)
+		assert.NoError(t, storage.SaveAccess(accessData))
+	}
+
+	for i, want := range []bool{false, false, true, true} {
+		_, err := storage.LoadAccess(fmt.Sprintf("access%d", i))
+		if want {
+			assert.NoError(t, err)
+		} else {
+			assert.True(t, errors.Is(err, ErrNotFound))
+		}
+	}
+
+	_, err := storage.LoadRefresh("refresh0")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.Panics(t, func() { WithMaxTokensPerClient(-1) })
+}
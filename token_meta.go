@@ -0,0 +1,101 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// setTokenMetaScript replaces the metadata hash KEYS[2] of the access data
+// KEYS[1] with the field value pairs in ARGV, expiring it together with the
+// access data. It returns 0 without writing anything if the access data does
+// not exist.
+var setTokenMetaScript = redis.NewScript(2, `
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -2 then
+	return 0
+end
+redis.call("DEL", KEYS[2])
+if #ARGV > 0 then
+	redis.call("HSET", KEYS[2], unpack(ARGV))
+	if ttl > 0 then
+		redis.call("PEXPIRE", KEYS[2], ttl)
+	end
+end
+return 1
+`)
+
+// SetTokenMeta replaces the metadata of the given access token, such as a
+// device fingerprint or client IP, with meta. The metadata is kept in a hash
+// next to the access data rather than in it, so it can change without
+// re-issuing the token. It expires with the access data, is deleted when the
+// token is revoked and is extended along with the access data by
+// ExtendAccess, LoadAccessAndExtend and WithSlidingRefresh; an empty meta
+// deletes it. It returns ErrNotFound if the token does not exist.
+func (s *Storage) SetTokenMeta(token string, meta map[string]string) (err error) {
+	defer s.observe("SetTokenMeta", time.Now(), &err)
+	s, span := s.startSpan("SetTokenMeta", "EVALSHA", "access_meta")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	accessID, err := s.resolveAccessID(conn, "access_token", token)
+	if err == redis.ErrNil {
+		return s.tokenMiss(conn, "access_token", token)
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to get access ID")
+	}
+
+	set, err := redis.Bool(setTokenMetaScript.Do(conn, redis.Args{}.
+		Add(s.makeKey("access", accessID), s.makeKey("access_meta", accessID)).
+		AddFlat(meta)...))
+	if err != nil {
+		return errors.Wrap(err, "failed to set token metadata")
+	}
+	if !set {
+		return errors.Wrap(ErrNotFound, "access not stored")
+	}
+	return nil
+}
+
+// GetTokenMeta returns the metadata set with SetTokenMeta for the given access
+// token, which is empty if none was set. It returns ErrNotFound if the token
+// does not exist.
+func (s *Storage) GetTokenMeta(token string) (_ map[string]string, err error) {
+	defer s.observe("GetTokenMeta", time.Now(), &err)
+	s, span := s.startSpan("GetTokenMeta", "HGETALL", "access_meta")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	accessID, err := s.resolveAccessID(conn, "access_token", token)
+	if err == redis.ErrNil {
+		return nil, s.tokenMiss(conn, "access_token", token)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get access ID")
+	}
+
+	conn.Send("EXISTS", s.makeKey("access", accessID))
+	conn.Send("HGETALL", s.makeKey("access_meta", accessID))
+	replies, err := flush(conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get token metadata")
+	}
+	if exists, _ := redis.Bool(replies[0], nil); !exists {
+		return nil, errors.Wrap(ErrNotFound, "access not stored")
+	}
+	return redis.StringMap(replies[1], nil)
+}
@@ -0,0 +1,54 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenMeta(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	meta, err := storage.GetTokenMeta(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Empty(t, meta)
+
+	assert.NoError(t, storage.SetTokenMeta(accessData.AccessToken, map[string]string{"ip": "10.0.0.1", "device": "abc"}))
+	assert.NoError(t, storage.SetTokenMeta(accessData.AccessToken, map[string]string{"ip": "10.0.0.2"}))
+	meta, err = storage.GetTokenMeta(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"ip": "10.0.0.2"}, meta)
+
+	keys, err := storage.DescribeToken(accessData.AccessToken)
+	assert.NoError(t, err)
+	metaKey := "test123:access_meta:" + keys["access_id"]
+	conn := pool.Get()
+	defer conn.Close()
+	ttl, err := redis.Int(conn.Do("TTL", metaKey))
+	assert.NoError(t, err)
+	assert.InDelta(t, 3600, ttl, 1)
+
+	assert.NoError(t, storage.ExtendAccess(accessData.AccessToken, 2*time.Hour))
+	ttl, err = redis.Int(conn.Do("TTL", metaKey))
+	assert.NoError(t, err)
+	assert.InDelta(t, 7200, ttl, 1)
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	exists, err := redis.Bool(conn.Do("EXISTS", metaKey))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	_, err = storage.GetTokenMeta(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	err = storage.SetTokenMeta(accessData.AccessToken, map[string]string{"ip": "10.0.0.3"})
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
@@ -0,0 +1,46 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/openshift/osin"
+)
+
+// WithTokenTagsFunc indexes access data by the tags tagsFunc returns for it,
+// such as a device session, so that LoadByTag and RemoveByTag can find all
+// access data sharing a tag. tagsFunc must return the same tags for the same
+// access data, since the tags are derived again when it is removed.
+func WithTokenTagsFunc(tagsFunc func(*osin.AccessData) []string) Option {
+	return func(s *Storage) {
+		s.tagsFunc = tagsFunc
+	}
+}
+
+func (s *Storage) tokenTags(access *osin.AccessData) []string {
+	if s.tagsFunc == nil {
+		return nil
+	}
+	return s.tagsFunc(access)
+}
+
+// LoadByTag returns the stored access data tagged with tag by the function
+// passed to WithTokenTagsFunc. Index entries whose access data has already
+// expired are dropped from the index along the way.
+func (s *Storage) LoadByTag(tag string) (_ []*osin.AccessData, err error) {
+	defer s.observe("LoadByTag", time.Now(), &err)
+	s, span := s.startSpan("LoadByTag", "SMEMBERS", "tag")
+	defer span.end(&err)
+
+	return s.loadIndexedAccess(s.makeKey("tag", tag))
+}
+
+// RemoveByTag revokes every access and refresh token tagged with tag by the
+// function passed to WithTokenTagsFunc.
+func (s *Storage) RemoveByTag(tag string) (err error) {
+	defer s.observe("RemoveByTag", time.Now(), &err)
+	s, span := s.startSpan("RemoveByTag", "MULTI", "tag")
+	defer span.end(&err)
+
+	_, err = s.removeIndexedTokens(s.makeKey("tag", tag))
+	return err
+}
@@ -0,0 +1,68 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func sessionTags(access *osin.AccessData) []string {
+	userData, ok := access.UserData.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	session, ok := userData["session"].(string)
+	if !ok {
+		return nil
+	}
+	return []string{session}
+}
+
+func newSessionAccessData(client *osin.DefaultClient, token, session string) *osin.AccessData {
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.AccessToken = token
+	accessData.RefreshToken = "refresh-" + token
+	accessData.UserData = map[string]interface{}{"session": session}
+	return accessData
+}
+
+func TestTokenTags(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithTokenTagsFunc(sessionTags))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	assert.NoError(t, storage.SaveAccess(newSessionAccessData(client, "a", "laptop")))
+	assert.NoError(t, storage.SaveAccess(newSessionAccessData(client, "b", "laptop")))
+	assert.NoError(t, storage.SaveAccess(newSessionAccessData(client, "c", "phone")))
+
+	accesses, err := storage.LoadByTag("laptop")
+	assert.NoError(t, err)
+	var tokens []string
+	for _, access := range accesses {
+		tokens = append(tokens, access.AccessToken)
+	}
+	assert.ElementsMatch(t, []string{"a", "b"}, tokens)
+
+	// Removing a single token drops it from its tag.
+	assert.NoError(t, storage.RemoveAccess("a"))
+	conn := pool.Get()
+	defer conn.Close()
+	members, err := redis.Strings(conn.Do("SMEMBERS", "test123:tag:laptop"))
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+
+	assert.NoError(t, storage.RemoveByTag("laptop"))
+	_, err = storage.LoadAccess("b")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	_, err = storage.LoadAccess("c")
+	assert.NoError(t, err)
+
+	exists, err := redis.Bool(conn.Do("EXISTS", "test123:tag:laptop"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,58 @@
+package osinredis
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracer starts a span named "osinredis.<Method>" for every Storage
+// operation, as a child of the span in the context given to WithContext. Spans
+// carry the Redis command and the key namespace, never token values or
+// authorize codes. Without a tracer no spans are created.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(s *Storage) {
+		s.tracer = tracer
+	}
+}
+
+type span struct {
+	span trace.Span
+}
+
+// startSpan starts the span of operation op and returns a copy of s bound to
+// the span's context, so that nested operations become child spans. It
+// returns s itself when tracing is disabled.
+func (s *Storage) startSpan(op, command, namespace string) (*Storage, span) {
+	if s.tracer == nil {
+		return s, span{}
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation", command),
+	}
+	if namespace != "" {
+		attrs = append(attrs, attribute.String("redis.key_namespace", namespace))
+	}
+
+	ctx, sp := s.tracer.Start(s.context(), "osinredis."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return s.WithContext(ctx), span{sp}
+}
+
+// end ends the span, recording *err unless it is nil or a miss.
+func (sp span) end(err *error) {
+	if sp.span == nil {
+		return
+	}
+	if *err != nil && !errors.Is(*err, ErrNotFound) {
+		sp.span.RecordError(*err)
+		sp.span.SetStatus(codes.Error, (*err).Error())
+	}
+	sp.span.End()
+}
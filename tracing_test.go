@@ -0,0 +1,64 @@
+package osinredis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWithTracer(t *testing.T) {
+	flushAll()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	storage := New(pool, "test123", WithTracer(provider.Tracer("osinredis")))
+
+	client := newClient()
+	assert.Nil(t, storage.CreateClient(client))
+	authorizeData := newAuthorizeData(client)
+	accessData := newAccessData(authorizeData)
+	assert.Nil(t, storage.SaveAccess(accessData))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	_, err := storage.WithContext(ctx).LoadAccess(accessData.AccessToken)
+	assert.Nil(t, err)
+	parent.End()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	loadAccess := spans["osinredis.LoadAccess"]
+	if assert.NotNil(t, loadAccess) {
+		assert.Equal(t, parent.SpanContext().SpanID(), loadAccess.Parent().SpanID())
+		assert.Contains(t, loadAccess.Attributes(), attribute.String("redis.key_namespace", "access_token"))
+		assert.Contains(t, loadAccess.Attributes(), attribute.String("db.operation", "GET"))
+		for _, attr := range loadAccess.Attributes() {
+			assert.NotContains(t, attr.Value.Emit(), accessData.AccessToken)
+		}
+	}
+
+	// The clients are loaded within LoadAccess rather than through GetClient.
+	assert.NotContains(t, spans, "osinredis.GetClient")
+}
+
+func TestWithTracerMissIsNotAnError(t *testing.T) {
+	flushAll()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	storage := New(pool, "test123", WithTracer(provider.Tracer("osinredis")))
+
+	_, err := storage.GetClient("missing")
+	assert.NotNil(t, err)
+
+	if spans := recorder.Ended(); assert.Len(t, spans, 1) {
+		assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	}
+}
@@ -0,0 +1,284 @@
+package osinredis
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// ErrNoExpiry is returned by TokenTTL and AuthorizeTTL for data stored
+// without a TTL.
+var ErrNoExpiry = errors.New("token has no expiry")
+
+// TokenTTL returns the remaining lifetime of the given access token, as
+// reported by Redis. It returns ErrNotFound if the token does not exist and
+// ErrNoExpiry if it never expires. With WithDirectAccessKeys the access token
+// has no key of its own, and the lifetime of its access data is returned,
+// which WithRefreshTTL may extend.
+func (s *Storage) TokenTTL(accessToken string) (_ time.Duration, err error) {
+	defer s.observe("TokenTTL", time.Now(), &err)
+	s, span := s.startSpan("TokenTTL", "PTTL", "access_token")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	accessID, err := s.resolveAccessID(conn, "access_token", accessToken)
+	if err == redis.ErrNil {
+		return 0, errors.Wrap(ErrNotFound, "token not stored")
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get access ID")
+	}
+
+	// The access data may outlive the access token for the sake of its
+	// refresh token, see WithRefreshTTL, while the pointer never does.
+	conn.Send("PTTL", s.makeKey("access", accessID))
+	if !s.directAccessKeys {
+		conn.Send("PTTL", s.tokenKey("access_token", accessToken))
+	}
+	ttls, err := redis.Int64s(flush(conn))
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get access TTL")
+	}
+	if ttls[0] == -2 {
+		return 0, errors.Wrap(ErrNotFound, "access not stored")
+	}
+
+	switch ms := ttls[len(ttls)-1]; ms {
+	case -2:
+		return 0, errors.Wrap(ErrNotFound, "token not stored")
+	case -1:
+		return 0, ErrNoExpiry
+	default:
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+}
+
+// AuthorizeTTL returns the remaining lifetime of the authorize data of code,
+// as reported by Redis, for example to show a countdown while an
+// authorization is pending. Like LoadAuthorize it returns ErrCodeRevoked for a
+// code removed recently and ErrNotFound for a code that does not exist. It
+// returns ErrNoExpiry if the authorize data never expires.
+func (s *Storage) AuthorizeTTL(code string) (_ time.Duration, err error) {
+	defer s.observe("AuthorizeTTL", time.Now(), &err)
+	s, span := s.startSpan("AuthorizeTTL", "PTTL", "auth")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	ms, err := redis.Int64(conn.Do("PTTL", s.tokenKey("auth", code)))
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get auth TTL")
+	}
+
+	switch ms {
+	case -2:
+		return 0, s.authorizeMiss(conn, code)
+	case -1:
+		return 0, ErrNoExpiry
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// ExtendAccess resets the expiry of the access data of the given access token
+// and of the token itself to ttl, rounded down to whole seconds, without
+// rotating it. The access data is rewritten with its ExpiresIn moved along, so
+// that expiry checks measured from CreatedAt accept the extended token too.
+// The refresh token keeps its own expiry, and the access data is kept for at
+// least as long as it can still load it, see WithRefreshTTL. It returns
+// ErrNotFound if the token does not exist; a token revoked concurrently is not
+// brought back.
+func (s *Storage) ExtendAccess(token string, ttl time.Duration) (err error) {
+	defer s.observe("ExtendAccess", time.Now(), &err)
+	s, span := s.startSpan("ExtendAccess", "MULTI", "access_token")
+	defer span.end(&err)
+
+	seconds := int64(ttl / time.Second)
+	if seconds <= 0 {
+		return errors.New("ttl must be at least one second")
+	}
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	accessID, err := s.resolveAccessID(conn, "access_token", token)
+	if err == redis.ErrNil {
+		return errors.Wrap(ErrNotFound, "token not stored")
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to get access ID")
+	}
+
+	_, err = s.extendAccess(conn, accessID, token, seconds)
+	return err
+}
+
+// extendAccess rewrites the access data stored under accessID, whose access
+// token is token, to expire seconds from now, and resets the TTL of the access
+// token pointer to match. The access data keys get the same TTL, or the
+// remaining lifetime of the refresh token if that is longer, so that the
+// refresh token still finds its access data. The rewrite runs in a MULTI/EXEC
+// transaction guarded by WATCH on the access key, so access data removed or
+// replaced concurrently is neither brought back nor overwritten. It returns
+// the access data as rewritten.
+func (s *Storage) extendAccess(conn redis.Conn, accessID, token string, seconds int64) (*osin.AccessData, error) {
+	key := s.makeKey("access", accessID)
+	for attempt := 0; attempt < maxWatchAttempts; attempt++ {
+		if _, err := conn.Do("WATCH", key); err != nil {
+			return nil, errors.Wrap(err, "failed to watch access")
+		}
+
+		accessGob, err := redis.Bytes(conn.Do("GET", key))
+		if err == redis.ErrNil {
+			conn.Do("UNWATCH")
+			return nil, errors.Wrap(ErrNotFound, "access not stored")
+		}
+		if err != nil {
+			conn.Do("UNWATCH")
+			return nil, errors.Wrap(err, "unable to get access gob")
+		}
+
+		var access osin.AccessData
+		if err := s.decodeRecord(key, accessGob, &access); err != nil {
+			conn.Do("UNWATCH")
+			s.logger.Log(LevelWarn, "failed to decode access", "key", key, "err", err)
+			return nil, errors.Wrap(err, "failed to decode access gob")
+		}
+
+		// A refresh token pointer without expiry keeps the access data
+		// forever, as SaveAccess stored it.
+		dataTTL := seconds
+		if access.RefreshToken != "" {
+			refreshTTL, err := redis.Int64(conn.Do("TTL", s.tokenKey("refresh_token", access.RefreshToken)))
+			if err != nil {
+				conn.Do("UNWATCH")
+				return nil, errors.Wrap(err, "unable to get refresh TTL")
+			}
+			if refreshTTL == -1 {
+				dataTTL = 0
+			} else if refreshTTL > dataTTL {
+				dataTTL = refreshTTL
+			}
+		}
+
+		elapsed := int64(s.now().Sub(access.CreatedAt) / time.Second)
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		access.ExpiresIn = int32(elapsed + seconds)
+		payload, err := s.encode(&access)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return nil, errors.Wrap(err, "failed to encode access")
+		}
+
+		conn.Send("MULTI")
+		sendSetWithTTL(conn, key, payload, dataTTL)
+		for _, dataKey := range []string{s.makeKey("access_created_at", accessID), s.makeKey("access_meta", accessID)} {
+			sendExpire(conn, dataKey, dataTTL)
+		}
+		if s.indexReaper {
+			reaperTTL := dataTTL
+			if reaperTTL > 0 {
+				reaperTTL += int64(indexReaperGrace / time.Second)
+			}
+			sendExpire(conn, s.makeKey("access_indexes", accessID), reaperTTL)
+		}
+		if !s.directAccessKeys {
+			conn.Send("EXPIRE", s.tokenKey("access_token", token), seconds)
+		}
+		_, err = exec(conn)
+		if err == errTransactionAborted {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to extend access TTL")
+		}
+		return &access, nil
+	}
+	return nil, errors.New("failed to extend access: access data kept changing")
+}
+
+// sendExpire queues setting the TTL of key to ttl seconds, or removing its
+// expiry if ttl is zero or less, like sendSetWithTTL.
+func sendExpire(conn redis.Conn, key string, ttl int64) {
+	if ttl <= 0 {
+		conn.Send("PERSIST", key)
+		return
+	}
+	conn.Send("EXPIRE", key, ttl)
+}
+
+// TokensExpiringWithin returns the access IDs of the stored access data that
+// expires within d, for example to refresh sessions before they end. Access
+// data without expiry is left out. It iterates the access namespace with SCAN
+// and checks the TTLs of each batch in one pipeline, so it is safe to run
+// against a live server, but access data written while it runs may be
+// missed. The access data expires with its access token, unless WithRefreshTTL
+// makes it outlive the access token, in which case its refresh token's
+// lifetime counts. GetAccessByID loads the access data of a returned ID.
+func (s *Storage) TokensExpiringWithin(d time.Duration) (_ []string, err error) {
+	defer s.observe("TokensExpiringWithin", time.Now(), &err)
+	s, span := s.startSpan("TokensExpiringWithin", "SCAN", "access")
+	defer span.end(&err)
+
+	conn := s.getReadConn()
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	var (
+		cursor   uint64
+		keys     []string
+		expiring []string
+	)
+	keyPrefix := s.makeKey("access", "")
+	limit := int64(d / time.Millisecond)
+	for {
+		cursor, keys, err = scan(conn, cursor, keyPrefix+"*", s.scanHint())
+		if err != nil {
+			return nil, err
+		}
+
+		if len(keys) > 0 {
+			for _, key := range keys {
+				conn.Send("PTTL", key)
+			}
+			ttls, err := redis.Int64s(flush(conn))
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get access TTLs")
+			}
+			for i, ttl := range ttls {
+				// Negative TTLs mark keys without expiry, or removed since
+				// SCAN returned them.
+				if ttl >= 0 && ttl <= limit {
+					expiring = append(expiring, strings.TrimPrefix(keys[i], keyPrefix))
+				}
+			}
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+	return expiring, nil
+}
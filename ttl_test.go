@@ -0,0 +1,206 @@
+package osinredis
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenTTL(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	ttl, err := storage.TokenTTL(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+	assert.True(t, ttl <= time.Duration(accessData.ExpiresIn)*time.Second)
+
+	_, err = storage.TokenTTL("missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestTokenTTLNoExpiry(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.ExpiresIn = 0
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	_, err := storage.TokenTTL(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrNoExpiry))
+}
+
+func TestExtendAccess(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	assert.NoError(t, storage.ExtendAccess(accessData.AccessToken, 24*time.Hour))
+
+	ttl, err := storage.TokenTTL(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Duration(accessData.ExpiresIn)*time.Second)
+
+	conn := pool.Get()
+	defer conn.Close()
+	pointerTTL, err := redis.Int64(conn.Do("TTL", storage.tokenKey("access_token", accessData.AccessToken)))
+	assert.NoError(t, err)
+	assert.True(t, pointerTTL > int64(accessData.ExpiresIn))
+
+	assert.Error(t, storage.ExtendAccess(accessData.AccessToken, time.Millisecond))
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	err = storage.ExtendAccess(accessData.AccessToken, time.Hour)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestExtendAccessPastExpiry(t *testing.T) {
+	flushAll()
+
+	now := time.Now()
+	storage := New(pool, "test123", WithClock(func() time.Time { return now }))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.CreatedAt = now
+	accessData.ExpiresIn = 60
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, storage.ExtendAccess(accessData.AccessToken, time.Hour))
+
+	// The extended token is accepted after its original expiry.
+	now = now.Add(2 * time.Minute)
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loadData.AccessToken)
+
+	now = now.Add(time.Hour)
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.True(t, errors.Is(err, ErrTokenExpired))
+}
+
+func TestAuthorizeTTL(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authData))
+
+	ttl, err := storage.AuthorizeTTL(authData.Code)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+	assert.True(t, ttl <= time.Duration(authData.ExpiresIn)*time.Second)
+
+	conn := pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("PERSIST", "test123:auth:"+authData.Code)
+	assert.NoError(t, err)
+	_, err = storage.AuthorizeTTL(authData.Code)
+	assert.True(t, errors.Is(err, ErrNoExpiry))
+
+	_, err = storage.AuthorizeTTL("missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestTokensExpiringWithin(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithScanCount(2))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	var soonIDs []string
+	for i, expiresIn := range []int32{60, 120, 3600, 0} {
+		accessData := newAccessData(newAuthorizeData(client))
+		accessData.AccessToken = fmt.Sprintf("token%d", i)
+		accessData.RefreshToken = ""
+		accessData.ExpiresIn = expiresIn
+		assert.NoError(t, storage.SaveAccess(accessData))
+		if expiresIn > 0 && expiresIn <= 120 {
+			keys, err := storage.DescribeToken(accessData.AccessToken)
+			assert.NoError(t, err)
+			soonIDs = append(soonIDs, keys["access_id"])
+		}
+	}
+
+	ids, err := storage.TokensExpiringWithin(2 * time.Minute)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, soonIDs, ids)
+
+	ids, err = storage.TokensExpiringWithin(time.Second)
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestExtendAccessKeepsRefreshLifetime(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithRefreshTTL(30*24*time.Hour))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	conn := pool.Get()
+	defer conn.Close()
+	accessID, err := redis.String(conn.Do("GET", storage.tokenKey("access_token", accessData.AccessToken)))
+	assert.NoError(t, err)
+	ttlOf := func(key string) int64 {
+		ttl, err := redis.Int64(conn.Do("TTL", key))
+		assert.NoError(t, err)
+		return ttl
+	}
+
+	assert.NoError(t, storage.ExtendAccess(accessData.AccessToken, 2*time.Hour))
+	assert.InDelta(t, 30*24*3600, ttlOf(storage.makeKey("access", accessID)), 1)
+	assert.InDelta(t, 7200, ttlOf(storage.tokenKey("access_token", accessData.AccessToken)), 1)
+
+	_, err = storage.LoadAccessAndExtend(accessData.AccessToken, time.Minute)
+	assert.NoError(t, err)
+	assert.InDelta(t, 30*24*3600, ttlOf(storage.makeKey("access", accessID)), 1)
+	assert.InDelta(t, 30*24*3600, ttlOf(storage.makeKey("access_created_at", accessID)), 1)
+	assert.InDelta(t, 60, ttlOf(storage.tokenKey("access_token", accessData.AccessToken)), 1)
+
+	loadData, err := storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loadData.AccessToken)
+
+	// Without a refresh token the access data expires with the access token.
+	other := newAccessData(newAuthorizeData(client))
+	other.AccessToken = "other"
+	other.RefreshToken = ""
+	assert.NoError(t, storage.SaveAccess(other))
+	otherID, err := redis.String(conn.Do("GET", storage.tokenKey("access_token", other.AccessToken)))
+	assert.NoError(t, err)
+	_, err = storage.LoadAccessAndExtend(other.AccessToken, time.Minute)
+	assert.NoError(t, err)
+	assert.InDelta(t, 60, ttlOf(storage.makeKey("access", otherID)), 1)
+}
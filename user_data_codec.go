@@ -0,0 +1,144 @@
+package osinredis
+
+import (
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// userDataCodec is the pair of functions set with WithUserDataCodec.
+type userDataCodec struct {
+	encode func(interface{}) ([]byte, error)
+	decode func([]byte) (interface{}, error)
+}
+
+// WithUserDataCodec makes the Storage store the UserData of authorize and
+// access data as the opaque bytes returned by encode, and restore it with
+// decode when loading, so that its type never has to be registered with gob
+// or the configured Serializer. This includes the authorize data and
+// previous access data embedded in access data. The UserData of clients is
+// stored as before.
+//
+// Records written before the codec was set keep their UserData as it was
+// stored, except for string UserData, which cannot be told apart from encoded
+// UserData and is passed to decode. encode is not called for nil UserData.
+// The encoding is stored as a value of an unexported byte slice type, which
+// the built-in Serializers restore byte for byte; a custom Serializer has to
+// do the same. WithUserDataCodec panics if either function is nil.
+func WithUserDataCodec(encode func(interface{}) ([]byte, error), decode func([]byte) (interface{}, error)) Option {
+	if encode == nil || decode == nil {
+		panic("osinredis: nil user data codec")
+	}
+	return func(s *Storage) {
+		s.userDataCodec = &userDataCodec{encode: encode, decode: decode}
+	}
+}
+
+// encodedUserData is UserData as encoded by the codec set with
+// WithUserDataCodec. It is a type of its own rather than a string, which JSON
+// would not keep byte for byte: gob and MsgpackSerializer store it as binary
+// under encodedUserDataType, and JSONSerializer as an object marked with
+// jsonEncodedUserDataKey that it restores before consulting NewUserData.
+type encodedUserData []byte
+
+// encodedUserDataType is the type name encodedUserData is stored under by gob
+// and MsgpackSerializer.
+const encodedUserDataType = "osinredis.encodedUserData"
+
+// detachUserData returns v with the UserData of the records it holds
+// replaced by its encoding, as an encodedUserData. v itself is not modified.
+func (c *userDataCodec) detachUserData(v interface{}) (interface{}, error) {
+	switch data := v.(type) {
+	case *osin.AuthorizeData:
+		return c.detachAuthorize(data)
+	case *osin.AccessData:
+		return c.detachAccess(data)
+	}
+	return v, nil
+}
+
+func (c *userDataCodec) detachAuthorize(auth *osin.AuthorizeData) (*osin.AuthorizeData, error) {
+	if auth == nil || auth.UserData == nil {
+		return auth, nil
+	}
+	encoded, err := c.encode(auth.UserData)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode user data")
+	}
+	detached := *auth
+	detached.UserData = encodedUserData(encoded)
+	return &detached, nil
+}
+
+func (c *userDataCodec) detachAccess(access *osin.AccessData) (*osin.AccessData, error) {
+	if access == nil {
+		return nil, nil
+	}
+	detached := *access
+	if access.UserData != nil {
+		encoded, err := c.encode(access.UserData)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to encode user data")
+		}
+		detached.UserData = encodedUserData(encoded)
+	}
+
+	var err error
+	if detached.AuthorizeData, err = c.detachAuthorize(access.AuthorizeData); err != nil {
+		return nil, err
+	}
+	if detached.AccessData, err = c.detachAccess(access.AccessData); err != nil {
+		return nil, err
+	}
+	return &detached, nil
+}
+
+// attachUserData decodes in place the UserData detachUserData encoded in the
+// records v points to.
+func (c *userDataCodec) attachUserData(v interface{}) error {
+	switch data := v.(type) {
+	case *osin.AuthorizeData:
+		return c.attachAuthorize(data)
+	case *osin.AccessData:
+		return c.attachAccess(data)
+	}
+	return nil
+}
+
+func (c *userDataCodec) attachAuthorize(auth *osin.AuthorizeData) error {
+	if auth == nil {
+		return nil
+	}
+	return c.attach(&auth.UserData)
+}
+
+func (c *userDataCodec) attachAccess(access *osin.AccessData) error {
+	if access == nil {
+		return nil
+	}
+	if err := c.attach(&access.UserData); err != nil {
+		return err
+	}
+	if err := c.attachAuthorize(access.AuthorizeData); err != nil {
+		return err
+	}
+	return c.attachAccess(access.AccessData)
+}
+
+func (c *userDataCodec) attach(userData *interface{}) error {
+	var encoded []byte
+	switch v := (*userData).(type) {
+	case encodedUserData:
+		encoded = v
+	case string:
+		// Encoded UserData was stored as a string before encodedUserData.
+		encoded = []byte(v)
+	default:
+		return nil
+	}
+	decoded, err := c.decode(encoded)
+	if err != nil {
+		return errors.Wrap(err, "unable to decode user data")
+	}
+	*userData = decoded
+	return nil
+}
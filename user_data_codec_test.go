@@ -0,0 +1,88 @@
+package osinredis
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unregisteredUserData is never registered with gob.
+type unregisteredUserData struct {
+	Subject string
+}
+
+func TestWithUserDataCodec(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithUserDataCodec(
+		func(v interface{}) ([]byte, error) { return json.Marshal(v) },
+		func(b []byte) (interface{}, error) {
+			var userData unregisteredUserData
+			err := json.Unmarshal(b, &userData)
+			return userData, err
+		},
+	))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authData := newAuthorizeData(client)
+	authData.UserData = unregisteredUserData{Subject: "auth"}
+	assert.NoError(t, storage.SaveAuthorize(authData))
+	assert.Equal(t, unregisteredUserData{Subject: "auth"}, authData.UserData)
+
+	loadAuth, err := storage.LoadAuthorize(authData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, unregisteredUserData{Subject: "auth"}, loadAuth.UserData)
+
+	accessData := newAccessData(authData)
+	accessData.UserData = unregisteredUserData{Subject: "access"}
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadAccess, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, unregisteredUserData{Subject: "access"}, loadAccess.UserData)
+	assert.Equal(t, unregisteredUserData{Subject: "auth"}, loadAccess.AuthorizeData.UserData)
+
+	// Without the codec the type cannot be stored.
+	plain := initTestStorage()
+	assert.Error(t, plain.SaveAccess(accessData))
+
+	assert.Panics(t, func() { WithUserDataCodec(nil, nil) })
+}
+
+func TestWithUserDataCodecBinary(t *testing.T) {
+	// Not valid UTF-8, which a JSON string would not keep.
+	binary := []byte{0xff, 0x00, 0x81, 0x78}
+
+	for _, serializer := range []Serializer{
+		GobSerializer{},
+		MsgpackSerializer{},
+		JSONSerializer{NewUserData: func() interface{} { return &unregisteredUserData{} }},
+	} {
+		flushAll()
+
+		storage := New(pool, "test123", WithSerializer(serializer), WithUserDataCodec(
+			func(interface{}) ([]byte, error) { return binary, nil },
+			func(b []byte) (interface{}, error) {
+				assert.Equal(t, binary, b)
+				return unregisteredUserData{Subject: "decoded"}, nil
+			},
+		))
+
+		client := newClient()
+		assert.NoError(t, storage.CreateClient(client))
+
+		authData := newAuthorizeData(client)
+		authData.UserData = unregisteredUserData{Subject: "auth"}
+		accessData := newAccessData(authData)
+		accessData.UserData = unregisteredUserData{Subject: "access"}
+		assert.NoError(t, storage.SaveAccess(accessData))
+
+		loadAccess, err := storage.LoadAccess(accessData.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, unregisteredUserData{Subject: "decoded"}, loadAccess.UserData)
+		assert.Equal(t, unregisteredUserData{Subject: "decoded"}, loadAccess.AuthorizeData.UserData)
+	}
+}
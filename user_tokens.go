@@ -0,0 +1,158 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/pkg/errors"
+)
+
+// WithUserIDFunc indexes access data by end-user so that LoadAccessByUser and
+// RemoveAllForUser can find it, and authorize data so that
+// ListAuthorizeForUser can. userIDFunc derives the user ID from the access
+// data, typically from its UserData; access data for which it returns false is
+// not indexed. Authorize data is passed to it wrapped in access data, see
+// ListAuthorizeForUser.
+func WithUserIDFunc(userIDFunc func(osin.AccessData) (string, bool)) Option {
+	return func(s *Storage) {
+		s.userIDFunc = userIDFunc
+	}
+}
+
+func (s *Storage) userID(access *osin.AccessData) (string, bool) {
+	if s.userIDFunc == nil {
+		return "", false
+	}
+	return s.userIDFunc(*access)
+}
+
+// LoadAccessByUser returns the stored access data of the given user, as
+// indexed by the function passed to WithUserIDFunc. Index entries whose access
+// data has already expired are dropped from the index along the way.
+func (s *Storage) LoadAccessByUser(userID string) (_ []*osin.AccessData, err error) {
+	defer s.observe("LoadAccessByUser", time.Now(), &err)
+	s, span := s.startSpan("LoadAccessByUser", "SMEMBERS", "user_tokens")
+	defer span.end(&err)
+
+	return s.loadIndexedAccess(s.makeKey("user_tokens", userID))
+}
+
+// RemoveAllForUser revokes every access and refresh token of the given user,
+// as indexed by the function passed to WithUserIDFunc.
+func (s *Storage) RemoveAllForUser(userID string) (err error) {
+	defer s.observe("RemoveAllForUser", time.Now(), &err)
+	s, span := s.startSpan("RemoveAllForUser", "MULTI", "user_tokens")
+	defer span.end(&err)
+
+	_, err = s.removeIndexedTokens(s.makeKey("user_tokens", userID))
+	return err
+}
+
+// authorizeUserID returns the user ID of the authorize data, see
+// ListAuthorizeForUser.
+func (s *Storage) authorizeUserID(data *osin.AuthorizeData) (string, bool) {
+	if s.userIDFunc == nil {
+		return "", false
+	}
+	return s.userIDFunc(osin.AccessData{
+		Client:        data.Client,
+		AuthorizeData: data,
+		Scope:         data.Scope,
+		RedirectUri:   data.RedirectUri,
+		CreatedAt:     data.CreatedAt,
+		UserData:      data.UserData,
+	})
+}
+
+// sendIndexAuthorize queues adding the auth key of data to the authorize
+// index of its user, and returns how many commands it queued. The index holds
+// key names rather than codes, so it reveals no codes when WithTokenKeyHashing
+// is set. It has no expiry; entries of expired or removed codes are pruned by
+// ListAuthorizeForUser and PruneIndexes.
+func (s *Storage) sendIndexAuthorize(conn redis.Conn, data *osin.AuthorizeData) int {
+	userID, ok := s.authorizeUserID(data)
+	if !ok {
+		return 0
+	}
+	conn.Send("SADD", s.makeKey("user_auths", userID), s.tokenKey("auth", data.Code))
+	return 1
+}
+
+// ListAuthorizeForUser returns the outstanding authorize codes of the given
+// user, as indexed by the function passed to WithUserIDFunc when they were
+// saved, with their clients loaded. The function is called with access data
+// holding the authorize data as its AuthorizeData, and its client, scope,
+// redirect URI, creation time and UserData. Codes that expired or were removed or
+// redeemed are left out and dropped from the index along the way, and codes
+// whose client no longer exists are left out.
+func (s *Storage) ListAuthorizeForUser(userID string) (_ []*osin.AuthorizeData, err error) {
+	defer s.observe("ListAuthorizeForUser", time.Now(), &err)
+	s, span := s.startSpan("ListAuthorizeForUser", "SMEMBERS", "user_auths")
+	defer span.end(&err)
+
+	conn := s.getConn()
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	indexKey := s.makeKey("user_auths", userID)
+	keys, err := redis.Strings(conn.Do("SMEMBERS", indexKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read authorize index")
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	authGobs, err := redis.ByteSlices(conn.Do("MGET", redis.Args{}.AddFlat(keys)...))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get auth gobs")
+	}
+
+	var (
+		auths     []*osin.AuthorizeData
+		clientIDs []string
+		stale     []interface{}
+	)
+	for i, authGob := range authGobs {
+		if authGob == nil {
+			stale = append(stale, keys[i])
+			continue
+		}
+		var auth osin.AuthorizeData
+		if err := s.decodeRecord(s.redactTokenKey("auth", keys[i]), authGob, &auth); err != nil {
+			s.logger.Log(LevelWarn, "failed to decode auth", "namespace", "auth", "err", err)
+			return nil, errors.Wrap(err, "failed to decode auth")
+		}
+		auths = append(auths, &auth)
+		if auth.Client != nil {
+			clientIDs = append(clientIDs, auth.Client.GetId())
+		}
+	}
+
+	clients, err := s.getClients(conn, clientIDs)
+	if err != nil {
+		return nil, err
+	}
+	listed := auths[:0]
+	for _, auth := range auths {
+		if auth.Client != nil {
+			client, ok := clients[auth.Client.GetId()]
+			if !ok {
+				continue
+			}
+			auth.Client = client
+		}
+		listed = append(listed, auth)
+	}
+
+	if len(stale) > 0 {
+		if _, err := conn.Do("SREM", append([]interface{}{indexKey}, stale...)...); err != nil {
+			return nil, errors.Wrap(err, "failed to prune authorize index")
+		}
+	}
+	return listed, nil
+}
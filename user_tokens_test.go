@@ -0,0 +1,151 @@
+package osinredis
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/openshift/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func usernameOf(access osin.AccessData) (string, bool) {
+	userData, ok := access.UserData.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	username, ok := userData["username"].(string)
+	return username, ok
+}
+
+func newUserAccessData(client *osin.DefaultClient, token, username string) *osin.AccessData {
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.AccessToken = token
+	accessData.RefreshToken = "refresh-" + token
+	if username != "" {
+		accessData.UserData = map[string]interface{}{"username": username}
+	}
+	return accessData
+}
+
+func TestLoadAccessByUser(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithUserIDFunc(usernameOf))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	assert.NoError(t, storage.SaveAccess(newUserAccessData(client, "a", "jdoe")))
+	assert.NoError(t, storage.SaveAccess(newUserAccessData(client, "b", "jdoe")))
+	assert.NoError(t, storage.SaveAccess(newUserAccessData(client, "c", "other")))
+	assert.NoError(t, storage.SaveAccess(newUserAccessData(client, "d", "")))
+
+	accesses, err := storage.LoadAccessByUser("jdoe")
+	assert.NoError(t, err)
+	var tokens []string
+	for _, access := range accesses {
+		tokens = append(tokens, access.AccessToken)
+	}
+	assert.ElementsMatch(t, []string{"a", "b"}, tokens)
+
+	assert.NoError(t, storage.RemoveAccess("a"))
+	accesses, err = storage.LoadAccessByUser("jdoe")
+	assert.NoError(t, err)
+	if assert.Len(t, accesses, 1) {
+		assert.Equal(t, "b", accesses[0].AccessToken)
+	}
+
+	accesses, err = storage.LoadAccessByUser("nobody")
+	assert.NoError(t, err)
+	assert.Empty(t, accesses)
+}
+
+func TestRemoveAllForUser(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithUserIDFunc(usernameOf))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	assert.NoError(t, storage.SaveAccess(newUserAccessData(client, "a", "jdoe")))
+	assert.NoError(t, storage.SaveAccess(newUserAccessData(client, "b", "jdoe")))
+	assert.NoError(t, storage.SaveAccess(newUserAccessData(client, "c", "other")))
+
+	assert.NoError(t, storage.RemoveAllForUser("jdoe"))
+
+	_, err := storage.LoadAccess("a")
+	assert.Error(t, err)
+	_, err = storage.LoadRefresh("refresh-b")
+	assert.Error(t, err)
+	_, err = storage.LoadAccess("c")
+	assert.NoError(t, err)
+
+	conn := pool.Get()
+	defer conn.Close()
+	members, err := redis.Strings(conn.Do("SMEMBERS", storage.makeKey("client_tokens", client.GetId())))
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+
+	// Purging the client also drops its tokens from the user index.
+	_, err = storage.PurgeClient(client.GetId())
+	assert.NoError(t, err)
+	exists, err := redis.Bool(conn.Do("EXISTS", storage.makeKey("user_tokens", "other")))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestListAuthorizeForUser(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithUserIDFunc(usernameOf))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	for _, grant := range []struct{ code, username string }{
+		{"a", "jdoe"}, {"b", "jdoe"}, {"c", "other"}, {"d", ""},
+	} {
+		auth := newAuthorizeData(client)
+		auth.Code = grant.code
+		if grant.username != "" {
+			auth.UserData = map[string]interface{}{"username": grant.username}
+		}
+		assert.NoError(t, storage.SaveAuthorize(auth))
+	}
+	nx := newAuthorizeData(client)
+	nx.Code = "e"
+	nx.UserData = map[string]interface{}{"username": "jdoe"}
+	assert.NoError(t, storage.SaveAuthorizeNX(nx))
+
+	codesOf := func(auths []*osin.AuthorizeData) []string {
+		var codes []string
+		for _, auth := range auths {
+			assert.Equal(t, client, auth.Client)
+			codes = append(codes, auth.Code)
+		}
+		return codes
+	}
+
+	auths, err := storage.ListAuthorizeForUser("jdoe")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "e"}, codesOf(auths))
+
+	assert.NoError(t, storage.RemoveAuthorize("a"))
+	conn := pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("DEL", "test123:auth:e")
+	assert.NoError(t, err)
+
+	auths, err = storage.ListAuthorizeForUser("jdoe")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, codesOf(auths))
+	indexed, err := redis.Strings(conn.Do("SMEMBERS", "test123:user_auths:jdoe"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test123:auth:b"}, indexed)
+
+	auths, err = storage.ListAuthorizeForUser("nobody")
+	assert.NoError(t, err)
+	assert.Empty(t, auths)
+
+	auths, err = initTestStorage().ListAuthorizeForUser("other")
+	assert.NoError(t, err)
+	assert.Len(t, auths, 1)
+}
@@ -0,0 +1,44 @@
+package osinredis
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	assert.NoError(t, storage.SaveAuthorize(newAuthorizeData(client)))
+	assert.NoError(t, storage.SaveAccess(newAccessData(newAuthorizeData(client))))
+
+	report, err := storage.Verify()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"client": 1, "auth": 1, "access": 1}, report.Checked)
+	assert.Empty(t, report.Failures)
+
+	conn := pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("SET", "test123:client:corrupt", []byte{schemaVersion1, 0xde, 0xad})
+	assert.NoError(t, err)
+	_, err = conn.Do("SET", "test123:auth:secret-code", []byte{schemaVersion1, 0xde, 0xad})
+	assert.NoError(t, err)
+
+	report, err = storage.Verify()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"client": 2, "auth": 2, "access": 1}, report.Checked)
+	if assert.Len(t, report.Failures, 2) {
+		var keys []string
+		for _, failure := range report.Failures {
+			keys = append(keys, failure.Key)
+			var decodeErr *DecodeError
+			assert.True(t, errors.As(failure.Err, &decodeErr))
+			assert.NotContains(t, failure.Err.Error(), "secret-code")
+		}
+		assert.ElementsMatch(t, []string{"test123:client:corrupt", storage.tokenLogKey("auth", "secret-code")}, keys)
+	}
+}